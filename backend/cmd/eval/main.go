@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tanaymehhta/self/backend/internal/database"
+	"github.com/tanaymehhta/self/backend/internal/eval"
+	"github.com/tanaymehhta/self/backend/internal/services"
+	"github.com/tanaymehhta/self/backend/pkg/config"
+	"github.com/tanaymehhta/self/backend/pkg/logger"
+)
+
+// Offline retrieval evaluation.
+//
+//	go run ./cmd/eval -golden internal/eval/golden.example.yaml -strategies hybrid,vector -k 10 -out report.json
+//	go run ./cmd/eval -golden internal/eval/golden.example.yaml -baseline report.json
+//
+// Query vectors come from the deterministic embedder unless -live-embeddings is set,
+// so two runs over the same database produce the same rankings.
+func main() {
+	goldenPath := flag.String("golden", "", "path to golden set (.json, .yaml or .yml)")
+	strategies := flag.String("strategies", strings.Join(services.SearchStrategies, ","), "comma-separated search strategies to evaluate")
+	k := flag.Int("k", 10, "cutoff for recall@k and nDCG@k")
+	outPath := flag.String("out", "", "write the full JSON report to this file")
+	baselinePath := flag.String("baseline", "", "compare against a previous JSON report")
+	liveEmbeddings := flag.Bool("live-embeddings", false, "embed queries with the configured OpenAI model instead of the deterministic embedder")
+	flag.Parse()
+
+	if *goldenPath == "" {
+		fmt.Fprintln(os.Stderr, "-golden is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	set, err := eval.LoadGoldenSet(*goldenPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load golden set: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg := config.Load()
+	log := logger.New(cfg)
+
+	db, err := database.NewSupabaseConnection(cfg, log)
+	if err != nil {
+		log.LogError(err, "Failed to connect to database")
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	runner := eval.NewRunner(db.DB, *k)
+	if *liveEmbeddings {
+		runner.SetSearchService(services.NewSearchService(db.DB, nil))
+	}
+
+	var reports []*eval.Report
+	for _, strategy := range strings.Split(*strategies, ",") {
+		strategy = strings.TrimSpace(strategy)
+		if strategy == "" {
+			continue
+		}
+
+		report, err := runner.Run(set, strategy)
+		if err != nil {
+			log.LogError(err, "Evaluation failed", "strategy", strategy)
+			os.Exit(1)
+		}
+		reports = append(reports, report)
+	}
+
+	var baseline map[string]*eval.Report
+	if *baselinePath != "" {
+		baseline, err = loadBaseline(*baselinePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load baseline: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	printSummary(set, reports, baseline)
+
+	if *outPath != "" {
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode report: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(*outPath, data, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nReport written to %s\n", *outPath)
+	}
+}
+
+// loadBaseline reads a report written with -out, keyed by strategy
+func loadBaseline(path string) (map[string]*eval.Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*eval.Report
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, err
+	}
+
+	byStrategy := make(map[string]*eval.Report, len(reports))
+	for _, report := range reports {
+		byStrategy[report.Strategy] = report
+	}
+	return byStrategy, nil
+}
+
+func printSummary(set *eval.GoldenSet, reports []*eval.Report, baseline map[string]*eval.Report) {
+	fmt.Printf("Golden set: %s (%d queries)\n\n", set.Name, len(set.Queries))
+	fmt.Printf("%-10s %10s %10s %10s %8s\n", "strategy", "recall@k", "mrr", "ndcg@k", "failed")
+
+	for _, report := range reports {
+		fmt.Printf("%-10s %10.4f %10.4f %10.4f %8d\n",
+			report.Strategy, report.Recall, report.MRR, report.NDCG, report.Failed)
+
+		if base, ok := baseline[report.Strategy]; ok {
+			fmt.Printf("%-10s %+10.4f %+10.4f %+10.4f\n",
+				"  vs base", report.Recall-base.Recall, report.MRR-base.MRR, report.NDCG-base.NDCG)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package api
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -9,8 +15,12 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/tanaymehhta/self/backend/internal/auth"
+	"github.com/tanaymehhta/self/backend/internal/cache"
+	"github.com/tanaymehhta/self/backend/internal/eval"
 	"github.com/tanaymehhta/self/backend/internal/middleware"
 	"github.com/tanaymehhta/self/backend/internal/models"
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+	"github.com/tanaymehhta/self/backend/internal/resilient"
 	"github.com/tanaymehhta/self/backend/internal/services"
 )
 
@@ -390,8 +400,16 @@ func (s *Server) uploadDocumentHandler(c *fiber.Ctx) error {
 	// Get user ID from auth context
 	userID := c.Locals("user_id").(uuid.UUID)
 
+	if exceeded := s.quotaExceeded(userID, services.UsageFeatureIngestion); exceeded != nil {
+		return quotaExceededResponse(c, exceeded)
+	}
+
 	// Process document
 	textPipeline := services.NewTextPipeline(s.db.DB)
+	textPipeline.SetUsageScope(s.usage.Scope(&userID, services.UsageFeatureIngestion))
+	savedSearches := services.NewSavedSearchService(s.db.DB, s.insightHub)
+	savedSearches.SetUsageScope(s.usage.Scope(&userID, services.UsageFeatureSavedSearch))
+	textPipeline.SetSavedSearchService(savedSearches)
 	contentItem, err := textPipeline.ProcessDocument(userID, fileContent, file)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -427,8 +445,13 @@ func (s *Server) searchHandler(c *fiber.Ctx) error {
 		req.Limit = 10
 	}
 
+	userID, _ := middleware.GetUserID(c)
+	started := time.Now()
+
 	// Create search service (old chunk-based search)
 	searchService := services.NewSearchService(s.db.DB, nil) // nil for backward compatibility
+	searchService.SetQueryEmbeddingCache(s.embeddingCache)
+	searchService.SetUsageScope(s.usage.Scope(&userID, services.UsageFeatureSearch))
 	results, err := searchService.Search(req.Query, req.Limit)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -437,11 +460,21 @@ func (s *Server) searchHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	// Analytics are best-effort, a failed write shouldn't fail the search
+	var searchID *uuid.UUID
+	analytics := services.NewSearchAnalyticsService(s.db.DB)
+	if record, err := analytics.RecordSearch(userID, "search", req.Query, results, started); err != nil {
+		s.logger.LogError(err, "Failed to record search analytics")
+	} else {
+		searchID = &record.ID
+	}
+
 	return c.JSON(fiber.Map{
-		"query":   req.Query,
-		"results": results.Results,
-		"total":   len(results.Results),
-		"strategy": results.Strategy,
+		"query":     req.Query,
+		"results":   results.Results,
+		"total":     len(results.Results),
+		"strategy":  results.Strategy,
+		"search_id": searchID,
 	})
 }
 
@@ -450,6 +483,7 @@ func (s *Server) qaSearchHandler(c *fiber.Ctx) error {
 	var req struct {
 		Query string `json:"query" validate:"required"`
 		Limit int    `json:"limit"`
+		Model string `json:"model"` // Optional per-request model, e.g. "openai:gpt-4o-mini"
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -463,24 +497,23 @@ func (s *Server) qaSearchHandler(c *fiber.Ctx) error {
 		req.Limit = 5 // Fewer answers than chunks by default
 	}
 
-	// Create Claude client using config
-	if s.config.ClaudeAPIKey == "" {
-		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
-			"error":   "service_unavailable",
-			"message": "Claude API key not configured",
-		})
+	userID, _ := middleware.GetUserID(c)
+	if exceeded := s.quotaExceeded(userID, services.UsageFeatureQA); exceeded != nil {
+		return quotaExceededResponse(c, exceeded)
 	}
 
-	claudeClient := services.NewClaudeClient(s.config.ClaudeAPIKey, "claude-3-haiku-20240307")
-
-	// Create answer extraction service
-	answerService := services.NewAnswerExtractionService(claudeClient)
-
 	// Create search service with answer extraction
-	searchService := services.NewSearchService(s.db.DB, answerService)
+	searchService, err := s.newQASearchService(req.Model)
+	if err != nil {
+		return s.llmUnavailable(c, err)
+	}
+	searchService.SetFeedback(services.NewFeedbackService(s.db.DB), userID)
 
-	// Perform QA search
-	results, err := searchService.QASearch(c.Context(), req.Query, req.Limit)
+	started := time.Now()
+
+	// Perform QA search, attributing LLM and embedding usage to the user
+	ctx := services.WithUsageScope(c.Context(), s.usage.Scope(&userID, services.UsageFeatureQA))
+	results, err := searchService.QASearch(ctx, req.Query, req.Limit)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "qa_search_failed",
@@ -488,14 +521,182 @@ func (s *Server) qaSearchHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	var searchID *uuid.UUID
+	analytics := services.NewSearchAnalyticsService(s.db.DB)
+	if record, err := analytics.RecordQASearch(userID, "qa", req.Query, results, started); err != nil {
+		s.logger.LogError(err, "Failed to record QA search analytics")
+	} else {
+		searchID = &record.ID
+	}
+
 	return c.JSON(fiber.Map{
-		"query":    req.Query,
-		"answers":  results.Answers,
-		"total":    results.Total,
-		"strategy": results.Strategy,
+		"query":     req.Query,
+		"answers":   results.Answers,
+		"total":     results.Total,
+		"strategy":  results.Strategy,
+		"partial":   results.Partial,
+		"search_id": searchID,
 	})
 }
 
+// Search feedback handler - records a click or helpful/not helpful vote on a result
+func (s *Server) searchFeedbackHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	searchID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid search ID",
+		})
+	}
+
+	var req struct {
+		ResultID string `json:"result_id" validate:"required"`
+		Position *int   `json:"position"`
+		Action   string `json:"action"`
+	}
+
+	if err := c.BodyParser(&req); err != nil || req.ResultID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+
+	if req.Action == "" {
+		req.Action = "click"
+	}
+
+	analytics := services.NewSearchAnalyticsService(s.db.DB)
+	interaction, err := analytics.RecordInteraction(userID, searchID, req.ResultID, req.Action, req.Position)
+	if errors.Is(err, services.ErrSearchQueryNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Search not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "feedback_failed",
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(interaction)
+}
+
+// QA answer feedback handler - thumbs up/down and an optional correction on one
+// answer of a QA search, identified by its chunk_id
+func (s *Server) searchAnswerFeedbackHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	searchID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid search ID",
+		})
+	}
+
+	var req services.FeedbackInput
+	if err := c.BodyParser(&req); err != nil || req.ChunkID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body: rating and chunk_id are required",
+		})
+	}
+
+	feedback, err := services.NewFeedbackService(s.db.DB).RateSearchResult(userID, searchID, req)
+	if err != nil {
+		return feedbackError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(feedback)
+}
+
+// Feedback export handler - the user's ratings as an eval dataset: every rating
+// as JSON lines (format=jsonl, the default) or a golden set for cmd/eval
+// (format=golden). days limits it to recent feedback.
+func (s *Server) feedbackExportHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	format := c.Query("format", "jsonl")
+	days := c.QueryInt("days", 0)
+
+	if format != "jsonl" && format != "golden" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "format must be jsonl or golden",
+		})
+	}
+	if days < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "days must not be negative",
+		})
+	}
+
+	var since time.Time
+	if days > 0 {
+		since = time.Now().AddDate(0, 0, -days)
+	}
+
+	feedback, err := services.NewFeedbackService(s.db.DB).Dataset(userID, since)
+	if err != nil {
+		s.logger.LogError(err, "Failed to export feedback")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to export feedback",
+		})
+	}
+
+	if format == "golden" {
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="feedback-golden.json"`)
+		return c.JSON(eval.GoldenSetFromFeedback("feedback", feedback))
+	}
+
+	var body strings.Builder
+	for _, item := range feedback {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="feedback.jsonl"`)
+	return c.SendString(body.String())
+}
+
+// Search analytics handler - top queries, zero-result queries and latency per strategy
+func (s *Server) searchAnalyticsHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	days := c.QueryInt("days", 7)
+	limit := c.QueryInt("limit", 20)
+
+	if days <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "days must be positive",
+		})
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+
+	analytics := services.NewSearchAnalyticsService(s.db.DB)
+	report, err := analytics.Report(userID, since, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to build search analytics",
+		})
+	}
+
+	return c.JSON(report)
+}
+
 // Get content items handler
 func (s *Server) getContentItemsHandler(c *fiber.Ctx) error {
 	contentType := c.Query("type", "") // filter by type
@@ -551,6 +752,110 @@ func (s *Server) getContentItemHandler(c *fiber.Ctx) error {
 	})
 }
 
+// Delete content item handler - removes a document with its chunks and embeddings
+func (s *Server) deleteContentItemHandler(c *fiber.Ctx) error {
+	itemID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid content item ID",
+		})
+	}
+
+	userID, _ := middleware.GetUserID(c)
+
+	// Answers extracted from the item's chunks must not outlive them
+	answerService := services.NewAnswerExtractionService(nil)
+	answerService.SetCache(s.answerCache)
+
+	textPipeline := services.NewTextPipeline(s.db.DB)
+	textPipeline.SetAnswerService(answerService)
+
+	err = textPipeline.DeleteContentItem(c.Context(), userID, itemID)
+	if errors.Is(err, services.ErrContentItemNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Content item not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "delete_failed",
+			"message": "Failed to delete content item",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Similar documents handler - "more like this" for a content item
+func (s *Server) similarDocumentsHandler(c *fiber.Ctx) error {
+	itemID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid content item ID",
+		})
+	}
+	limit := c.QueryInt("limit", 10)
+
+	searchService := services.NewSearchService(s.db.DB, nil)
+	results, err := searchService.SimilarDocuments(itemID.String(), limit)
+	if errors.Is(err, services.ErrNoSourceEmbeddings) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Content item not found or not yet embedded",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "search_failed",
+			"message": fmt.Sprintf("Similar documents search failed: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"source_id": itemID,
+		"results":   results,
+		"total":     len(results),
+		"strategy":  "document-centroid",
+	})
+}
+
+// Similar chunks handler - nearest passages from other documents
+func (s *Server) similarChunksHandler(c *fiber.Ctx) error {
+	chunkID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid chunk ID",
+		})
+	}
+	limit := c.QueryInt("limit", 10)
+
+	searchService := services.NewSearchService(s.db.DB, nil)
+	results, err := searchService.SimilarChunks(chunkID.String(), limit)
+	if errors.Is(err, services.ErrNoSourceEmbeddings) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Chunk not found or not yet embedded",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "search_failed",
+			"message": fmt.Sprintf("Similar chunks search failed: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"source_id": chunkID,
+		"results":   results,
+		"total":     len(results),
+		"strategy":  "vector",
+	})
+}
+
 // Test Pipeline handler - uploads document with detailed step logging
 func (s *Server) testPipelineHandler(c *fiber.Ctx) error {
 	// Get file from form
@@ -584,11 +889,19 @@ func (s *Server) testPipelineHandler(c *fiber.Ctx) error {
 	// Get user ID from auth context
 	userID := c.Locals("user_id").(uuid.UUID)
 
+	if exceeded := s.quotaExceeded(userID, services.UsageFeatureIngestion); exceeded != nil {
+		return quotaExceededResponse(c, exceeded)
+	}
+
 	// Create pipeline logger for detailed tracking
 	logger := services.NewPipelineLogger()
 
 	// Process document with detailed logging
 	textPipeline := services.NewTextPipeline(s.db.DB)
+	textPipeline.SetUsageScope(s.usage.Scope(&userID, services.UsageFeatureIngestion))
+	savedSearches := services.NewSavedSearchService(s.db.DB, s.insightHub)
+	savedSearches.SetUsageScope(s.usage.Scope(&userID, services.UsageFeatureSavedSearch))
+	textPipeline.SetSavedSearchService(savedSearches)
 	contentItem, err := textPipeline.ProcessDocumentWithLogging(userID, fileContent, file, logger)
 
 	// Always return the pipeline logs, even if processing failed
@@ -664,98 +977,288 @@ func (s *Server) deleteEntityHandler(c *fiber.Ctx) error {
 
 // Insight handlers
 func (s *Server) getInsightsHandler(c *fiber.Ctx) error {
-	// TODO: Implement insights retrieval
+	userID := c.Locals("user_id").(uuid.UUID)
+	status := c.Query("status", "") // filter by status
+	limit := c.QueryInt("limit", 50)
+
+	insights := []services.Insight{}
+	query := s.db.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status <> ?", "dismissed")
+	}
+
+	if err := query.Find(&insights).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to fetch insights",
+		})
+	}
+
 	return c.JSON(fiber.Map{
-		"message": "Get insights endpoint - TODO: Implement",
+		"insights": insights,
+		"total":    len(insights),
 	})
 }
 
 func (s *Server) acknowledgeInsightHandler(c *fiber.Ctx) error {
-	// TODO: Implement insight acknowledgment
-	return c.JSON(fiber.Map{
-		"message": "Acknowledge insight endpoint - TODO: Implement",
-	})
+	return s.setInsightStatus(c, "acknowledged")
 }
 
 func (s *Server) dismissInsightHandler(c *fiber.Ctx) error {
-	// TODO: Implement insight dismissal
-	return c.JSON(fiber.Map{
-		"message": "Dismiss insight endpoint - TODO: Implement",
-	})
+	return s.setInsightStatus(c, "dismissed")
 }
 
-// Integration handlers
-func (s *Server) getIntegrationsHandler(c *fiber.Ctx) error {
-	// TODO: Implement integrations retrieval
-	return c.JSON(fiber.Map{
-		"message": "Get integrations endpoint - TODO: Implement",
-	})
-}
+func (s *Server) setInsightStatus(c *fiber.Ctx, status string) error {
+	userID := c.Locals("user_id").(uuid.UUID)
 
-func (s *Server) connectIntegrationHandler(c *fiber.Ctx) error {
-	// TODO: Implement integration connection
-	return c.JSON(fiber.Map{
-		"message": "Connect integration endpoint - TODO: Implement OAuth flow",
-	})
-}
+	insightID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid insight ID",
+		})
+	}
+
+	result := s.db.DB.Model(&services.Insight{}).
+		Where("id = ? AND user_id = ?", insightID, userID).
+		Updates(map[string]interface{}{"status": status, "updated_at": time.Now()})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "update_failed",
+			"message": "Failed to update insight",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Insight not found",
+		})
+	}
 
-func (s *Server) disconnectIntegrationHandler(c *fiber.Ctx) error {
-	// TODO: Implement integration disconnection
 	return c.JSON(fiber.Map{
-		"message": "Disconnect integration endpoint - TODO: Implement",
+		"id":     insightID,
+		"status": status,
 	})
 }
 
-func (s *Server) syncIntegrationHandler(c *fiber.Ctx) error {
-	// TODO: Implement integration sync
+// Saved search handlers
+func (s *Server) getSavedSearchesHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	savedSearchService := services.NewSavedSearchService(s.db.DB, s.insightHub)
+	savedSearches, err := savedSearchService.List(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to fetch saved searches",
+		})
+	}
+
 	return c.JSON(fiber.Map{
-		"message": "Sync integration endpoint - TODO: Implement",
+		"saved_searches": savedSearches,
+		"total":          len(savedSearches),
 	})
 }
 
-// WebSocket handlers
-func (s *Server) transcriptionWebSocketHandler(c *websocket.Conn) {
-	// TODO: Implement real-time transcription WebSocket
-	defer c.Close()
-
-	for {
-		// Read message from client
-		messageType, msg, err := c.ReadMessage()
-		if err != nil {
-			s.logger.LogError(err, "WebSocket read error")
-			break
-		}
-
-		s.logger.Debug("WebSocket message received", "type", messageType, "message", string(msg))
+func (s *Server) createSavedSearchHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
 
-		// Echo back for now - TODO: Implement real transcription streaming
-		if err := c.WriteMessage(messageType, []byte("Transcription WebSocket - TODO: Implement real-time processing")); err != nil {
-			s.logger.LogError(err, "WebSocket write error")
-			break
-		}
+	var req struct {
+		Name         string   `json:"name"`
+		Query        string   `json:"query" validate:"required"`
+		ContentTypes []string `json:"content_types"`
+		Threshold    float64  `json:"threshold"`
 	}
-}
 
-func (s *Server) insightsWebSocketHandler(c *websocket.Conn) {
-	// TODO: Implement real-time insights WebSocket
-	defer c.Close()
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+
+	savedSearchService := services.NewSavedSearchService(s.db.DB, s.insightHub)
+	savedSearch, err := savedSearchService.Create(userID, req.Name, req.Query, req.ContentTypes, req.Threshold)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "creation_failed",
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(savedSearch)
+}
+
+func (s *Server) updateSavedSearchHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	savedSearchID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid saved search ID",
+		})
+	}
+
+	var req struct {
+		Name      *string  `json:"name"`
+		Threshold *float64 `json:"threshold"`
+		IsActive  *bool    `json:"is_active"`
+	}
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+
+	savedSearchService := services.NewSavedSearchService(s.db.DB, s.insightHub)
+	savedSearch, err := savedSearchService.Update(userID, savedSearchID, req.Name, req.Threshold, req.IsActive)
+	if errors.Is(err, services.ErrSavedSearchNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Saved search not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "update_failed",
+			"message": err.Error(),
+		})
+	}
+
+	return c.JSON(savedSearch)
+}
+
+func (s *Server) deleteSavedSearchHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	savedSearchID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid saved search ID",
+		})
+	}
+
+	savedSearchService := services.NewSavedSearchService(s.db.DB, s.insightHub)
+	err = savedSearchService.Delete(userID, savedSearchID)
+	if errors.Is(err, services.ErrSavedSearchNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Saved search not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "delete_failed",
+			"message": "Failed to delete saved search",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Integration handlers
+func (s *Server) getIntegrationsHandler(c *fiber.Ctx) error {
+	// TODO: Implement integrations retrieval
+	return c.JSON(fiber.Map{
+		"message": "Get integrations endpoint - TODO: Implement",
+	})
+}
+
+func (s *Server) connectIntegrationHandler(c *fiber.Ctx) error {
+	// TODO: Implement integration connection
+	return c.JSON(fiber.Map{
+		"message": "Connect integration endpoint - TODO: Implement OAuth flow",
+	})
+}
+
+func (s *Server) disconnectIntegrationHandler(c *fiber.Ctx) error {
+	// TODO: Implement integration disconnection
+	return c.JSON(fiber.Map{
+		"message": "Disconnect integration endpoint - TODO: Implement",
+	})
+}
+
+func (s *Server) syncIntegrationHandler(c *fiber.Ctx) error {
+	// TODO: Implement integration sync
+	return c.JSON(fiber.Map{
+		"message": "Sync integration endpoint - TODO: Implement",
+	})
+}
+
+// WebSocket handlers
+func (s *Server) transcriptionWebSocketHandler(c *websocket.Conn) {
+	// TODO: Implement real-time transcription WebSocket
+	defer c.Close()
 
 	for {
+		// Read message from client
 		messageType, msg, err := c.ReadMessage()
 		if err != nil {
-			s.logger.LogError(err, "Insights WebSocket read error")
+			s.logger.LogError(err, "WebSocket read error")
 			break
 		}
 
-		s.logger.Debug("Insights WebSocket message", "type", messageType, "message", string(msg))
+		s.logger.Debug("WebSocket message received", "type", messageType, "message", string(msg))
 
-		if err := c.WriteMessage(messageType, []byte("Insights WebSocket - TODO: Implement real-time insights")); err != nil {
-			s.logger.LogError(err, "Insights WebSocket write error")
+		// Echo back for now - TODO: Implement real transcription streaming
+		if err := c.WriteMessage(messageType, []byte("Transcription WebSocket - TODO: Implement real-time processing")); err != nil {
+			s.logger.LogError(err, "WebSocket write error")
 			break
 		}
 	}
 }
 
+func (s *Server) insightsWebSocketHandler(c *websocket.Conn) {
+	defer c.Close()
+
+	// Browsers can't set headers on WebSocket upgrades, so the access token comes as ?token=
+	claims, err := s.jwtManager.ValidateAccessToken(c.Query("token"))
+	if err != nil {
+		c.WriteJSON(fiber.Map{"error": "unauthorized", "message": "Invalid or expired token"})
+		return
+	}
+	userID, err := auth.GetUserIDFromClaims(claims)
+	if err != nil {
+		c.WriteJSON(fiber.Map{"error": "unauthorized", "message": "Invalid user ID in token"})
+		return
+	}
+
+	insights, unsubscribe := s.insightHub.Subscribe(userID)
+	defer unsubscribe()
+
+	// Reads only serve to notice the client going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case insight, ok := <-insights:
+			if !ok {
+				return
+			}
+			if err := c.WriteJSON(insight); err != nil {
+				s.logger.LogError(err, "Insights WebSocket write error")
+				return
+			}
+		}
+	}
+}
+
 // ============================================================================
 // CHAT HANDLERS
 // ============================================================================
@@ -806,17 +1309,22 @@ func (s *Server) sendChatMessageHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create search service instance
-	searchService := services.NewSearchService(
-		s.db.DB,
-		services.NewAnswerExtractionService(services.NewClaudeClient(s.config.ClaudeAPIKey, "claude-3-haiku-20240307")),
-	)
+	if exceeded := s.quotaExceeded(userID, services.UsageFeatureChat); exceeded != nil {
+		return quotaExceededResponse(c, exceeded)
+	}
 
 	// Create chat service
-	chatService := services.NewChatService(s.db.DB, searchService)
+	chatService, err := s.newChatService(req.Model)
+	if err != nil {
+		return s.llmUnavailable(c, err)
+	}
 
-	// Process the message
-	response, err := chatService.ProcessMessage(c.Context(), userID, req)
+	// Process the message, attributing LLM and embedding usage to the user
+	ctx := services.WithUsageScope(c.Context(), s.usage.Scope(&userID, services.UsageFeatureChat))
+	response, err := chatService.ProcessMessage(ctx, userID, req)
+	if errors.Is(err, services.ErrDocumentNotFound) {
+		return chatDocumentsError(c, err)
+	}
 	if err != nil {
 		s.logger.LogError(err, "Failed to process chat message")
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -828,66 +1336,846 @@ func (s *Server) sendChatMessageHandler(c *fiber.Ctx) error {
 	return c.JSON(response)
 }
 
-// Get chat conversations for user
-func (s *Server) getChatConversationsHandler(c *fiber.Ctx) error {
+// List the documents a chat conversation is scoped to
+func (s *Server) getChatDocumentsHandler(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(uuid.UUID)
-	limit := c.QueryInt("limit", 20)
 
-	// Create search service instance
-	searchService := services.NewSearchService(
-		s.db.DB,
-		services.NewAnswerExtractionService(services.NewClaudeClient(s.config.ClaudeAPIKey, "claude-3-haiku-20240307")),
-	)
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+
+	chatService := services.NewChatService(s.db.DB, nil)
+	documents, err := chatService.ConversationDocuments(userID, conversationID)
+	if err != nil {
+		return chatDocumentsError(c, err)
+	}
 
-	// Create chat service
-	chatService := services.NewChatService(s.db.DB, searchService)
+	return c.JSON(fiber.Map{
+		"documents": documents,
+		"total":     len(documents),
+	})
+}
 
-	conversations, err := chatService.GetConversations(userID, limit)
+// Attach documents to a chat conversation; retrieval is then limited to them
+func (s *Server) attachChatDocumentsHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "database_error",
-			"message": "Failed to fetch conversations",
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+
+	var req struct {
+		DocumentIDs []uuid.UUID `json:"document_ids"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+	if len(req.DocumentIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "document_ids cannot be empty",
 		})
 	}
 
+	chatService := services.NewChatService(s.db.DB, nil)
+	documents, err := chatService.AttachDocuments(userID, conversationID, req.DocumentIDs)
+	if err != nil {
+		return chatDocumentsError(c, err)
+	}
+
 	return c.JSON(fiber.Map{
-		"conversations": conversations,
-		"total":         len(conversations),
+		"documents": documents,
+		"total":     len(documents),
 	})
 }
 
-// Get messages for a chat conversation
-func (s *Server) getChatMessagesHandler(c *fiber.Ctx) error {
+// Detach a document from a chat conversation
+func (s *Server) detachChatDocumentHandler(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(uuid.UUID)
 
-	conversationIDStr := c.Params("id")
-	conversationID, err := uuid.Parse(conversationIDStr)
+	conversationID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "invalid_request",
 			"message": "Invalid conversation ID",
 		})
 	}
+	documentID, err := uuid.Parse(c.Params("documentId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid document ID",
+		})
+	}
+
+	chatService := services.NewChatService(s.db.DB, nil)
+	if err := chatService.DetachDocument(userID, conversationID, documentID); err != nil {
+		return chatDocumentsError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// chatDocumentsError maps conversation document errors to responses
+func chatDocumentsError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrConversationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Conversation not found",
+		})
+	case errors.Is(err, services.ErrDocumentNotFound), errors.Is(err, services.ErrDocumentNotAttached):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "database_error",
+		"message": "Failed to update conversation documents",
+	})
+}
+
+// Send a chat message and stream the response as server-sent events:
+// retrieval_started, sources, token (repeated), then done with the saved
+// message and, for a new conversation, title; or error
+func (s *Server) streamChatMessageHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req services.ChatRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+
+	if req.Message == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Message cannot be empty",
+		})
+	}
 
-	// Create search service instance
-	searchService := services.NewSearchService(
-		s.db.DB,
-		services.NewAnswerExtractionService(services.NewClaudeClient(s.config.ClaudeAPIKey, "claude-3-haiku-20240307")),
-	)
+	if exceeded := s.quotaExceeded(userID, services.UsageFeatureChat); exceeded != nil {
+		return quotaExceededResponse(c, exceeded)
+	}
 
 	// Create chat service
-	chatService := services.NewChatService(s.db.DB, searchService)
+	chatService, err := s.newChatService(req.Model)
+	if err != nil {
+		return s.llmUnavailable(c, err)
+	}
 
-	messages, err := chatService.GetConversationMessages(userID, conversationID)
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+
+	// The writer runs after the handler returns, when c is no longer valid, so
+	// the request gets its own context. The message is saved even if the
+	// client disconnects part way.
+	ctx := services.WithUsageScope(context.Background(), s.usage.Scope(&userID, services.UsageFeatureChat))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		_, err := chatService.StreamMessage(ctx, userID, req, func(event services.ChatEvent) error {
+			return writeServerSentEvent(w, event.Type, event.Data)
+		})
+		if err != nil {
+			s.logger.LogError(err, "Failed to process streamed chat message")
+		}
+	})
+	return nil
+}
+
+// writeServerSentEvent writes one event and flushes it to the client
+func writeServerSentEvent(w *bufio.Writer, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Get chat conversations for user, pinned first then most recently active.
+// archived=true|false|all (default false), pinned=true|false, limit and cursor
+// page through the list; next_cursor is omitted on the last page.
+func (s *Server) getChatConversationsHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	filter := services.ConversationFilter{
+		Limit:  c.QueryInt("limit", 20),
+		Cursor: c.Query("cursor"),
+	}
+
+	switch archived := c.Query("archived", "false"); archived {
+	case "all":
+	case "true", "false":
+		value := archived == "true"
+		filter.Archived = &value
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "archived must be true, false or all",
+		})
+	}
+
+	if pinned := c.Query("pinned"); pinned != "" {
+		value, err := strconv.ParseBool(pinned)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "invalid_request",
+				"message": "pinned must be true or false",
+			})
+		}
+		filter.Pinned = &value
+	}
+
+	// Create chat service (no LLM needed to read history)
+	chatService := services.NewChatService(s.db.DB, nil)
+
+	page, err := chatService.GetConversations(userID, filter)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid cursor",
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "database_error",
-			"message": "Failed to fetch messages",
+			"message": "Failed to fetch conversations",
 		})
 	}
 
 	return c.JSON(fiber.Map{
-		"messages": messages,
-		"total":    len(messages),
+		"conversations": page.Conversations,
+		"total":         len(page.Conversations),
+		"next_cursor":   page.NextCursor,
 	})
-}
\ No newline at end of file
+}
+
+// Rename, pin or archive a chat conversation
+func (s *Server) updateChatConversationHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+
+	var update services.ConversationUpdate
+	if err := c.BodyParser(&update); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+	if update.Title == nil && update.Pinned == nil && update.Archived == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Nothing to update: set title, pinned or archived",
+		})
+	}
+
+	chatService := services.NewChatService(s.db.DB, nil)
+	conversation, err := chatService.UpdateConversation(userID, conversationID, update)
+	switch {
+	case errors.Is(err, services.ErrConversationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Conversation not found",
+		})
+	case errors.Is(err, services.ErrTitleTooLong):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": err.Error(),
+		})
+	case err != nil:
+		s.logger.LogError(err, "Failed to update chat conversation")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to update conversation",
+		})
+	}
+
+	return c.JSON(conversation)
+}
+
+// Delete a chat conversation with its messages and document links
+func (s *Server) deleteChatConversationHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+
+	chatService := services.NewChatService(s.db.DB, nil)
+	err = chatService.DeleteConversation(userID, conversationID)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Conversation not found",
+		})
+	}
+	if err != nil {
+		s.logger.LogError(err, "Failed to delete chat conversation")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to delete conversation",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Full-text search across the user's chat messages
+func (s *Server) searchChatMessagesHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Query parameter q is required",
+		})
+	}
+
+	chatService := services.NewChatService(s.db.DB, nil)
+	matches, err := chatService.SearchMessages(userID, query, c.QueryInt("limit", 20))
+	if err != nil {
+		s.logger.LogError(err, "Failed to search chat messages")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to search messages",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"query":   query,
+		"results": matches,
+		"total":   len(matches),
+	})
+}
+
+// Get messages for a chat conversation
+func (s *Server) getChatMessagesHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationIDStr := c.Params("id")
+	conversationID, err := uuid.Parse(conversationIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+
+	// Create chat service (no LLM needed to read history)
+	chatService := services.NewChatService(s.db.DB, nil)
+
+	messages, err := chatService.GetConversationMessages(userID, conversationID)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return chatBranchError(c, err)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to fetch messages",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"messages": messages,
+		"total":    len(messages),
+	})
+}
+
+// Export a chat conversation's active branch as Markdown, JSON or printable
+// HTML (format=md|json|html), with numbered citations and their sources
+func (s *Server) exportChatConversationHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+
+	format := c.Query("format", services.ExportFormatMarkdown)
+
+	chatService := services.NewChatService(s.db.DB, nil)
+	export, err := chatService.ExportConversation(userID, conversationID)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return chatBranchError(c, err)
+	}
+	if err != nil {
+		s.logger.LogError(err, "Failed to export chat conversation")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to export conversation",
+		})
+	}
+
+	body, contentType, err := export.Render(format)
+	if errors.Is(err, services.ErrUnknownExportFormat) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		s.logger.LogError(err, "Failed to render chat export")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "export_failed",
+			"message": "Failed to render conversation",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, export.FileName(format)))
+	return c.Send(body)
+}
+
+// Regenerate an assistant reply, optionally with another model or top_k. The
+// new reply is added beside the old one and becomes the active branch.
+func (s *Server) regenerateChatMessageHandler(c *fiber.Ctx) error {
+	return s.branchChatMessage(c, false)
+}
+
+// Edit a user message and answer the edited question on a new branch
+func (s *Server) editChatMessageHandler(c *fiber.Ctx) error {
+	return s.branchChatMessage(c, true)
+}
+
+// branchChatMessage regenerates the reply to, or edits, the message in the URL
+func (s *Server) branchChatMessage(c *fiber.Ctx, edit bool) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+	messageID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid message ID",
+		})
+	}
+
+	var req services.ChatRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "invalid_request",
+				"message": "Invalid request body",
+			})
+		}
+	}
+	if edit && strings.TrimSpace(req.Message) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Message cannot be empty",
+		})
+	}
+
+	if exceeded := s.quotaExceeded(userID, services.UsageFeatureChat); exceeded != nil {
+		return quotaExceededResponse(c, exceeded)
+	}
+
+	chatService, err := s.newChatService(req.Model)
+	if err != nil {
+		return s.llmUnavailable(c, err)
+	}
+
+	ctx := services.WithUsageScope(c.Context(), s.usage.Scope(&userID, services.UsageFeatureChat))
+	var response *services.ChatResponse
+	if edit {
+		response, err = chatService.EditMessage(ctx, userID, conversationID, messageID, req)
+	} else {
+		response, err = chatService.RegenerateMessage(ctx, userID, conversationID, messageID, req)
+	}
+	if err != nil {
+		return chatBranchError(c, err)
+	}
+
+	return c.JSON(response)
+}
+
+// Select which branch of a chat conversation is shown and followed by new
+// messages: the one through message_id, down its most recent replies
+func (s *Server) selectChatBranchHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+
+	var req struct {
+		MessageID uuid.UUID `json:"message_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.MessageID == uuid.Nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "message_id is required",
+		})
+	}
+
+	chatService := services.NewChatService(s.db.DB, nil)
+	messages, err := chatService.SelectBranch(userID, conversationID, req.MessageID)
+	if err != nil {
+		return chatBranchError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"messages": messages,
+		"total":    len(messages),
+	})
+}
+
+// Chat feedback handler - thumbs up/down and an optional correction on an
+// assistant reply, or with chunk_id on one of its sources
+func (s *Server) chatMessageFeedbackHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid conversation ID",
+		})
+	}
+	messageID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid message ID",
+		})
+	}
+
+	var req services.FeedbackInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+
+	feedback, err := services.NewFeedbackService(s.db.DB).RateMessage(userID, conversationID, messageID, req)
+	if err != nil {
+		return feedbackError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(feedback)
+}
+
+// feedbackError maps feedback errors to responses
+func feedbackError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrConversationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Conversation not found",
+		})
+	case errors.Is(err, services.ErrSearchQueryNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Search not found",
+		})
+	case errors.Is(err, services.ErrMessageNotFound), errors.Is(err, services.ErrSourceNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidRating), errors.Is(err, services.ErrNotRateable):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "feedback_failed",
+		"message": "Failed to save feedback",
+	})
+}
+
+// chatBranchError maps message tree errors to responses
+func chatBranchError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrConversationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": "Conversation not found",
+		})
+	case errors.Is(err, services.ErrMessageNotFound), errors.Is(err, services.ErrDocumentNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "not_found",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrNotAssistantMessage), errors.Is(err, services.ErrNotUserMessage):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "processing_failed",
+		"message": fmt.Sprintf("Failed to process message: %v", err),
+	})
+}
+
+// newQASearchService builds a search service with answer extraction from the
+// extraction role (or the given model) and the shared caches
+func (s *Server) newQASearchService(model string) (*services.SearchService, error) {
+	llmClient, err := s.llm.Client(services.LLMRoleExtraction, model)
+	if err != nil {
+		return nil, err
+	}
+
+	answerService := services.NewAnswerExtractionService(llmClient)
+	answerService.SetCache(s.answerCache)
+
+	options := services.DefaultExtractionOptions()
+	options.Concurrency = s.config.ExtractionConcurrency
+	options.Timeout = s.config.ExtractionTimeout
+	options.Mode = s.config.ExtractionMode
+	options.BatchTokenBudget = s.config.ExtractionBatchTokens
+	answerService.SetExtractionOptions(options)
+
+	searchService := services.NewSearchService(s.db.DB, answerService)
+	searchService.SetQueryEmbeddingCache(s.embeddingCache)
+	searchService.SetRankingWeights(s.rankingWeights)
+	return searchService, nil
+}
+
+// newChatService builds a chat service with QA search and answer synthesis.
+// A per-request model overrides both the extraction and synthesis roles.
+func (s *Server) newChatService(model string) (*services.ChatService, error) {
+	searchService, err := s.newQASearchService(model)
+	if err != nil {
+		return nil, err
+	}
+
+	synthesisClient, err := s.llm.Client(services.LLMRoleSynthesis, model)
+	if err != nil {
+		return nil, err
+	}
+
+	chatService := services.NewChatService(s.db.DB, searchService)
+	chatService.SetSynthesisService(services.NewSynthesisService(synthesisClient))
+
+	// Follow-ups are rewritten heuristically when no rewriting model is configured
+	if rewritingClient, err := s.llm.Client(services.LLMRoleRewriting, ""); err == nil {
+		chatService.SetQueryRewriter(services.NewQueryRewriter(rewritingClient))
+	}
+	// Likewise titles and summaries
+	if titlingClient, err := s.llm.Client(services.LLMRoleTitling, ""); err == nil {
+		chatService.SetConversationGenerator(titlingClient)
+	}
+	return chatService, nil
+}
+
+// llmUnavailable maps model-selection errors to a response
+func (s *Server) llmUnavailable(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrUnknownModel) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_model",
+			"message": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error":   "service_unavailable",
+		"message": fmt.Sprintf("No LLM provider available: %v", err),
+	})
+}
+
+// quotaExceeded checks the user's quota for a feature. A failed lookup is logged
+// and lets the request through rather than taking AI features down with it.
+func (s *Server) quotaExceeded(userID uuid.UUID, feature string) *services.QuotaExceededError {
+	err := s.quotas.Check(userID, feature)
+	if err == nil {
+		return nil
+	}
+
+	var exceeded *services.QuotaExceededError
+	if errors.As(err, &exceeded) {
+		return exceeded
+	}
+	s.logger.LogError(err, "Failed to check quota")
+	return nil
+}
+
+// quotaExceededResponse tells the client which limit was hit and when it resets
+func quotaExceededResponse(c *fiber.Ctx, exceeded *services.QuotaExceededError) error {
+	c.Set("Retry-After", strconv.Itoa(int(time.Until(exceeded.ResetAt).Seconds())+1))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":    "quota_exceeded",
+		"message":  exceeded.Error(),
+		"limit":    exceeded.Limit,
+		"used":     exceeded.Used,
+		"max":      exceeded.Max,
+		"reset_at": exceeded.ResetAt,
+	})
+}
+
+// LLM models handler - configured fallback order per model role
+func (s *Server) llmModelsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"roles": s.llm.Roles(),
+	})
+}
+
+// LLM prompts handler - every prompt template version and its A/B weight
+func (s *Server) llmPromptsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"templates": prompts.Default().List(),
+	})
+}
+
+// Cache metrics handler - hit rates for the embedding and answer caches
+func (s *Server) cacheMetricsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"backend": s.config.CacheBackend,
+		"caches": []cache.Stats{
+			s.embeddingCache.Stats(),
+			s.answerCache.Stats(),
+		},
+	})
+}
+
+// Structured output metrics handler - LLM responses parsed, repaired or failed per provider
+func (s *Server) structuredOutputMetricsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"providers": services.StructuredOutputMetrics(),
+	})
+}
+
+// LLM transport metrics handler - retries and circuit breaker state per provider
+func (s *Server) llmTransportMetricsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"transports": resilient.AllStats(),
+	})
+}
+
+// User usage handler - the caller's LLM tokens and cost over the last ?days=30
+func (s *Server) getUserUsageHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	days := c.QueryInt("days", 30)
+	if days <= 0 {
+		days = 30
+	}
+
+	report, err := s.usage.UserReport(userID, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		s.logger.LogError(err, "Failed to build usage report")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to fetch usage",
+		})
+	}
+
+	return c.JSON(report)
+}
+
+// User quota handler - the caller's plan and consumption of each limit
+func (s *Server) getUserQuotaHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	status, err := s.quotas.Status(userID)
+	if err != nil {
+		s.logger.LogError(err, "Failed to build quota status")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to fetch quota",
+		})
+	}
+
+	return c.JSON(status)
+}
+
+// Admin usage handler - usage across all users with the top ?limit= spenders
+func (s *Server) adminUsageHandler(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days <= 0 {
+		days = 30
+	}
+	limit := c.QueryInt("limit", 20)
+
+	report, err := s.usage.AdminReport(time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		s.logger.LogError(err, "Failed to build admin usage report")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to fetch usage",
+		})
+	}
+
+	return c.JSON(report)
+}
+
+// Set user quota handler - assigns a plan and optional per-limit overrides
+func (s *Server) setUserQuotaHandler(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_id",
+			"message": "Invalid user ID",
+		})
+	}
+
+	var quota services.UserQuota
+	if err := c.BodyParser(&quota); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid_request",
+			"message": "Invalid request body",
+		})
+	}
+	quota.UserID = userID
+
+	saved, err := s.quotas.SetUserQuota(quota)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownQuotaPlan) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "invalid_plan",
+				"message": err.Error(),
+			})
+		}
+		s.logger.LogError(err, "Failed to set user quota")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "database_error",
+			"message": "Failed to save quota",
+		})
+	}
+
+	return c.JSON(saved)
+}
@@ -5,8 +5,12 @@ import (
 	"github.com/gofiber/websocket/v2"
 
 	"github.com/tanaymehhta/self/backend/internal/auth"
+	"github.com/tanaymehhta/self/backend/internal/cache"
 	"github.com/tanaymehhta/self/backend/internal/database"
 	"github.com/tanaymehhta/self/backend/internal/middleware"
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+	"github.com/tanaymehhta/self/backend/internal/resilient"
+	"github.com/tanaymehhta/self/backend/internal/services"
 	"github.com/tanaymehhta/self/backend/pkg/config"
 	"github.com/tanaymehhta/self/backend/pkg/logger"
 )
@@ -18,6 +22,15 @@ type Server struct {
 	logger     *logger.Logger
 	jwtManager *auth.JWTManager
 	auth       *middleware.AuthMiddleware
+	insightHub *services.InsightHub
+
+	// Shared across requests; services are still built per request
+	embeddingCache *cache.Cache
+	answerCache    *cache.Cache
+	llm            *services.LLMRegistry
+	usage          *services.UsageService
+	quotas         *services.QuotaService
+	rankingWeights services.RankingWeights
 }
 
 func NewServer(
@@ -42,7 +55,13 @@ func NewServer(
 		logger:     logger,
 		jwtManager: jwtManager,
 		auth:       authMiddleware,
+		insightHub: services.NewInsightHub(),
 	}
+	server.setupCaches()
+	server.setupLLMRegistry()
+	server.setupUsage()
+	server.setupPrompts()
+	server.setupRanking()
 
 	server.setupMiddleware()
 	server.setupRoutes()
@@ -50,6 +69,95 @@ func NewServer(
 	return server
 }
 
+// setupCaches builds the query-embedding cache (always in-process) and the answer
+// cache, which uses Redis when CACHE_BACKEND=redis and falls back to memory if
+// Redis is unreachable
+func (s *Server) setupCaches() {
+	s.embeddingCache = cache.New("query_embeddings", cache.NewMemoryStore(s.config.EmbeddingCacheSize), 0)
+
+	var answerStore cache.Store = cache.NewMemoryStore(s.config.AnswerCacheSize)
+	if s.config.CacheBackend == "redis" {
+		redisStore, err := cache.NewRedisStore(s.config.RedisURL)
+		if err != nil {
+			s.logger.LogError(err, "Failed to connect to Redis, using in-memory answer cache")
+		} else {
+			answerStore = redisStore
+		}
+	}
+	s.answerCache = cache.New("answers", answerStore, s.config.AnswerCacheTTL)
+}
+
+// setupLLMRegistry registers every provider that has credentials (or, for the
+// local provider, an endpoint) and the configured model roles
+func (s *Server) setupLLMRegistry() {
+	transportConfig := resilient.DefaultConfig()
+	transportConfig.MaxRetries = s.config.LLMMaxRetries
+	transportConfig.MaxConcurrent = s.config.LLMMaxConcurrency
+	resilient.SetDefaultConfig(transportConfig)
+
+	var providers []services.LLMProviderConfig
+	if s.config.ClaudeAPIKey != "" {
+		providers = append(providers, services.LLMProviderConfig{Name: "claude", Kind: "anthropic", BaseURL: s.config.ClaudeBaseURL, APIKey: s.config.ClaudeAPIKey})
+	}
+	if s.config.OpenAIAPIKey != "" {
+		providers = append(providers, services.LLMProviderConfig{Name: "openai", Kind: "openai", BaseURL: s.config.OpenAIBaseURL, APIKey: s.config.OpenAIAPIKey})
+	}
+	if s.config.LocalLLMBaseURL != "" {
+		providers = append(providers, services.LLMProviderConfig{Name: "local", Kind: "openai", BaseURL: s.config.LocalLLMBaseURL, APIKey: s.config.LocalLLMAPIKey})
+	}
+
+	registry, err := services.NewLLMRegistry(providers, map[string]string{
+		services.LLMRoleExtraction: s.config.LLMExtractionModels,
+		services.LLMRoleSynthesis:  s.config.LLMSynthesisModels,
+		services.LLMRoleTitling:    s.config.LLMTitlingModels,
+		services.LLMRoleRewriting:  s.config.LLMRewritingModels,
+	})
+	if err != nil {
+		s.logger.LogError(err, "Invalid LLM configuration, LLM features are disabled")
+		registry, _ = services.NewLLMRegistry(nil, nil)
+	}
+	s.llm = registry
+}
+
+// setupUsage prices LLM calls and builds quota plans from the defaults overlaid
+// with LLM_PRICES and QUOTA_PLANS; an invalid override is logged and ignored
+func (s *Server) setupUsage() {
+	prices, err := services.ParsePriceTable(s.config.LLMPrices)
+	if err != nil {
+		s.logger.LogError(err, "Invalid LLM_PRICES, using default prices")
+	}
+	s.usage = services.NewUsageService(s.db.DB, prices)
+
+	plans, err := services.ParseQuotaPlans(s.config.QuotaPlans)
+	if err != nil {
+		s.logger.LogError(err, "Invalid QUOTA_PLANS, using default plans")
+	}
+	s.quotas = services.NewQuotaService(s.db.DB, plans, s.config.QuotaDefaultPlan)
+}
+
+// setupRanking reads the QA answer ranking weights; invalid weights are logged
+// and the defaults used
+func (s *Server) setupRanking() {
+	weights, err := services.ParseRankingWeights(s.config.RankingWeights)
+	if err != nil {
+		s.logger.LogError(err, "Invalid ANSWER_RANKING_WEIGHTS, using default weights")
+	}
+	s.rankingWeights = weights
+}
+
+// setupPrompts adds the prompt versions stored in the database to the embedded
+// ones; if they can't be loaded the embedded templates are used alone
+func (s *Server) setupPrompts() {
+	loaded, err := services.LoadPromptTemplates(s.db.DB, prompts.Default())
+	if err != nil {
+		s.logger.LogError(err, "Failed to load stored prompt templates, using embedded templates")
+		return
+	}
+	if loaded > 0 {
+		s.logger.Info("Loaded stored prompt templates", "count", loaded)
+	}
+}
+
 func (s *Server) setupMiddleware() {
 	// Global middleware
 	s.app.Use(middleware.NewCORS(s.config))
@@ -89,6 +197,8 @@ func (s *Server) setupProtectedRoutes(router fiber.Router) {
 	users.Get("/me", s.getUserProfileHandler)
 	users.Put("/me", s.updateUserProfileHandler)
 	users.Delete("/me", s.deleteUserHandler)
+	users.Get("/me/usage", s.getUserUsageHandler)
+	users.Get("/me/quota", s.getUserQuotaHandler)
 
 	// Text processing routes
 	text := router.Group("/text")
@@ -97,6 +207,9 @@ func (s *Server) setupProtectedRoutes(router fiber.Router) {
 	text.Post("/search", s.searchHandler)
 	text.Get("/items", s.getContentItemsHandler)
 	text.Get("/items/:id", s.getContentItemHandler)
+	text.Delete("/items/:id", s.deleteContentItemHandler)
+	text.Get("/items/:id/similar", s.similarDocumentsHandler)
+	text.Get("/chunks/:id/similar", s.similarChunksHandler)
 
 	// Conversation routes
 	conversations := router.Group("/conversations")
@@ -124,6 +237,31 @@ func (s *Server) setupProtectedRoutes(router fiber.Router) {
 	search.Get("/", s.searchHandler)
 	search.Post("/qa", s.qaSearchHandler) // New QA-based search endpoint
 	search.Post("/semantic", s.semanticSearchHandler)
+	search.Get("/analytics", s.searchAnalyticsHandler)
+	search.Post("/:id/feedback", s.searchFeedbackHandler)
+	search.Post("/:id/answers/feedback", s.searchAnswerFeedbackHandler)
+	search.Get("/saved", s.getSavedSearchesHandler)
+	search.Post("/saved", s.createSavedSearchHandler)
+	search.Patch("/saved/:id", s.updateSavedSearchHandler)
+	search.Delete("/saved/:id", s.deleteSavedSearchHandler)
+
+	// Feedback routes
+	feedback := router.Group("/feedback")
+	feedback.Get("/export", s.feedbackExportHandler)
+
+	// LLM routes
+	llm := router.Group("/llm")
+	llm.Get("/models", s.llmModelsHandler)
+
+	// Admin routes
+	admin := router.Group("/admin", s.auth.RequireAdmin(s.config.AdminEmails))
+	admin.Get("/usage", s.adminUsageHandler)
+	admin.Put("/quotas/:userId", s.setUserQuotaHandler)
+	// Process-wide provider state and every stored prompt template
+	admin.Get("/metrics/cache", s.cacheMetricsHandler)
+	admin.Get("/metrics/structured-output", s.structuredOutputMetricsHandler)
+	admin.Get("/metrics/llm-transport", s.llmTransportMetricsHandler)
+	admin.Get("/llm/prompts", s.llmPromptsHandler)
 
 	// Entity routes
 	entities := router.Group("/entities")
@@ -150,8 +288,20 @@ func (s *Server) setupProtectedRoutes(router fiber.Router) {
 	chat := router.Group("/chat")
 	chat.Post("/conversations", s.createChatConversationHandler)
 	chat.Post("/conversations/:id/message", s.sendChatMessageHandler)
+	chat.Post("/conversations/:id/message/stream", s.streamChatMessageHandler)
 	chat.Get("/conversations", s.getChatConversationsHandler)
+	chat.Patch("/conversations/:id", s.updateChatConversationHandler)
+	chat.Delete("/conversations/:id", s.deleteChatConversationHandler)
+	chat.Get("/search", s.searchChatMessagesHandler)
 	chat.Get("/conversations/:id/messages", s.getChatMessagesHandler)
+	chat.Get("/conversations/:id/export", s.exportChatConversationHandler)
+	chat.Post("/conversations/:id/messages/:messageId/regenerate", s.regenerateChatMessageHandler)
+	chat.Post("/conversations/:id/messages/:messageId/edit", s.editChatMessageHandler)
+	chat.Put("/conversations/:id/branch", s.selectChatBranchHandler)
+	chat.Post("/conversations/:id/messages/:messageId/feedback", s.chatMessageFeedbackHandler)
+	chat.Get("/conversations/:id/documents", s.getChatDocumentsHandler)
+	chat.Post("/conversations/:id/documents", s.attachChatDocumentsHandler)
+	chat.Delete("/conversations/:id/documents/:documentId", s.detachChatDocumentHandler)
 }
 
 func (s *Server) setupWebSocketRoutes() {
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Store is a byte-oriented cache backend (in-memory LRU or Redis)
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+
+	// Tag records that key (stored with ttl) belongs to tag, so InvalidateTag
+	// can drop it later. The record shouldn't outlive the entry.
+	Tag(ctx context.Context, tag, key string, ttl time.Duration) error
+	InvalidateTag(ctx context.Context, tag string) error
+}
+
+// Cache is a named, namespaced view over a Store that tracks its hit rate
+type Cache struct {
+	name   string
+	store  Store
+	ttl    time.Duration
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+// Stats is a point-in-time snapshot of a cache's counters
+type Stats struct {
+	Name    string  `json:"name"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	Errors  int64   `json:"errors"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+func New(name string, store Store, ttl time.Duration) *Cache {
+	return &Cache{
+		name:  name,
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// GetJSON decodes a cached value into dest. Backend errors count as misses so
+// a flaky cache never fails the caller.
+func (c *Cache) GetJSON(ctx context.Context, key string, dest interface{}) bool {
+	data, ok, err := c.store.Get(ctx, c.namespaced(key))
+	if err != nil {
+		c.errors.Add(1)
+		c.misses.Add(1)
+		return false
+	}
+	if !ok || json.Unmarshal(data, dest) != nil {
+		c.misses.Add(1)
+		return false
+	}
+
+	c.hits.Add(1)
+	return true
+}
+
+// SetJSON stores value under key, optionally tagging it for invalidation
+func (c *Cache) SetJSON(ctx context.Context, key string, value interface{}, tags ...string) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		c.errors.Add(1)
+		return
+	}
+
+	fullKey := c.namespaced(key)
+	if err := c.store.Set(ctx, fullKey, data, c.ttl); err != nil {
+		c.errors.Add(1)
+		return
+	}
+
+	for _, tag := range tags {
+		if err := c.store.Tag(ctx, c.namespaced("tag:"+tag), fullKey, c.ttl); err != nil {
+			c.errors.Add(1)
+		}
+	}
+}
+
+// Invalidate drops every entry stored with the given tag
+func (c *Cache) Invalidate(ctx context.Context, tag string) error {
+	return c.store.InvalidateTag(ctx, c.namespaced("tag:"+tag))
+}
+
+// Stats returns the cache's counters and hit rate
+func (c *Cache) Stats() Stats {
+	hits := c.hits.Load()
+	misses := c.misses.Load()
+
+	stats := Stats{
+		Name:   c.name,
+		Hits:   hits,
+		Misses: misses,
+		Errors: c.errors.Load(),
+	}
+	if total := hits + misses; total > 0 {
+		stats.HitRate = float64(hits) / float64(total)
+	}
+	return stats
+}
+
+func (c *Cache) namespaced(key string) string {
+	return "self:" + c.name + ":" + key
+}
+
+// Key builds a fixed-length key from its parts, safe for any input text
+func Key(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process LRU store with per-entry expiry
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front = most recently used
+	tags     map[string]map[string]struct{}
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // zero = never
+	tags      []string  // So an evicted entry can be dropped from its tag sets
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+
+	return &MemoryStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		tags:     make(map[string]map[string]struct{}),
+	}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		m.removeElement(element)
+		return nil, false, nil
+	}
+
+	m.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (m *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if element, ok := m.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		m.order.MoveToFront(element)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, value: value, expiresAt: expiresAt})
+
+	// Evict least recently used entries
+	for m.order.Len() > m.capacity {
+		m.removeElement(m.order.Back())
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := m.entries[key]; ok {
+			m.removeElement(element)
+		}
+	}
+	return nil
+}
+
+// Tag ignores keys that are no longer stored; entries leave their tag sets
+// when evicted or expired, so the sets never outgrow the store
+func (m *MemoryStore) Tag(ctx context.Context, tag, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if _, tagged := m.tags[tag][key]; tagged {
+		return nil
+	}
+
+	if m.tags[tag] == nil {
+		m.tags[tag] = make(map[string]struct{})
+	}
+	m.tags[tag][key] = struct{}{}
+	entry := element.Value.(*memoryEntry)
+	entry.tags = append(entry.tags, tag)
+	return nil
+}
+
+func (m *MemoryStore) InvalidateTag(ctx context.Context, tag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.tags[tag] {
+		if element, ok := m.entries[key]; ok {
+			m.removeElement(element)
+		}
+	}
+	delete(m.tags, tag)
+	return nil
+}
+
+// removeElement drops an entry and its tag memberships
+func (m *MemoryStore) removeElement(element *list.Element) {
+	entry := element.Value.(*memoryEntry)
+	delete(m.entries, entry.key)
+	m.order.Remove(element)
+
+	for _, tag := range entry.tags {
+		delete(m.tags[tag], entry.key)
+		if len(m.tags[tag]) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+}
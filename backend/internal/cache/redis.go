@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps cache entries in Redis (or any RESP-compatible server),
+// so they survive restarts and are shared between API instances
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore accepts either a redis:// URL or a bare host:port as in Config.RedisURL
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	var options *redis.Options
+	if strings.Contains(redisURL, "://") {
+		parsed, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: %w", err)
+		}
+		options = parsed
+	} else {
+		options = &redis.Options{Addr: redisURL}
+	}
+
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	return &RedisStore{client: client}, nil
+}
+
+func (r *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (r *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (r *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.client.Del(ctx, keys...).Err()
+}
+
+// Tag adds key to the tag's set and, for expiring entries, pushes the set's
+// expiry out to the entry's, so the set goes once its newest member has
+func (r *RedisStore) Tag(ctx context.Context, tag, key string, ttl time.Duration) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, tag, key)
+		if ttl > 0 {
+			pipe.Expire(ctx, tag, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisStore) InvalidateTag(ctx context.Context, tag string) error {
+	keys, err := r.client.SMembers(ctx, tag).Result()
+	if err != nil {
+		return err
+	}
+	return r.client.Del(ctx, append(keys, tag)...).Err()
+}
+
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
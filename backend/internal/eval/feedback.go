@@ -0,0 +1,69 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanaymehhta/self/backend/internal/services"
+)
+
+// GoldenSetFromFeedback turns user feedback into a chunk-level golden set.
+// Feedback is grouped by query (ignoring case and spacing); chunks rated up, or
+// behind an answer rated up, are relevant unless a source rating for the same
+// query marked them down. Queries left with no relevant chunk are skipped.
+func GoldenSetFromFeedback(name string, feedback []services.AnswerFeedback) *GoldenSet {
+	type judged struct {
+		query    string
+		relevant []string
+		rated    map[string]string // Chunk ID -> rating from a source rating
+	}
+
+	var order []string
+	byQuery := make(map[string]*judged)
+	for _, item := range feedback {
+		key := strings.ToLower(strings.Join(strings.Fields(item.Query), " "))
+		if key == "" {
+			continue
+		}
+
+		entry, ok := byQuery[key]
+		if !ok {
+			entry = &judged{query: strings.TrimSpace(item.Query), rated: make(map[string]string)}
+			byQuery[key] = entry
+			order = append(order, key)
+		}
+
+		if item.ChunkID != "" {
+			entry.rated[item.ChunkID] = item.Rating
+		}
+		if item.Rating == services.FeedbackUp {
+			entry.relevant = append(entry.relevant, item.ChunkIDs...)
+		}
+	}
+
+	set := &GoldenSet{Name: name, Queries: []GoldenQuery{}}
+	for _, key := range order {
+		entry := byQuery[key]
+
+		var relevant []string
+		seen := make(map[string]bool)
+		for _, chunkID := range entry.relevant {
+			if chunkID == "" || seen[chunkID] || entry.rated[chunkID] == services.FeedbackDown {
+				continue
+			}
+			seen[chunkID] = true
+			relevant = append(relevant, chunkID)
+		}
+		if len(relevant) == 0 {
+			continue
+		}
+
+		set.Queries = append(set.Queries, GoldenQuery{
+			ID:               fmt.Sprintf("feedback-%d", len(set.Queries)+1),
+			Query:            entry.query,
+			RelevantChunkIDs: relevant,
+		})
+	}
+
+	return set
+}
@@ -0,0 +1,101 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GoldenSet is a named collection of queries with known relevant results
+type GoldenSet struct {
+	Name    string        `json:"name" yaml:"name"`
+	Queries []GoldenQuery `json:"queries" yaml:"queries"`
+}
+
+// GoldenQuery pairs a query with the chunks or documents that should be retrieved.
+// When RelevantChunkIDs is set the query is judged at chunk level, otherwise
+// retrieved chunks are mapped to their documents and judged against RelevantDocumentIDs.
+type GoldenQuery struct {
+	ID                  string   `json:"id" yaml:"id"`
+	Query               string   `json:"query" yaml:"query"`
+	RelevantChunkIDs    []string `json:"relevant_chunk_ids,omitempty" yaml:"relevant_chunk_ids,omitempty"`
+	RelevantDocumentIDs []string `json:"relevant_document_ids,omitempty" yaml:"relevant_document_ids,omitempty"`
+}
+
+// Level reports whether the query is judged on chunks or documents
+func (q GoldenQuery) Level() string {
+	if len(q.RelevantChunkIDs) > 0 {
+		return "chunk"
+	}
+	return "document"
+}
+
+// Relevant returns the expected IDs for the query's level
+func (q GoldenQuery) Relevant() []string {
+	if len(q.RelevantChunkIDs) > 0 {
+		return q.RelevantChunkIDs
+	}
+	return q.RelevantDocumentIDs
+}
+
+// LoadGoldenSet reads a golden set from a .json, .yaml or .yml file
+func LoadGoldenSet(path string) (*GoldenSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read golden set: %w", err)
+	}
+
+	var set GoldenSet
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &set)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &set)
+	default:
+		return nil, fmt.Errorf("unsupported golden set format %q (want .json, .yaml or .yml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse golden set: %w", err)
+	}
+
+	if set.Name == "" {
+		set.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	if err := set.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &set, nil
+}
+
+// Validate checks every query has text, a unique ID and at least one expected result
+func (g *GoldenSet) Validate() error {
+	if len(g.Queries) == 0 {
+		return fmt.Errorf("golden set %q has no queries", g.Name)
+	}
+
+	seen := make(map[string]bool)
+	for i, q := range g.Queries {
+		if q.ID == "" {
+			return fmt.Errorf("query %d has no id", i)
+		}
+		if seen[q.ID] {
+			return fmt.Errorf("duplicate query id %q", q.ID)
+		}
+		seen[q.ID] = true
+
+		if strings.TrimSpace(q.Query) == "" {
+			return fmt.Errorf("query %q has no text", q.ID)
+		}
+		if len(q.Relevant()) == 0 {
+			return fmt.Errorf("query %q has no relevant chunk or document ids", q.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package eval
+
+import "math"
+
+// RecallAtK is the fraction of relevant IDs found in the top k retrieved
+func RecallAtK(retrieved, relevant []string, k int) float64 {
+	if len(relevant) == 0 {
+		return 0
+	}
+
+	relevantSet := toSet(relevant)
+	found := 0
+	for _, id := range topK(retrieved, k) {
+		if relevantSet[id] {
+			found++
+			delete(relevantSet, id) // count each relevant ID once
+		}
+	}
+
+	return float64(found) / float64(len(relevant))
+}
+
+// ReciprocalRank is 1/rank of the first relevant result in the top k, or 0
+func ReciprocalRank(retrieved, relevant []string, k int) float64 {
+	relevantSet := toSet(relevant)
+	for i, id := range topK(retrieved, k) {
+		if relevantSet[id] {
+			return 1.0 / float64(i+1)
+		}
+	}
+	return 0
+}
+
+// NDCGAtK is normalised discounted cumulative gain with binary relevance
+func NDCGAtK(retrieved, relevant []string, k int) float64 {
+	if len(relevant) == 0 {
+		return 0
+	}
+
+	relevantSet := toSet(relevant)
+	dcg := 0.0
+	for i, id := range topK(retrieved, k) {
+		if relevantSet[id] {
+			dcg += 1.0 / math.Log2(float64(i+2))
+			delete(relevantSet, id)
+		}
+	}
+
+	// Ideal ranking puts every relevant ID first
+	ideal := len(relevant)
+	if k > 0 && ideal > k {
+		ideal = k
+	}
+	idcg := 0.0
+	for i := 0; i < ideal; i++ {
+		idcg += 1.0 / math.Log2(float64(i+2))
+	}
+
+	return dcg / idcg
+}
+
+func topK(ids []string, k int) []string {
+	if k > 0 && len(ids) > k {
+		return ids[:k]
+	}
+	return ids
+}
+
+func toSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
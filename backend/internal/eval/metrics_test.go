@@ -0,0 +1,96 @@
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+const metricTolerance = 1e-6
+
+func TestRecallAtK(t *testing.T) {
+	tests := []struct {
+		name      string
+		retrieved []string
+		relevant  []string
+		k         int
+		want      float64
+	}{
+		{"all relevant in top k", []string{"a", "b", "c"}, []string{"a", "b"}, 3, 1},
+		{"one of three in top 2", []string{"a", "b", "c", "d"}, []string{"b", "d", "x"}, 2, 1.0 / 3},
+		{"two of three in top 4", []string{"a", "b", "c", "d"}, []string{"b", "d", "x"}, 4, 2.0 / 3},
+		{"k beyond results", []string{"a", "b", "c", "d"}, []string{"b", "d", "x"}, 10, 2.0 / 3},
+		{"k of zero uses all results", []string{"a", "b"}, []string{"b"}, 0, 1},
+		{"duplicate result counted once", []string{"b", "b"}, []string{"b", "c"}, 2, 0.5},
+		{"nothing relevant retrieved", []string{"a", "b"}, []string{"c"}, 2, 0},
+		{"no results", nil, []string{"a"}, 5, 0},
+		{"empty relevant set", []string{"a", "b"}, nil, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RecallAtK(tt.retrieved, tt.relevant, tt.k)
+			if math.Abs(got-tt.want) > metricTolerance {
+				t.Errorf("RecallAtK(%v, %v, %d) = %v, want %v", tt.retrieved, tt.relevant, tt.k, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReciprocalRank(t *testing.T) {
+	tests := []struct {
+		name      string
+		retrieved []string
+		relevant  []string
+		k         int
+		want      float64
+	}{
+		{"first result relevant", []string{"a", "b", "c"}, []string{"a"}, 3, 1},
+		{"first relevant at rank 3", []string{"a", "b", "c"}, []string{"c", "x"}, 3, 1.0 / 3},
+		{"earliest of several relevant", []string{"a", "b", "c"}, []string{"c", "b"}, 3, 0.5},
+		{"relevant outside top k", []string{"a", "b", "c"}, []string{"c"}, 2, 0},
+		{"k beyond results", []string{"a", "b", "c"}, []string{"c"}, 10, 1.0 / 3},
+		{"no results", nil, []string{"a"}, 5, 0},
+		{"empty relevant set", []string{"a", "b"}, nil, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ReciprocalRank(tt.retrieved, tt.relevant, tt.k)
+			if math.Abs(got-tt.want) > metricTolerance {
+				t.Errorf("ReciprocalRank(%v, %v, %d) = %v, want %v", tt.retrieved, tt.relevant, tt.k, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNDCGAtK(t *testing.T) {
+	// Discounts by rank: 1/log2(rank+1)
+	rank1, rank2, rank3 := 1.0, 1/math.Log2(3), 0.5
+
+	tests := []struct {
+		name      string
+		retrieved []string
+		relevant  []string
+		k         int
+		want      float64
+	}{
+		{"ideal ranking", []string{"a", "b", "c"}, []string{"a", "b"}, 3, 1},
+		{"relevant at ranks 2 and 3", []string{"a", "b", "c"}, []string{"b", "c"}, 3, (rank2 + rank3) / (rank1 + rank2)},
+		{"k beyond results", []string{"a", "b", "c"}, []string{"b", "c"}, 10, (rank2 + rank3) / (rank1 + rank2)},
+		{"ideal capped at k", []string{"a", "x"}, []string{"a", "b", "c"}, 2, rank1 / (rank1 + rank2)},
+		{"k of zero uses all results", []string{"x", "a"}, []string{"a"}, 0, rank2},
+		{"duplicate result counted once", []string{"a", "a"}, []string{"a", "b"}, 2, rank1 / (rank1 + rank2)},
+		{"relevant outside top k", []string{"a", "b", "c"}, []string{"c"}, 2, 0},
+		{"no results", nil, []string{"a"}, 5, 0},
+		{"empty relevant set", []string{"a", "b"}, nil, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NDCGAtK(tt.retrieved, tt.relevant, tt.k)
+			if math.Abs(got-tt.want) > metricTolerance {
+				t.Errorf("NDCGAtK(%v, %v, %d) = %v, want %v", tt.retrieved, tt.relevant, tt.k, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package eval
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/tanaymehhta/self/backend/internal/services"
+)
+
+// Runner evaluates retrieval strategies against a golden set
+type Runner struct {
+	db            *gorm.DB
+	searchService *services.SearchService
+	k             int
+}
+
+// QueryResult holds the metrics for a single golden query
+type QueryResult struct {
+	ID             string   `json:"id"`
+	Query          string   `json:"query"`
+	Level          string   `json:"level"` // "chunk" or "document"
+	Retrieved      []string `json:"retrieved"`
+	Relevant       []string `json:"relevant"`
+	Recall         float64  `json:"recall"`
+	ReciprocalRank float64  `json:"reciprocal_rank"`
+	NDCG           float64  `json:"ndcg"`
+	LatencyMs      int64    `json:"latency_ms"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// Report summarises one strategy over a golden set
+type Report struct {
+	GoldenSet string        `json:"golden_set"`
+	Strategy  string        `json:"strategy"`
+	K         int           `json:"k"`
+	Queries   int           `json:"queries"`
+	Failed    int           `json:"failed"`
+	Recall    float64       `json:"recall_at_k"`
+	MRR       float64       `json:"mrr"`
+	NDCG      float64       `json:"ndcg_at_k"`
+	Results   []QueryResult `json:"results"`
+}
+
+// NewRunner creates a runner that uses the deterministic embedder, so query
+// vectors never depend on a network call
+func NewRunner(db *gorm.DB, k int) *Runner {
+	searchService := services.NewSearchService(db, nil)
+	searchService.SetEmbeddingService(services.NewDeterministicEmbeddingService())
+
+	return &Runner{
+		db:            db,
+		searchService: searchService,
+		k:             k,
+	}
+}
+
+// SetSearchService overrides the search service, e.g. to evaluate with live embeddings
+func (r *Runner) SetSearchService(searchService *services.SearchService) {
+	r.searchService = searchService
+}
+
+// Run evaluates one strategy. Failed queries score zero and are counted in Failed.
+func (r *Runner) Run(set *GoldenSet, strategy string) (*Report, error) {
+	report := &Report{
+		GoldenSet: set.Name,
+		Strategy:  strategy,
+		K:         r.k,
+		Queries:   len(set.Queries),
+		Results:   make([]QueryResult, 0, len(set.Queries)),
+	}
+
+	for _, q := range set.Queries {
+		result := QueryResult{
+			ID:       q.ID,
+			Query:    q.Query,
+			Level:    q.Level(),
+			Relevant: q.Relevant(),
+		}
+
+		started := time.Now()
+		retrieved, err := r.retrieve(strategy, q)
+		result.LatencyMs = time.Since(started).Milliseconds()
+
+		if err != nil {
+			result.Error = err.Error()
+			report.Failed++
+		} else {
+			result.Retrieved = retrieved
+			result.Recall = RecallAtK(retrieved, result.Relevant, r.k)
+			result.ReciprocalRank = ReciprocalRank(retrieved, result.Relevant, r.k)
+			result.NDCG = NDCGAtK(retrieved, result.Relevant, r.k)
+		}
+
+		report.Recall += result.Recall
+		report.MRR += result.ReciprocalRank
+		report.NDCG += result.NDCG
+		report.Results = append(report.Results, result)
+	}
+
+	if report.Queries > 0 {
+		n := float64(report.Queries)
+		report.Recall /= n
+		report.MRR /= n
+		report.NDCG /= n
+	}
+
+	return report, nil
+}
+
+// retrieve returns ranked IDs at the query's judgement level
+func (r *Runner) retrieve(strategy string, q GoldenQuery) ([]string, error) {
+	results, err := r.searchService.SearchWithStrategy(strategy, q.Query, r.k)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkIDs := make([]string, 0, len(results.Results))
+	for _, result := range results.Results {
+		chunkIDs = append(chunkIDs, result.ID)
+	}
+
+	if q.Level() == "chunk" {
+		return chunkIDs, nil
+	}
+
+	return r.documentsForChunks(chunkIDs)
+}
+
+// documentsForChunks maps ranked chunk IDs to ranked, de-duplicated document IDs
+func (r *Runner) documentsForChunks(chunkIDs []string) ([]string, error) {
+	if len(chunkIDs) == 0 {
+		return []string{}, nil
+	}
+
+	var rows []struct {
+		ID            string
+		ContentItemID string
+	}
+	err := r.db.Table("chunks").
+		Select("id, content_item_id").
+		Where("id IN ?", chunkIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to map chunks to documents: %w", err)
+	}
+
+	documentOf := make(map[string]string, len(rows))
+	for _, row := range rows {
+		documentOf[row.ID] = row.ContentItemID
+	}
+
+	seen := make(map[string]bool)
+	documentIDs := make([]string, 0, len(chunkIDs))
+	for _, chunkID := range chunkIDs {
+		docID, ok := documentOf[chunkID]
+		if !ok || seen[docID] {
+			continue
+		}
+		seen[docID] = true
+		documentIDs = append(documentIDs, docID)
+	}
+
+	return documentIDs, nil
+}
@@ -0,0 +1,119 @@
+package llmfake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Interaction is one recorded request and the provider's reply
+type Interaction struct {
+	Key      string          `json:"key"`
+	API      string          `json:"api"`
+	Request  json.RawMessage `json:"request"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// Cassette stores interactions by request key. Files are indented JSON so
+// recordings review well in diffs.
+type Cassette struct {
+	mu           sync.Mutex
+	Interactions []Interaction `json:"interactions"`
+	byKey        map[string]int
+}
+
+func NewCassette() *Cassette {
+	return &Cassette{byKey: make(map[string]int)}
+}
+
+// LoadCassette reads a cassette file; a missing file is an empty cassette
+func LoadCassette(path string) (*Cassette, error) {
+	cassette := NewCassette()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cassette, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	for i, interaction := range cassette.Interactions {
+		cassette.byKey[interaction.Key] = i
+	}
+	return cassette, nil
+}
+
+// Find returns the interaction recorded for key
+func (c *Cassette) Find(key string) (Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i, ok := c.byKey[key]
+	if !ok {
+		return Interaction{}, false
+	}
+	return c.Interactions[i], true
+}
+
+// Add stores an interaction, replacing one with the same key
+func (c *Cassette) Add(interaction Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if i, ok := c.byKey[interaction.Key]; ok {
+		c.Interactions[i] = interaction
+		return
+	}
+	c.byKey[interaction.Key] = len(c.Interactions)
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+// Save writes the cassette, creating parent directories
+func (c *Cassette) Save(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// RequestKey identifies a request by API and body. Bodies are canonicalized
+// (object keys sorted, whitespace dropped) so field order doesn't matter.
+func RequestKey(api string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(api))
+	hash.Write([]byte{0})
+	hash.Write(canonicalJSON(body))
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func canonicalJSON(body []byte) json.RawMessage {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return body
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return canonical
+}
@@ -0,0 +1,84 @@
+package llmfake_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/tanaymehhta/self/backend/internal/llmfake"
+	"github.com/tanaymehhta/self/backend/internal/services"
+)
+
+// TestCassetteRecordAndReplay records extraction and embedding calls against
+// an upstream fake, then replays them with the upstream gone
+func TestCassetteRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qa.json")
+
+	upstream := newFake(t)
+	scriptExtraction(upstream)
+
+	recorder, err := llmfake.New(llmfake.Options{
+		Mode:              llmfake.ModeRecord,
+		CassettePath:      path,
+		AnthropicUpstream: upstream.URL(),
+		OpenAIUpstream:    upstream.URL(),
+	})
+	if err != nil {
+		t.Fatalf("failed to start recorder: %v", err)
+	}
+	recorded := runQASearch(t, recorder)
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("failed to save cassette: %v", err)
+	}
+
+	cassette, err := llmfake.LoadCassette(path)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+	// One embedding and two extractions
+	if got := len(cassette.Interactions); got != 3 {
+		t.Errorf("recorded interactions = %d, want 3", got)
+	}
+	upstreamCalls := len(upstream.Requests())
+	upstream.Close()
+
+	replayer, err := llmfake.New(llmfake.Options{Mode: llmfake.ModeReplay, CassettePath: path})
+	if err != nil {
+		t.Fatalf("failed to start replayer: %v", err)
+	}
+	defer replayer.Close()
+	replayed := runQASearch(t, replayer)
+
+	if len(replayed.Answers) != len(recorded.Answers) {
+		t.Fatalf("replayed %d answers, recorded %d", len(replayed.Answers), len(recorded.Answers))
+	}
+	for i := range recorded.Answers {
+		want, got := recorded.Answers[i], replayed.Answers[i]
+		if got.ChunkID != want.ChunkID || got.Answer != want.Answer || got.Confidence != want.Confidence {
+			t.Errorf("answer %d = %s %q (%.2f), recorded %s %q (%.2f)",
+				i, got.ChunkID, got.Answer, got.Confidence, want.ChunkID, want.Answer, want.Confidence)
+		}
+	}
+	if got := len(upstream.Requests()); got != upstreamCalls {
+		t.Errorf("upstream saw %d requests during replay", got-upstreamCalls)
+	}
+}
+
+func runQASearch(t *testing.T, fake *llmfake.Server) *services.QASearchResults {
+	t.Helper()
+	db, gormDB := newFakeDB(t)
+	scriptRetrieval(db)
+
+	registry := newRegistry(t, fake)
+	searchService := services.NewSearchService(gormDB, services.NewAnswerExtractionService(client(t, registry, services.LLMRoleExtraction)))
+	searchService.SetEmbeddingService(fake.EmbeddingService())
+
+	results, err := searchService.QASearch(context.Background(), testQuestion, 3)
+	if err != nil {
+		t.Fatalf("QASearch failed: %v", err)
+	}
+	if len(results.Answers) == 0 || results.Answers[0].Answer != "Paris" {
+		t.Fatalf("answers = %+v, want Paris first", results.Answers)
+	}
+	return results
+}
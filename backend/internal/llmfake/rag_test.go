@@ -0,0 +1,217 @@
+package llmfake_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/tanaymehhta/self/backend/internal/llmfake"
+	"github.com/tanaymehhta/self/backend/internal/services"
+)
+
+const (
+	testModel    = "claude-3-haiku-20240307"
+	testQuestion = "What is the capital of France?"
+	parisChunk   = "Paris is the capital of France and its largest city."
+	berlinChunk  = "Berlin is the capital of Germany."
+)
+
+var searchColumns = []string{"chunk_text", "chunk_span", "title", "content_type", "id", "id", "score"}
+
+// scriptRetrieval makes both vector and full-text search return the Paris and
+// Berlin chunks, Paris first
+func scriptRetrieval(db *fakeDB) {
+	paris := []driver.Value{parisChunk, []byte(`{"page": 1}`), "Atlas", "pdf", "chunk-paris", "doc-atlas"}
+	berlin := []driver.Value{berlinChunk, nil, "Atlas", "pdf", "chunk-berlin", "doc-atlas"}
+
+	db.OnQuery("AS distance", searchColumns, append(paris, 0.1), append(berlin, 0.4))
+	db.OnQuery("ts_rank", searchColumns, append(paris, 0.6), append(berlin, 0.2))
+}
+
+// scriptExtraction finds the answer in the Paris chunk only
+func scriptExtraction(fake *llmfake.Server) {
+	fake.On(llmfake.APIAnthropic, parisChunk,
+		`{"answer": "Paris", "confidence": 0.95, "has_answer": true, "reasoning": "Stated directly"}`)
+	fake.On(llmfake.APIAnthropic, "Extract the answer",
+		`{"answer": "", "confidence": 0.05, "has_answer": false, "reasoning": "Not about France"}`)
+}
+
+func newFake(t *testing.T) *llmfake.Server {
+	t.Helper()
+	fake, err := llmfake.New(llmfake.Options{})
+	if err != nil {
+		t.Fatalf("failed to start fake: %v", err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	return fake
+}
+
+// newRegistry serves every role from the fake's claude provider
+func newRegistry(t *testing.T, fake *llmfake.Server) *services.LLMRegistry {
+	t.Helper()
+	model := "claude:" + testModel
+	registry, err := services.NewLLMRegistry(fake.Providers(), map[string]string{
+		services.LLMRoleExtraction: model,
+		services.LLMRoleSynthesis:  model,
+		services.LLMRoleTitling:    model,
+	})
+	if err != nil {
+		t.Fatalf("failed to build registry: %v", err)
+	}
+	return registry
+}
+
+func client(t *testing.T, registry *services.LLMRegistry, role string) *services.FallbackClient {
+	t.Helper()
+	client, err := registry.Client(role, "")
+	if err != nil {
+		t.Fatalf("no %s client: %v", role, err)
+	}
+	return client
+}
+
+func requestsContaining(fake *llmfake.Server, api, contains string) int {
+	count := 0
+	for _, req := range fake.Requests() {
+		if req.API == api && (strings.Contains(req.System, contains) || strings.Contains(req.Prompt, contains)) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestQASearchAgainstFake(t *testing.T) {
+	fake := newFake(t)
+	scriptExtraction(fake)
+	db, gormDB := newFakeDB(t)
+	scriptRetrieval(db)
+
+	registry := newRegistry(t, fake)
+	searchService := services.NewSearchService(gormDB, services.NewAnswerExtractionService(client(t, registry, services.LLMRoleExtraction)))
+	searchService.SetEmbeddingService(fake.EmbeddingService())
+
+	results, err := searchService.QASearch(context.Background(), testQuestion, 3)
+	if err != nil {
+		t.Fatalf("QASearch failed: %v", err)
+	}
+
+	if len(results.Answers) == 0 {
+		t.Fatal("no answers")
+	}
+	top := results.Answers[0]
+	if !top.HasAnswer || top.Answer != "Paris" || top.ChunkID != "chunk-paris" {
+		t.Errorf("top answer = %+v, want Paris from chunk-paris", top)
+	}
+	if top.SourceTitle != "Atlas" || top.ContentItemID != "doc-atlas" {
+		t.Errorf("top answer source = %q (%s), want Atlas (doc-atlas)", top.SourceTitle, top.ContentItemID)
+	}
+	for _, answer := range results.Answers[1:] {
+		if answer.HasAnswer {
+			t.Errorf("unexpected answer %q from %s", answer.Answer, answer.ChunkID)
+		}
+	}
+	if results.Model != testModel {
+		t.Errorf("model = %q, want %q", results.Model, testModel)
+	}
+
+	if got := requestsContaining(fake, llmfake.APIOpenAIEmbedding, ""); got != 1 {
+		t.Errorf("embedding requests = %d, want 1", got)
+	}
+	if got := requestsContaining(fake, llmfake.APIAnthropic, "Extract the answer"); got != 2 {
+		t.Errorf("extraction requests = %d, want one per chunk (2)", got)
+	}
+}
+
+// newChatService wires a chat service to the fake the way the API does, with
+// synthesis and titling scripted ahead of extraction, since their prompts
+// quote the chunks too
+func newChatService(t *testing.T, fake *llmfake.Server) (*fakeDB, *services.ChatService) {
+	t.Helper()
+	fake.On(llmfake.APIAnthropic, "numbered sources", "Paris is the capital of France [1].")
+	fake.On(llmfake.APIAnthropic, "You name chat conversations", "Capital of France")
+	scriptExtraction(fake)
+	db, gormDB := newFakeDB(t)
+	scriptRetrieval(db)
+
+	registry := newRegistry(t, fake)
+	searchService := services.NewSearchService(gormDB, services.NewAnswerExtractionService(client(t, registry, services.LLMRoleExtraction)))
+	searchService.SetEmbeddingService(fake.EmbeddingService())
+
+	chatService := services.NewChatService(gormDB, searchService)
+	chatService.SetSynthesisService(services.NewSynthesisService(client(t, registry, services.LLMRoleSynthesis)))
+	chatService.SetConversationGenerator(client(t, registry, services.LLMRoleTitling))
+	return db, chatService
+}
+
+func TestProcessMessageAgainstFake(t *testing.T) {
+	fake := newFake(t)
+	db, chatService := newChatService(t, fake)
+
+	response, err := chatService.ProcessMessage(context.Background(), uuid.New(), services.ChatRequest{Message: testQuestion})
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+
+	if response.Response != "Paris is the capital of France [1]." {
+		t.Errorf("response = %q, want the synthesized answer", response.Response)
+	}
+	if len(response.Citations) != 1 || response.Citations[0].ChunkID != "chunk-paris" {
+		t.Errorf("citations = %+v, want one citing chunk-paris", response.Citations)
+	}
+	if len(response.Sources) != 1 || response.Sources[0].Answer != "Paris" {
+		t.Errorf("sources = %+v, want the Paris answer", response.Sources)
+	}
+	if response.Confidence == nil || *response.Confidence <= 0 {
+		t.Errorf("confidence = %v, want a positive confidence", response.Confidence)
+	}
+	if response.Title == nil || *response.Title != "Capital of France" {
+		t.Errorf("title = %v, want the generated title", response.Title)
+	}
+
+	if got := db.Count(`INSERT INTO "chat_messages"`); got != 2 {
+		t.Errorf("saved messages = %d, want the question and the answer", got)
+	}
+	if got := requestsContaining(fake, llmfake.APIAnthropic, "numbered sources"); got != 1 {
+		t.Errorf("synthesis requests = %d, want 1", got)
+	}
+}
+
+func TestStreamMessageSendsDoneBeforeTitle(t *testing.T) {
+	fake := newFake(t)
+	_, chatService := newChatService(t, fake)
+
+	var events []services.ChatEvent
+	var streamed strings.Builder
+	_, err := chatService.StreamMessage(context.Background(), uuid.New(), services.ChatRequest{Message: testQuestion},
+		func(event services.ChatEvent) error {
+			events = append(events, event)
+			if event.Type == services.ChatEventToken {
+				streamed.WriteString(event.Data.(map[string]string)["text"])
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("StreamMessage failed: %v", err)
+	}
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	want := []string{services.ChatEventRetrievalStarted, services.ChatEventSources, services.ChatEventToken,
+		services.ChatEventDone, services.ChatEventTitle}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+
+	done := events[3].Data.(*services.ChatResponse)
+	if done.Response != streamed.String() {
+		t.Errorf("done text = %q, streamed %q", done.Response, streamed.String())
+	}
+	if title := events[4].Data.(map[string]interface{})["title"]; title != "Capital of France" {
+		t.Errorf("title event = %v, want the generated title", title)
+	}
+}
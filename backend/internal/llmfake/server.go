@@ -0,0 +1,528 @@
+// Package llmfake is an in-process stand-in for the Anthropic Messages, OpenAI
+// chat completions and OpenAI embeddings APIs, so the retrieval and answer
+// pipeline can run without network access or API keys.
+//
+// Responses come from, in order: scripted rules, a cassette of recorded
+// interactions, the real provider (record mode only) and, for embeddings, a
+// deterministic bag-of-words embedder. Anything else gets a 404 naming the
+// unmatched request.
+//
+//	fake, _ := llmfake.New(llmfake.Options{Mode: llmfake.ModeReplay, CassettePath: "testdata/qa.json"})
+//	defer fake.Close()
+//	fake.On(llmfake.APIAnthropic, "capital of France", `{"answer": "Paris", "confidence": 0.9, "has_answer": true}`)
+//	registry, _ := services.NewLLMRegistry(fake.Providers(), roles)
+package llmfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/tanaymehhta/self/backend/internal/services"
+)
+
+// APIs the server speaks
+const (
+	APIAnthropic       = "anthropic"
+	APIOpenAIChat      = "openai_chat"
+	APIOpenAIEmbedding = "openai_embeddings"
+)
+
+// Mode selects where unscripted responses come from
+type Mode int
+
+const (
+	// ModeScripted answers only from rules and the deterministic embedder
+	ModeScripted Mode = iota
+	// ModeReplay also answers from the cassette
+	ModeReplay
+	// ModeRecord forwards cassette misses to the real provider and saves the
+	// exchange on Close. API keys are forwarded but never written to the cassette.
+	ModeRecord
+)
+
+// Options configures a Server
+type Options struct {
+	Mode         Mode
+	CassettePath string // Required for ModeReplay and ModeRecord
+
+	// Real providers for ModeRecord; default to the public APIs
+	AnthropicUpstream string
+	OpenAIUpstream    string
+	UpstreamTimeout   time.Duration // Per forwarded request; defaults to 2 minutes
+
+	EmbeddingDimension int // Defaults to 1536, matching the chunk embedding column
+}
+
+// Request is a parsed request, as seen by rules and responders
+type Request struct {
+	API     string   `json:"api"`
+	Model   string   `json:"model"`
+	System  string   `json:"system,omitempty"`
+	Prompt  string   `json:"prompt,omitempty"`  // Last user message
+	Prefill string   `json:"prefill,omitempty"` // Trailing assistant turn (Anthropic prefill)
+	Inputs  []string `json:"inputs,omitempty"`  // Embedding inputs
+
+	body []byte
+}
+
+// Rule replies with a fixed text to chat requests whose system or user prompt
+// contains a substring
+type Rule struct {
+	API      string // "" matches both chat APIs
+	Contains string // "" matches every request
+	Reply    string
+}
+
+// Responder computes a reply; ok = false passes the request on
+type Responder func(req Request) (reply string, ok bool)
+
+// Server is a running fake; point clients at AnthropicBaseURL and OpenAIBaseURL
+type Server struct {
+	options  Options
+	http     *httptest.Server
+	cassette *Cassette
+	upstream *http.Client
+
+	mu         sync.Mutex
+	rules      []Rule
+	responders []Responder
+	requests   []Request
+
+	ids atomic.Int64
+}
+
+// New starts a server on a local port
+func New(options Options) (*Server, error) {
+	if options.AnthropicUpstream == "" {
+		options.AnthropicUpstream = "https://api.anthropic.com"
+	}
+	if options.OpenAIUpstream == "" {
+		options.OpenAIUpstream = "https://api.openai.com"
+	}
+	if options.UpstreamTimeout <= 0 {
+		options.UpstreamTimeout = 2 * time.Minute
+	}
+	if options.EmbeddingDimension <= 0 {
+		options.EmbeddingDimension = 1536
+	}
+
+	s := &Server{
+		options:  options,
+		cassette: NewCassette(),
+		upstream: &http.Client{Timeout: options.UpstreamTimeout},
+	}
+
+	if options.Mode != ModeScripted {
+		if options.CassettePath == "" {
+			return nil, fmt.Errorf("llmfake: replay and record modes need a CassettePath")
+		}
+		cassette, err := LoadCassette(options.CassettePath)
+		if err != nil {
+			return nil, err
+		}
+		s.cassette = cassette
+	}
+
+	s.http = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s, nil
+}
+
+// Close stops the server and, in record mode, writes the cassette
+func (s *Server) Close() error {
+	s.http.Close()
+	if s.options.Mode == ModeRecord {
+		return s.cassette.Save(s.options.CassettePath)
+	}
+	return nil
+}
+
+// URL is the server root
+func (s *Server) URL() string {
+	return s.http.URL
+}
+
+// AnthropicBaseURL is the API root for NewClaudeClientWithBaseURL
+func (s *Server) AnthropicBaseURL() string {
+	return s.http.URL + "/v1"
+}
+
+// OpenAIBaseURL is the API root for OpenAI-compatible and embedding clients
+func (s *Server) OpenAIBaseURL() string {
+	return s.http.URL + "/v1"
+}
+
+// Providers returns "claude" and "openai" providers pointing at the fake, for
+// services.NewLLMRegistry
+func (s *Server) Providers() []services.LLMProviderConfig {
+	return []services.LLMProviderConfig{
+		{Name: "claude", Kind: "anthropic", BaseURL: s.AnthropicBaseURL(), APIKey: "fake-key"},
+		{Name: "openai", Kind: "openai", BaseURL: s.OpenAIBaseURL(), APIKey: "fake-key"},
+	}
+}
+
+// EmbeddingService returns an embedder that calls the fake
+func (s *Server) EmbeddingService() *services.EmbeddingService {
+	return services.NewOpenAIEmbeddingService(s.OpenAIBaseURL(), "fake-key")
+}
+
+// On adds a rule; rules are tried in the order added
+func (s *Server) On(api, contains, reply string) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, Rule{API: api, Contains: contains, Reply: reply})
+	return s
+}
+
+// Respond adds a responder, tried after the rules
+func (s *Server) Respond(responder Responder) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responders = append(s.responders, responder)
+	return s
+}
+
+// Requests returns every request received so far, in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	api := apiForPath(r.URL.Path)
+	if api == "" || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("llmfake: unsupported endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req, err := parseRequest(api, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("llmfake: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	if reply, ok := s.scripted(req); ok {
+		s.writeReply(w, req, reply)
+		return
+	}
+
+	key := RequestKey(api, body)
+	if interaction, ok := s.cassette.Find(key); ok {
+		writeJSON(w, interaction.Status, interaction.Response)
+		return
+	}
+
+	if s.options.Mode == ModeRecord {
+		s.record(w, r, req, key)
+		return
+	}
+
+	if api == APIOpenAIEmbedding {
+		s.writeEmbeddings(w, req)
+		return
+	}
+
+	// A 404 rather than a 5xx, so the resilient transport doesn't retry a
+	// request that can never succeed
+	writeError(w, http.StatusNotFound, fmt.Sprintf("llmfake: no scripted or recorded response for %s request (key %s): %s",
+		api, key, truncate(req.Prompt, 200)))
+}
+
+// scripted matches rules, then responders; embeddings are never scripted
+func (s *Server) scripted(req Request) (string, bool) {
+	if req.API == APIOpenAIEmbedding {
+		return "", false
+	}
+
+	s.mu.Lock()
+	rules := append([]Rule(nil), s.rules...)
+	responders := append([]Responder(nil), s.responders...)
+	s.mu.Unlock()
+
+	for _, rule := range rules {
+		if rule.API != "" && rule.API != req.API {
+			continue
+		}
+		if strings.Contains(req.System, rule.Contains) || strings.Contains(req.Prompt, rule.Contains) {
+			return rule.Reply, true
+		}
+	}
+	for _, responder := range responders {
+		if reply, ok := responder(req); ok {
+			return reply, true
+		}
+	}
+	return "", false
+}
+
+// record forwards a request to the real provider and stores successful replies
+func (s *Server) record(w http.ResponseWriter, r *http.Request, req Request, key string) {
+	upstream := s.options.OpenAIUpstream
+	if req.API == APIAnthropic {
+		upstream = s.options.AnthropicUpstream
+	}
+
+	forward, err := http.NewRequestWithContext(r.Context(), http.MethodPost, strings.TrimRight(upstream, "/")+r.URL.Path, strings.NewReader(string(req.body)))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, header := range []string{"Content-Type", "Authorization", "X-Api-Key", "Anthropic-Version"} {
+		if value := r.Header.Get(header); value != "" {
+			forward.Header.Set(header, value)
+		}
+	}
+
+	resp, err := s.upstream.Do(forward)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("llmfake: upstream request failed: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	// Errors are passed through but not recorded, so a flaky run can be re-recorded
+	if resp.StatusCode == http.StatusOK && json.Valid(body) {
+		s.cassette.Add(Interaction{
+			Key:      key,
+			API:      req.API,
+			Request:  canonicalJSON(req.body),
+			Status:   resp.StatusCode,
+			Response: body,
+		})
+	}
+	writeJSON(w, resp.StatusCode, body)
+}
+
+func (s *Server) writeReply(w http.ResponseWriter, req Request, reply string) {
+	id := s.ids.Add(1)
+	inputTokens := countTokens(req.System) + countTokens(req.Prompt)
+
+	var response interface{}
+	switch req.API {
+	case APIAnthropic:
+		// Claude continues after a prefilled assistant turn, so the prefill
+		// isn't repeated in its reply
+		reply = strings.TrimPrefix(reply, req.Prefill)
+		response = map[string]interface{}{
+			"id":          fmt.Sprintf("msg_fake_%d", id),
+			"type":        "message",
+			"role":        "assistant",
+			"model":       req.Model,
+			"content":     []map[string]string{{"type": "text", "text": reply}},
+			"stop_reason": "end_turn",
+			"usage": map[string]int{
+				"input_tokens":  inputTokens,
+				"output_tokens": countTokens(reply),
+			},
+		}
+	default:
+		response = map[string]interface{}{
+			"id":     fmt.Sprintf("chatcmpl-fake-%d", id),
+			"object": "chat.completion",
+			"model":  req.Model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": reply},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{
+				"prompt_tokens":     inputTokens,
+				"completion_tokens": countTokens(reply),
+				"total_tokens":      inputTokens + countTokens(reply),
+			},
+		}
+	}
+
+	data, _ := json.Marshal(response)
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (s *Server) writeEmbeddings(w http.ResponseWriter, req Request) {
+	data := make([]map[string]interface{}, len(req.Inputs))
+	tokens := 0
+	for i, input := range req.Inputs {
+		data[i] = map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": Embed(input, s.options.EmbeddingDimension),
+		}
+		tokens += countTokens(input)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  req.Model,
+		"usage":  map[string]int{"prompt_tokens": tokens, "total_tokens": tokens},
+	})
+	writeJSON(w, http.StatusOK, body)
+}
+
+// Embed is the deterministic embedder: hashed bag of words, L2-normalized, so
+// texts sharing words have a high cosine similarity
+func Embed(text string, dimension int) []float32 {
+	vector := make([]float64, dimension)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		hash := fnv.New64a()
+		hash.Write([]byte(word))
+		sum := hash.Sum64()
+		sign := 1.0
+		if sum&1 == 1 {
+			sign = -1.0
+		}
+		vector[(sum>>1)%uint64(dimension)] += sign
+	}
+
+	norm := 0.0
+	for _, value := range vector {
+		norm += value * value
+	}
+	embedding := make([]float32, dimension)
+	if norm == 0 {
+		embedding[0] = 1
+		return embedding
+	}
+	norm = math.Sqrt(norm)
+	for i, value := range vector {
+		embedding[i] = float32(value / norm)
+	}
+	return embedding
+}
+
+func apiForPath(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/messages"):
+		return APIAnthropic
+	case strings.HasSuffix(path, "/chat/completions"):
+		return APIOpenAIChat
+	case strings.HasSuffix(path, "/embeddings"):
+		return APIOpenAIEmbedding
+	}
+	return ""
+}
+
+// wireMessage accepts string content or Anthropic/OpenAI content-part arrays
+type wireMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+func (m wireMessage) text() string {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(m.Content, &parts)
+	texts := make([]string, len(parts))
+	for i, part := range parts {
+		texts[i] = part.Text
+	}
+	return strings.Join(texts, "")
+}
+
+func parseRequest(api string, body []byte) (Request, error) {
+	req := Request{API: api, body: body}
+
+	if api == APIOpenAIEmbedding {
+		var wire struct {
+			Model string          `json:"model"`
+			Input json.RawMessage `json:"input"`
+		}
+		if err := json.Unmarshal(body, &wire); err != nil {
+			return req, err
+		}
+		req.Model = wire.Model
+
+		var single string
+		if err := json.Unmarshal(wire.Input, &single); err == nil {
+			req.Inputs = []string{single}
+		} else if err := json.Unmarshal(wire.Input, &req.Inputs); err != nil {
+			return req, fmt.Errorf("embedding input must be a string or array of strings")
+		}
+		return req, nil
+	}
+
+	var wire struct {
+		Model    string        `json:"model"`
+		System   string        `json:"system"`
+		Messages []wireMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &wire); err != nil {
+		return req, err
+	}
+	req.Model = wire.Model
+	req.System = wire.System
+
+	for i, message := range wire.Messages {
+		switch message.Role {
+		case "system":
+			req.System = message.text()
+		case "user":
+			req.Prompt = message.text()
+		case "assistant":
+			if i == len(wire.Messages)-1 {
+				req.Prefill = message.text()
+			}
+		}
+	}
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeError replies in the Anthropic error shape, which both clients surface verbatim
+func writeError(w http.ResponseWriter, status int, message string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": "llmfake_error", "message": message},
+	})
+	writeJSON(w, status, body)
+}
+
+func countTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length]) + "..."
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 
@@ -117,6 +119,28 @@ func (m *AuthMiddleware) OptionalAuth() fiber.Handler {
 	}
 }
 
+// RequireAdmin middleware that allows only the configured admin emails.
+// Must run after RequireAuth.
+func (m *AuthMiddleware) RequireAdmin(adminEmails []string) fiber.Handler {
+	admins := make(map[string]bool, len(adminEmails))
+	for _, email := range adminEmails {
+		admins[strings.ToLower(email)] = true
+	}
+
+	return func(c *fiber.Ctx) error {
+		claims, ok := GetClaims(c)
+		if !ok || !admins[strings.ToLower(claims.Email)] {
+			m.logger.Warn("Admin access denied", "path", c.Path())
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":   "forbidden",
+				"message": "Admin access required",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
 // GetUserID extracts user ID from Fiber context
 func GetUserID(c *fiber.Ctx) (uuid.UUID, bool) {
 	userID := c.Locals(string(UserIDKey))
@@ -0,0 +1,301 @@
+// Package prompts holds the versioned prompt templates sent to LLMs. Built-in
+// templates are embedded from templates/<name>.<version>.prompt; more versions
+// can be added at runtime (e.g. from the database) and weighted for A/B tests.
+package prompts
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Built-in template names
+const (
+	AnswerExtraction    = "answer_extraction"
+	BatchExtraction     = "batch_extraction"
+	Synthesis           = "synthesis"
+	QueryRewrite        = "query_rewrite"
+	ConversationTitle   = "conversation_title"
+	ConversationSummary = "conversation_summary"
+)
+
+//go:embed templates/*.prompt
+var embedded embed.FS
+
+// ErrTemplateNotFound is returned for unknown template names or versions
+var ErrTemplateNotFound = errors.New("prompt template not found")
+
+// Template is one version of a system + user prompt pair
+type Template struct {
+	Name      string   `json:"name"`
+	Version   string   `json:"version"`
+	System    string   `json:"system"`
+	User      string   `json:"user"`
+	Variables []string `json:"variables"` // Must all be supplied to Render
+	Weight    int      `json:"weight"`    // Share of traffic among the name's versions; 0 = inactive
+
+	system *template.Template
+	user   *template.Template
+}
+
+// New compiles a template. Variables are referenced as {{.name}}.
+func New(name, version, system, user string, variables []string, weight int) (*Template, error) {
+	if name == "" || version == "" {
+		return nil, fmt.Errorf("prompt template needs a name and version")
+	}
+
+	t := &Template{
+		Name:      name,
+		Version:   version,
+		System:    system,
+		User:      user,
+		Variables: variables,
+		Weight:    weight,
+	}
+
+	var err error
+	if t.system, err = template.New(t.ID() + "/system").Option("missingkey=error").Parse(system); err != nil {
+		return nil, fmt.Errorf("invalid system prompt for %s: %w", t.ID(), err)
+	}
+	if t.user, err = template.New(t.ID() + "/user").Option("missingkey=error").Parse(user); err != nil {
+		return nil, fmt.Errorf("invalid user prompt for %s: %w", t.ID(), err)
+	}
+	return t, nil
+}
+
+// ID identifies the exact prompt, e.g. "answer_extraction@v1"
+func (t *Template) ID() string {
+	return t.Name + "@" + t.Version
+}
+
+// Render fills in the variables
+func (t *Template) Render(vars map[string]string) (system, user string, err error) {
+	for _, name := range t.Variables {
+		if _, ok := vars[name]; !ok {
+			return "", "", fmt.Errorf("prompt %s: missing variable %q", t.ID(), name)
+		}
+	}
+
+	var systemText, userText strings.Builder
+	if err := t.system.Execute(&systemText, vars); err != nil {
+		return "", "", fmt.Errorf("prompt %s: %w", t.ID(), err)
+	}
+	if err := t.user.Execute(&userText, vars); err != nil {
+		return "", "", fmt.Errorf("prompt %s: %w", t.ID(), err)
+	}
+	return systemText.String(), userText.String(), nil
+}
+
+// Parse reads the .prompt file format: optional "# key: value" header lines
+// (variables, weight) followed by [system] and [user] sections
+func Parse(name, version, source string) (*Template, error) {
+	var (
+		variables []string
+		weight    = 1
+		section   string
+		sections  = map[string][]string{}
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(source))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "[system]" || line == "[user]":
+			section = strings.Trim(line, "[]")
+		case section == "" && strings.HasPrefix(line, "#"):
+			key, value, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "#")), ":")
+			value = strings.TrimSpace(value)
+			switch strings.TrimSpace(key) {
+			case "variables":
+				for _, variable := range strings.Split(value, ",") {
+					if variable = strings.TrimSpace(variable); variable != "" {
+						variables = append(variables, variable)
+					}
+				}
+			case "weight":
+				parsed, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s@%s: invalid weight %q", name, version, value)
+				}
+				weight = parsed
+			}
+		case section != "":
+			sections[section] = append(sections[section], line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	system := strings.Trim(strings.Join(sections["system"], "\n"), "\n")
+	user := strings.Trim(strings.Join(sections["user"], "\n"), "\n")
+	if system == "" || user == "" {
+		return nil, fmt.Errorf("%s@%s: needs [system] and [user] sections", name, version)
+	}
+	return New(name, version, system, user, variables, weight)
+}
+
+// Registry holds every known version of every template
+type Registry struct {
+	mu        sync.RWMutex
+	templates map[string][]*Template // Versions in the order they were added
+}
+
+func NewRegistry() *Registry {
+	return &Registry{templates: make(map[string][]*Template)}
+}
+
+// LoadEmbedded returns a registry with the built-in templates
+func LoadEmbedded() (*Registry, error) {
+	files, err := embedded.ReadDir("templates")
+	if err != nil {
+		return nil, err
+	}
+
+	registry := NewRegistry()
+	for _, file := range files {
+		base := strings.TrimSuffix(file.Name(), ".prompt")
+		name, version, ok := strings.Cut(base, ".")
+		if !ok {
+			return nil, fmt.Errorf("prompt file %s should be named <name>.<version>.prompt", file.Name())
+		}
+
+		source, err := embedded.ReadFile(path.Join("templates", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t, err := Parse(name, version, string(source))
+		if err != nil {
+			return nil, err
+		}
+		registry.Add(t)
+	}
+	return registry, nil
+}
+
+// Add registers a template, replacing an existing one with the same name and version
+func (r *Registry) Add(t *Template) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.templates[t.Name]
+	for i, existing := range versions {
+		if existing.Version == t.Version {
+			versions[i] = t
+			return
+		}
+	}
+	r.templates[t.Name] = append(versions, t)
+}
+
+// Get returns one exact version, e.g. to reproduce an old answer
+func (r *Registry) Get(name, version string) (*Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.templates[name] {
+		if t.Version == version {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s@%s", ErrTemplateNotFound, name, version)
+}
+
+// Select picks one of the name's active versions in proportion to their
+// weights. The pick is a hash of key, so the same key (e.g. a user ID) always
+// gets the same version. With no active versions the first one added is used.
+func (r *Registry) Select(name, key string) (*Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.templates[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	total := 0
+	for _, t := range versions {
+		if t.Weight > 0 {
+			total += t.Weight
+		}
+	}
+	if total == 0 {
+		return versions[0], nil
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name + "\x00" + key))
+	point := int(hash.Sum32() % uint32(total))
+
+	for _, t := range versions {
+		if t.Weight <= 0 {
+			continue
+		}
+		if point < t.Weight {
+			return t, nil
+		}
+		point -= t.Weight
+	}
+	return versions[0], nil
+}
+
+// List returns every template, grouped by name
+func (r *Registry) List() map[string][]*Template {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make(map[string][]*Template, len(r.templates))
+	for name, versions := range r.templates {
+		list[name] = append([]*Template(nil), versions...)
+	}
+	return list
+}
+
+var defaultRegistry = mustLoadEmbedded()
+
+func mustLoadEmbedded() *Registry {
+	registry, err := LoadEmbedded()
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded prompt templates: %v", err))
+	}
+	return registry
+}
+
+// Default returns the process-wide registry, preloaded with the built-in templates
+func Default() *Registry {
+	return defaultRegistry
+}
+
+type selectedKey struct{}
+
+// WithSelected records the template version a request uses, so every call in
+// that request (and what it stores) agrees on one version
+func WithSelected(ctx context.Context, t *Template) context.Context {
+	selected := map[string]*Template{t.Name: t}
+	if previous, ok := ctx.Value(selectedKey{}).(map[string]*Template); ok {
+		for name, existing := range previous {
+			if name != t.Name {
+				selected[name] = existing
+			}
+		}
+	}
+	return context.WithValue(ctx, selectedKey{}, selected)
+}
+
+// Selected returns the version recorded in ctx for name, if any
+func Selected(ctx context.Context, name string) (*Template, bool) {
+	selected, ok := ctx.Value(selectedKey{}).(map[string]*Template)
+	if !ok {
+		return nil, false
+	}
+	t, ok := selected[name]
+	return t, ok
+}
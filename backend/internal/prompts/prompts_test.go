@@ -0,0 +1,240 @@
+package prompts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+const sample = `# variables: query, chunk
+# weight: 3
+[system]
+You extract answers.
+
+Be precise.
+[user]
+Query: {{.query}}
+
+{{.chunk}}
+`
+
+func TestParse(t *testing.T) {
+	tmpl, err := Parse("extract", "v2", sample)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if tmpl.ID() != "extract@v2" {
+		t.Errorf("ID = %q, want extract@v2", tmpl.ID())
+	}
+	if strings.Join(tmpl.Variables, ",") != "query,chunk" {
+		t.Errorf("variables = %v, want [query chunk]", tmpl.Variables)
+	}
+	if tmpl.Weight != 3 {
+		t.Errorf("weight = %d, want 3", tmpl.Weight)
+	}
+	if tmpl.System != "You extract answers.\n\nBe precise." {
+		t.Errorf("system = %q", tmpl.System)
+	}
+	if tmpl.User != "Query: {{.query}}\n\n{{.chunk}}" {
+		t.Errorf("user = %q", tmpl.User)
+	}
+}
+
+func TestParseDefaultsWeightToOne(t *testing.T) {
+	tmpl, err := Parse("title", "v1", "[system]\nName it.\n[user]\n{{.question}}\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if tmpl.Weight != 1 || len(tmpl.Variables) != 0 {
+		t.Errorf("weight = %d, variables = %v; want 1 and none", tmpl.Weight, tmpl.Variables)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"missing user section", "[system]\nHello\n", "needs [system] and [user] sections"},
+		{"empty system section", "[system]\n\n[user]\nHi\n", "needs [system] and [user] sections"},
+		{"invalid weight", "# weight: lots\n[system]\nA\n[user]\nB\n", `invalid weight "lots"`},
+		{"invalid template", "[system]\nA\n[user]\n{{.query\n", "invalid user prompt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("p", "v1", tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tmpl, err := Parse("extract", "v2", sample)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	system, user, err := tmpl.Render(map[string]string{"query": "capital?", "chunk": "Paris"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if system != tmpl.System || user != "Query: capital?\n\nParis" {
+		t.Errorf("Render = %q, %q", system, user)
+	}
+}
+
+func TestRenderMissingVariable(t *testing.T) {
+	tmpl, err := Parse("extract", "v2", sample)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if _, _, err := tmpl.Render(map[string]string{"query": "capital?"}); err == nil || !strings.Contains(err.Error(), `missing variable "chunk"`) {
+		t.Errorf("Render error = %v, want missing variable \"chunk\"", err)
+	}
+
+	// Undeclared variables used by the template fail too, rather than rendering "<no value>"
+	undeclared, err := New("p", "v1", "System", "{{.other}}", nil, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, _, err := undeclared.Render(map[string]string{}); err == nil {
+		t.Error("Render with an undeclared, unsupplied variable succeeded")
+	}
+}
+
+func mustNew(t *testing.T, name, version string, weight int) *Template {
+	t.Helper()
+	tmpl, err := New(name, version, "system "+version, "user "+version, nil, weight)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return tmpl
+}
+
+func TestSelectIsSticky(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add(mustNew(t, "p", "v1", 1))
+	registry.Add(mustNew(t, "p", "v2", 1))
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		first, err := registry.Select("p", key)
+		if err != nil {
+			t.Fatalf("Select failed: %v", err)
+		}
+		for j := 0; j < 5; j++ {
+			if again, _ := registry.Select("p", key); again.Version != first.Version {
+				t.Fatalf("key %s got %s, then %s", key, first.Version, again.Version)
+			}
+		}
+	}
+}
+
+func TestSelectFollowsWeights(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add(mustNew(t, "p", "v1", 3))
+	registry.Add(mustNew(t, "p", "v2", 1))
+	registry.Add(mustNew(t, "p", "v3", 0))
+
+	const keys = 8000
+	counts := map[string]int{}
+	for i := 0; i < keys; i++ {
+		tmpl, err := registry.Select("p", fmt.Sprintf("user-%d", i))
+		if err != nil {
+			t.Fatalf("Select failed: %v", err)
+		}
+		counts[tmpl.Version]++
+	}
+
+	if counts["v3"] != 0 {
+		t.Errorf("weight-0 version selected %d times", counts["v3"])
+	}
+	if share := float64(counts["v1"]) / keys; math.Abs(share-0.75) > 0.03 {
+		t.Errorf("v1 share = %.3f, want about 0.75 (counts %v)", share, counts)
+	}
+}
+
+func TestSelectWithoutActiveVersions(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add(mustNew(t, "p", "v1", 0))
+	registry.Add(mustNew(t, "p", "v2", 0))
+
+	tmpl, err := registry.Select("p", "user")
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	if tmpl.Version != "v1" {
+		t.Errorf("Select = %s, want the first version added", tmpl.Version)
+	}
+
+	if _, err := registry.Select("unknown", "user"); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Select unknown = %v, want ErrTemplateNotFound", err)
+	}
+}
+
+func TestAddReplacesSameVersion(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add(mustNew(t, "p", "v1", 1))
+	registry.Add(mustNew(t, "p", "v2", 1))
+
+	replacement, err := New("p", "v1", "new system", "new user", nil, 5)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	registry.Add(replacement)
+
+	versions := registry.List()["p"]
+	if len(versions) != 2 || versions[0].Version != "v1" || versions[1].Version != "v2" {
+		t.Fatalf("versions = %v, want v1 replaced in place", versions)
+	}
+	got, err := registry.Get("p", "v1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.System != "new system" || got.Weight != 5 {
+		t.Errorf("v1 = %+v, want the replacement", got)
+	}
+	if _, err := registry.Get("p", "v9"); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Get missing version = %v, want ErrTemplateNotFound", err)
+	}
+}
+
+func TestEmbeddedTemplatesRender(t *testing.T) {
+	for name, versions := range Default().List() {
+		for _, tmpl := range versions {
+			vars := map[string]string{}
+			for _, variable := range tmpl.Variables {
+				vars[variable] = "x"
+			}
+			if _, _, err := tmpl.Render(vars); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestWithSelected(t *testing.T) {
+	v1, v2, other := mustNew(t, "p", "v1", 1), mustNew(t, "p", "v2", 1), mustNew(t, "q", "v1", 1)
+
+	ctx := WithSelected(context.Background(), v1)
+	ctx = WithSelected(ctx, other)
+	ctx = WithSelected(ctx, v2)
+
+	if got, ok := Selected(ctx, "p"); !ok || got != v2 {
+		t.Errorf("Selected p = %v, want the latest version recorded", got)
+	}
+	if got, ok := Selected(ctx, "q"); !ok || got != other {
+		t.Errorf("Selected q = %v, want it kept", got)
+	}
+	if _, ok := Selected(context.Background(), "p"); ok {
+		t.Error("Selected found a version in an empty context")
+	}
+}
@@ -0,0 +1,104 @@
+package resilient
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// CircuitBreaker opens after a run of consecutive failures, rejects calls while
+// open, then lets a single probe through; the probe's outcome closes or reopens it
+type CircuitBreaker struct {
+	mu           sync.Mutex
+	threshold    int
+	openDuration time.Duration
+	state        string
+	failures     int
+	openedAt     time.Time
+	probing      bool
+
+	// Now returns the current time; replaceable so tests can move the clock
+	Now func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:    threshold,
+		openDuration: openDuration,
+		state:        StateClosed,
+		Now:          time.Now,
+	}
+}
+
+// Allow reports whether a call may proceed
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if b.Now().Sub(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return true
+	case StateHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Success records a healthy response and closes the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a provider failure, opening the breaker at the threshold or
+// immediately when a half-open probe fails
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.state = StateOpen
+		b.openedAt = b.Now()
+	}
+	b.probing = false
+}
+
+// Release gives back a half-open probe slot without a verdict, e.g. when the
+// caller cancelled before the provider answered
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// State returns the current state, reporting an expired open breaker as half-open
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.Now().Sub(b.openedAt) >= b.openDuration {
+		return StateHalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,378 @@
+// Package resilient provides an http.RoundTripper for calls to LLM and embedding
+// providers: retries with jittered exponential backoff that honor retry-after,
+// a concurrency limit, and a circuit breaker that fails fast while a provider is down.
+package resilient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config tunes a Transport. Zero values other than MaxRetries are replaced by
+// DefaultConfig's; start from DefaultConfig to keep its retries.
+type Config struct {
+	MaxRetries     int           // Retries after the first attempt; 0 or negative = none
+	BaseDelay      time.Duration // Backoff before the first retry
+	MaxDelay       time.Duration // Cap for backoff and retry-after
+	AttemptTimeout time.Duration // Per-attempt wait for response headers; 0 = none
+	MaxConcurrent  int           // Requests in flight per transport
+
+	FailureThreshold int           // Consecutive failures that open the breaker
+	OpenDuration     time.Duration // How long the breaker stays open before probing
+}
+
+// ErrCircuitOpen is returned without calling the provider while the breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open: provider is failing, try again later")
+
+// Stats is a snapshot of a transport's counters
+type Stats struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Requests int64  `json:"requests"`
+	Retries  int64  `json:"retries"`
+	Failures int64  `json:"failures"`
+	Rejected int64  `json:"rejected"` // Failed fast by the open breaker
+	InFlight int64  `json:"in_flight"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxRetries:       3,
+		BaseDelay:        500 * time.Millisecond,
+		MaxDelay:         20 * time.Second,
+		AttemptTimeout:   30 * time.Second,
+		MaxConcurrent:    8,
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+	}
+}
+
+// Transport retries transient failures of the wrapped RoundTripper
+type Transport struct {
+	name    string
+	base    http.RoundTripper
+	config  Config
+	limiter chan struct{}
+	breaker *CircuitBreaker
+
+	// Sleep waits between attempts; replaceable so tests needn't wait
+	Sleep func(ctx context.Context, d time.Duration) error
+
+	requests atomic.Int64
+	retries  atomic.Int64
+	failures atomic.Int64
+	rejected atomic.Int64
+	inFlight atomic.Int64
+}
+
+// NewTransport wraps base (http.DefaultTransport when nil)
+func NewTransport(name string, base http.RoundTripper, config Config) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	config = withDefaults(config)
+
+	return &Transport{
+		name:    name,
+		base:    base,
+		config:  config,
+		limiter: make(chan struct{}, config.MaxConcurrent),
+		breaker: NewCircuitBreaker(config.FailureThreshold, config.OpenDuration),
+		Sleep:   sleepContext,
+	}
+}
+
+// NewClient returns an http.Client using t. The client has no overall timeout;
+// each attempt must start responding within AttemptTimeout and the whole call,
+// body included, is bounded by the request context.
+func NewClient(t *Transport) *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func withDefaults(config Config) Config {
+	defaults := DefaultConfig()
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = defaults.BaseDelay
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = defaults.MaxDelay
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = defaults.MaxConcurrent
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaults.FailureThreshold
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = defaults.OpenDuration
+	}
+	return config
+}
+
+// RoundTrip implements http.RoundTripper. After the last attempt the final
+// response is returned as-is so callers still see the provider's error body.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	t.requests.Add(1)
+
+	if !t.breaker.Allow() {
+		t.rejected.Add(1)
+		return nil, fmt.Errorf("%s: %w", t.name, ErrCircuitOpen)
+	}
+
+	if err := t.acquire(ctx); err != nil {
+		t.breaker.Release()
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req)
+		if err != nil && ctx.Err() != nil {
+			// Cancelled or timed out by the caller: says nothing about the provider
+			t.release()
+			t.breaker.Release()
+			return nil, err
+		}
+
+		retryable, providerFailure := classify(resp, err)
+		if providerFailure {
+			t.failures.Add(1)
+			t.breaker.Failure()
+		} else {
+			t.breaker.Success()
+		}
+
+		if !retryable || attempt >= t.config.MaxRetries {
+			t.release()
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		// Let other requests use the slot while this one waits
+		t.release()
+		if err := t.Sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		if !t.breaker.Allow() {
+			t.rejected.Add(1)
+			return nil, fmt.Errorf("%s: %w", t.name, ErrCircuitOpen)
+		}
+		if err := t.acquire(ctx); err != nil {
+			t.breaker.Release()
+			return nil, err
+		}
+		t.retries.Add(1)
+	}
+}
+
+// acquire takes a concurrency slot, waiting until one frees up or ctx is done
+func (t *Transport) acquire(ctx context.Context) error {
+	select {
+	case t.limiter <- struct{}{}:
+		t.inFlight.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release gives back a slot taken by acquire
+func (t *Transport) release() {
+	t.inFlight.Add(-1)
+	<-t.limiter
+}
+
+// attempt sends one copy of req. AttemptTimeout bounds the wait for response
+// headers only; the body is then bounded by the request context, so a
+// streamed reply can run longer than one attempt may take to start.
+func (t *Transport) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	attemptReq := req.Clone(ctx)
+
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		attemptReq.Body = body
+	}
+
+	var timer *time.Timer
+	if t.config.AttemptTimeout > 0 {
+		timer = time.AfterFunc(t.config.AttemptTimeout, cancel)
+	}
+
+	resp, err := t.base.RoundTrip(attemptReq)
+	if timer != nil && !timer.Stop() {
+		// The timer fired before the headers arrived
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("%s: no response within %s: %w", t.name, t.config.AttemptTimeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Keep the attempt's context alive until the caller has read the body
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// classify reports whether an outcome is worth retrying and whether it counts
+// against the provider's health. Rate limits are retried but don't trip the
+// breaker; the provider is up, just busy.
+func classify(resp *http.Response, err error) (retryable, providerFailure bool) {
+	if err != nil {
+		return true, true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true, false
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout, 529: // 529: Anthropic "overloaded"
+		return true, true
+	}
+	return false, false
+}
+
+// backoff honors retry-after (seconds or HTTP date) and retry-after-ms, and
+// otherwise uses full-jitter exponential backoff
+func (t *Transport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header); ok {
+			if delay > t.config.MaxDelay {
+				delay = t.config.MaxDelay
+			}
+			return delay
+		}
+	}
+
+	ceiling := t.config.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > t.config.MaxDelay {
+		ceiling = t.config.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func retryAfter(header http.Header) (time.Duration, bool) {
+	if value := header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Stats returns the transport's counters and breaker state
+func (t *Transport) Stats() Stats {
+	return Stats{
+		Name:     t.name,
+		State:    t.breaker.State(),
+		Requests: t.requests.Load(),
+		Retries:  t.retries.Load(),
+		Failures: t.failures.Load(),
+		Rejected: t.rejected.Load(),
+		InFlight: t.inFlight.Load(),
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var shared = struct {
+	mu         sync.Mutex
+	config     Config
+	transports map[string]*Transport
+}{config: DefaultConfig(), transports: make(map[string]*Transport)}
+
+// SetDefaultConfig sets the config used by transports Shared creates afterwards
+func SetDefaultConfig(config Config) {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	shared.config = withDefaults(config)
+}
+
+// Shared returns the process-wide transport for a provider, creating it on first
+// use. Clients are built per request, so the limiter and breaker must live here.
+func Shared(name string) *Transport {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if t, ok := shared.transports[name]; ok {
+		return t
+	}
+	t := NewTransport(name, nil, shared.config)
+	shared.transports[name] = t
+	return t
+}
+
+// AllStats returns stats for every shared transport
+func AllStats() []Stats {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	stats := make([]Stats, 0, len(shared.transports))
+	for _, t := range shared.transports {
+		stats = append(stats, t.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
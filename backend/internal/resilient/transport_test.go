@@ -0,0 +1,402 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConfig retries quickly and keeps the breaker out of the way unless a
+// test tightens it
+func testConfig() Config {
+	config := DefaultConfig()
+	config.MaxRetries = 3
+	config.AttemptTimeout = 5 * time.Second
+	config.FailureThreshold = 100
+	return config
+}
+
+// scriptedServer replies with statuses in order, then 200, and records each body
+type scriptedServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	headers  []http.Header
+	bodies   []string
+}
+
+func newScriptedServer(t *testing.T, statuses []int, headers []http.Header) *scriptedServer {
+	s := &scriptedServer{statuses: statuses, headers: headers}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		attempt := len(s.bodies)
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+
+		status := http.StatusOK
+		if attempt < len(s.statuses) {
+			status = s.statuses[attempt]
+		}
+		if attempt < len(s.headers) {
+			for key, values := range s.headers[attempt] {
+				w.Header()[key] = values
+			}
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, http.StatusText(status))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *scriptedServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+// recordSleeps replaces the transport's Sleep, recording delays without waiting
+func recordSleeps(transport *Transport) *[]time.Duration {
+	var delays []time.Duration
+	transport.Sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	return &delays
+}
+
+func post(t *testing.T, client *http.Client, url, body string) *http.Response {
+	t.Helper()
+	resp, err := client.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestTransportRetriesTransientFailures(t *testing.T) {
+	for _, status := range []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		529,
+	} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := newScriptedServer(t, []int{status, status}, nil)
+			transport := NewTransport("test", nil, testConfig())
+			delays := recordSleeps(transport)
+
+			resp := post(t, NewClient(transport), server.URL, `{"prompt":"hi"}`)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+			if got := server.attempts(); got != 3 {
+				t.Errorf("attempts = %d, want 3", got)
+			}
+			if len(*delays) != 2 {
+				t.Errorf("backoff sleeps = %d, want 2", len(*delays))
+			}
+			for i, body := range server.bodies {
+				if body != `{"prompt":"hi"}` {
+					t.Errorf("attempt %d body = %q, want the original body", i+1, body)
+				}
+			}
+			if stats := transport.Stats(); stats.Retries != 2 {
+				t.Errorf("retries = %d, want 2", stats.Retries)
+			}
+		})
+	}
+}
+
+func TestTransportDoesNotRetryClientErrors(t *testing.T) {
+	server := newScriptedServer(t, []int{http.StatusBadRequest}, nil)
+	transport := NewTransport("test", nil, testConfig())
+	delays := recordSleeps(transport)
+
+	resp := post(t, NewClient(transport), server.URL, "{}")
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", resp.StatusCode)
+	}
+	if got := server.attempts(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+	if len(*delays) != 0 {
+		t.Errorf("backoff sleeps = %d, want none", len(*delays))
+	}
+}
+
+func TestTransportReturnsLastResponseWhenRetriesRunOut(t *testing.T) {
+	unavailable := http.StatusServiceUnavailable
+	server := newScriptedServer(t, []int{unavailable, unavailable, unavailable}, nil)
+	config := testConfig()
+	config.MaxRetries = 2
+	transport := NewTransport("test", nil, config)
+	recordSleeps(transport)
+
+	resp := post(t, NewClient(transport), server.URL, "{}")
+
+	if resp.StatusCode != unavailable {
+		t.Fatalf("status = %d, want 503", resp.StatusCode)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != http.StatusText(unavailable) {
+		t.Errorf("body = %q, want the provider's error body", body)
+	}
+	if got := server.attempts(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestTransportZeroMaxRetriesDisablesRetries(t *testing.T) {
+	server := newScriptedServer(t, []int{http.StatusServiceUnavailable}, nil)
+	config := testConfig()
+	config.MaxRetries = 0
+	transport := NewTransport("test", nil, config)
+	recordSleeps(transport)
+
+	resp := post(t, NewClient(transport), server.URL, "{}")
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", resp.StatusCode)
+	}
+	if got := server.attempts(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestTransportHonorsRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"7"}}, 7 * time.Second},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"250"}}, 250 * time.Millisecond},
+		{"capped at MaxDelay", http.Header{"Retry-After": {"3600"}}, 20 * time.Second},
+		{"past date", http.Header{"Retry-After": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newScriptedServer(t, []int{http.StatusTooManyRequests}, []http.Header{tt.header})
+			transport := NewTransport("test", nil, testConfig())
+			delays := recordSleeps(transport)
+
+			resp := post(t, NewClient(transport), server.URL, "{}")
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+			if len(*delays) != 1 || (*delays)[0] != tt.want {
+				t.Errorf("backoff sleeps = %v, want [%v]", *delays, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransportBackoffStaysWithinJitterCeiling(t *testing.T) {
+	unavailable := http.StatusServiceUnavailable
+	server := newScriptedServer(t, []int{unavailable, unavailable, unavailable}, nil)
+	config := testConfig()
+	config.BaseDelay = 100 * time.Millisecond
+	transport := NewTransport("test", nil, config)
+	delays := recordSleeps(transport)
+
+	post(t, NewClient(transport), server.URL, "{}")
+
+	if len(*delays) != 3 {
+		t.Fatalf("backoff sleeps = %d, want 3", len(*delays))
+	}
+	for attempt, delay := range *delays {
+		ceiling := config.BaseDelay << attempt
+		if delay < 0 || delay > ceiling {
+			t.Errorf("retry %d slept %v, want between 0 and %v", attempt+1, delay, ceiling)
+		}
+	}
+}
+
+func TestTransportReleasesSlotWhileBackingOff(t *testing.T) {
+	server := newScriptedServer(t, []int{http.StatusTooManyRequests}, nil)
+	config := testConfig()
+	config.MaxConcurrent = 1
+	transport := NewTransport("test", nil, config)
+	client := NewClient(transport)
+
+	// While the first request backs off, a second must get the only slot
+	var secondStatus int
+	var secondErr error
+	transport.Sleep = func(ctx context.Context, d time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader("{}"))
+		resp, err := client.Do(req)
+		if err != nil {
+			secondErr = err
+			return nil
+		}
+		secondStatus = resp.StatusCode
+		resp.Body.Close()
+		return nil
+	}
+
+	resp := post(t, client, server.URL, "{}")
+
+	if secondErr != nil {
+		t.Fatalf("request during backoff failed: %v", secondErr)
+	}
+	if secondStatus != http.StatusOK || resp.StatusCode != http.StatusOK {
+		t.Errorf("statuses = %d and %d, want 200 for both", secondStatus, resp.StatusCode)
+	}
+	if stats := transport.Stats(); stats.InFlight != 0 {
+		t.Errorf("in flight = %d after both finished, want 0", stats.InFlight)
+	}
+}
+
+func TestTransportBreakerOpensHalfOpensAndCloses(t *testing.T) {
+	var healthy atomic.Bool
+	var attempts atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := testConfig()
+	config.MaxRetries = 0
+	config.FailureThreshold = 2
+	config.OpenDuration = time.Minute
+	transport := NewTransport("test", nil, config)
+	now := time.Now()
+	transport.breaker.Now = func() time.Time { return now }
+	client := NewClient(transport)
+
+	// Two provider failures open the breaker
+	for i := 0; i < 2; i++ {
+		post(t, client, server.URL, "{}")
+	}
+	if state := transport.Stats().State; state != StateOpen {
+		t.Fatalf("state after failures = %s, want open", state)
+	}
+
+	// While open, calls fail fast without reaching the provider
+	_, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error while open = %v, want ErrCircuitOpen", err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("provider attempts = %d, want 2", got)
+	}
+
+	// Once OpenDuration has passed, one probe goes through
+	now = now.Add(config.OpenDuration)
+	if state := transport.Stats().State; state != StateHalfOpen {
+		t.Fatalf("state after OpenDuration = %s, want half_open", state)
+	}
+
+	// A failed probe reopens it
+	post(t, client, server.URL, "{}")
+	if state := transport.Stats().State; state != StateOpen {
+		t.Fatalf("state after failed probe = %s, want open", state)
+	}
+
+	// A successful probe closes it
+	now = now.Add(config.OpenDuration)
+	healthy.Store(true)
+	if resp := post(t, client, server.URL, "{}"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("probe status = %d, want 200", resp.StatusCode)
+	}
+	if state := transport.Stats().State; state != StateClosed {
+		t.Fatalf("state after successful probe = %s, want closed", state)
+	}
+	if stats := transport.Stats(); stats.Rejected != 1 {
+		t.Errorf("rejected = %d, want 1", stats.Rejected)
+	}
+}
+
+func TestTransportRateLimitsDoNotTripBreaker(t *testing.T) {
+	tooMany := http.StatusTooManyRequests
+	server := newScriptedServer(t, []int{tooMany, tooMany, tooMany}, nil)
+	config := testConfig()
+	config.MaxRetries = 3
+	config.FailureThreshold = 1
+	transport := NewTransport("test", nil, config)
+	recordSleeps(transport)
+
+	resp := post(t, NewClient(transport), server.URL, "{}")
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if state := transport.Stats().State; state != StateClosed {
+		t.Errorf("state = %s, want closed", state)
+	}
+}
+
+func TestTransportAttemptTimeoutCoversHeadersOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Headers right away, then a body that takes longer than AttemptTimeout
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 4; i++ {
+			io.WriteString(w, "token ")
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	config := testConfig()
+	config.AttemptTimeout = 100 * time.Millisecond
+	transport := NewTransport("test", nil, config)
+
+	resp := post(t, NewClient(transport), server.URL, "{}")
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the streamed body failed: %v", err)
+	}
+	if string(body) != strings.Repeat("token ", 4) {
+		t.Errorf("body = %q, want all four tokens", body)
+	}
+}
+
+func TestTransportAttemptTimeoutWaitingForHeaders(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := testConfig()
+	config.MaxRetries = 1
+	config.AttemptTimeout = 50 * time.Millisecond
+	transport := NewTransport("test", nil, config)
+	delays := recordSleeps(transport)
+
+	_, err := NewClient(transport).Post(server.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if len(*delays) != 1 {
+		t.Errorf("backoff sleeps = %d, want the timed-out attempt retried once", len(*delays))
+	}
+}
@@ -3,11 +3,63 @@ package services
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tanaymehhta/self/backend/internal/cache"
+	"github.com/tanaymehhta/self/backend/internal/prompts"
 )
 
 // AnswerExtractionService handles extracting specific answers from text chunks using LLM
 type AnswerExtractionService struct {
 	llmClient LLMClient
+	cache     *cache.Cache
+	options   ExtractionOptions
+}
+
+// ExtractionOptions bounds how ExtractAnswersFromChunks fans out LLM calls
+type ExtractionOptions struct {
+	Concurrency int           // Max LLM calls in flight
+	Timeout     time.Duration // Overall deadline for one request; 0 = none
+
+	// Stop once this many answers reach EarlyStopConfidence; 0 = never stop early
+	EarlyStopCount      int
+	EarlyStopConfidence float64
+
+	// Mode is ExtractionModePerChunk or ExtractionModeBatched. Batched mode sends
+	// several chunks per LLM call, packed up to BatchTokenBudget prompt tokens.
+	Mode             string
+	BatchTokenBudget int
+}
+
+const (
+	ExtractionModePerChunk = "per_chunk"
+	ExtractionModeBatched  = "batched"
+)
+
+// ExtractionReport describes how far an extraction got before returning
+type ExtractionReport struct {
+	Total        int  `json:"total"`     // Candidate chunks submitted
+	Processed    int  `json:"processed"` // Chunks that got an LLM response
+	Failed       int  `json:"failed"`
+	TimedOut     bool `json:"timed_out"`
+	StoppedEarly bool `json:"stopped_early"`
+}
+
+// Partial reports whether some candidates were never evaluated
+func (r ExtractionReport) Partial() bool {
+	return r.TimedOut || r.StoppedEarly
+}
+
+// DefaultExtractionOptions are used until SetExtractionOptions is called
+func DefaultExtractionOptions() ExtractionOptions {
+	return ExtractionOptions{
+		Concurrency:         5,
+		Timeout:             20 * time.Second,
+		EarlyStopConfidence: 0.8,
+		Mode:                ExtractionModePerChunk,
+		BatchTokenBudget:    6000,
+	}
 }
 
 // AnswerResult represents an extracted answer with metadata and confidence scoring
@@ -18,16 +70,34 @@ type AnswerResult struct {
 	HasAnswer  bool    `json:"has_answer"`
 
 	// Source attribution
-	ChunkID     string `json:"chunk_id"`
-	SourceChunk string `json:"source_chunk"`
-	SourceTitle string `json:"source_title"`
-	ContentType string `json:"content_type"`
+	ChunkID       string `json:"chunk_id"`
+	ContentItemID string `json:"content_item_id,omitempty"`
+	SourceChunk   string `json:"source_chunk"`
+	SourceTitle   string `json:"source_title"`
+	ContentType   string `json:"content_type"`
 
 	// Optional metadata
 	StartTime *float64 `json:"start_time,omitempty"`
 	EndTime   *float64 `json:"end_time,omitempty"`
 	Speaker   *string  `json:"speaker,omitempty"`
 	PageNum   *int     `json:"page_num,omitempty"`
+
+	// Grounding: where in SourceChunk the answer is supported (see VerifyGrounding)
+	Grounded       bool         `json:"grounded"`
+	GroundingScore float64      `json:"grounding_score"`
+	Spans          []SourceSpan `json:"spans,omitempty"`
+
+	// Prompt template the answer was extracted with, e.g. "answer_extraction@v1"
+	PromptVersion string `json:"prompt_version,omitempty"`
+
+	// Ranking: how the source was retrieved and the calibrated score (see AnswerRanker)
+	RetrievalScore    float64  `json:"retrieval_score"`
+	RetrievalRank     int      `json:"retrieval_rank,omitempty"`
+	Score             float64  `json:"score"`
+	DuplicateChunkIDs []string `json:"duplicate_chunk_ids,omitempty"` // Other chunks that gave the same answer
+
+	// Share of Score taken off because users marked this chunk wrong for similar queries
+	FeedbackPenalty float64 `json:"feedback_penalty,omitempty"`
 }
 
 // LLMResponse represents the structured response from the LLM
@@ -36,6 +106,9 @@ type LLMResponse struct {
 	Confidence float64 `json:"confidence"`
 	HasAnswer  bool    `json:"has_answer"`
 	Reasoning  string  `json:"reasoning"`
+
+	// Model that answered, when the client tries several (see FallbackClient)
+	Model string `json:"-"`
 }
 
 // LLMClient interface allows us to swap between OpenAI, Ollama, etc.
@@ -43,28 +116,80 @@ type LLMClient interface {
 	ExtractAnswer(ctx context.Context, query, chunk string) (*LLMResponse, error)
 }
 
+// modelNamer is implemented by clients that can report which model they call
+type modelNamer interface {
+	Model() string
+}
+
 // NewAnswerExtractionService creates a new answer extraction service
 func NewAnswerExtractionService(llmClient LLMClient) *AnswerExtractionService {
 	return &AnswerExtractionService{
 		llmClient: llmClient,
+		options:   DefaultExtractionOptions(),
+	}
+}
+
+// Model returns the model answers are extracted with, or "" if the client doesn't say
+func (s *AnswerExtractionService) Model() string {
+	if namer, ok := s.llmClient.(modelNamer); ok {
+		return namer.Model()
+	}
+	return ""
+}
+
+// SetExtractionOptions overrides concurrency, deadline and early-stop settings
+func (s *AnswerExtractionService) SetExtractionOptions(options ExtractionOptions) {
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	s.options = options
+}
+
+// SetCache enables the answer cache, keyed by (query, chunk, model, prompt version),
+// so answers from an old prompt version are never served for a new one
+func (s *AnswerExtractionService) SetCache(answerCache *cache.Cache) {
+	s.cache = answerCache
+}
+
+// InvalidateChunk drops cached answers extracted from a chunk
+func (s *AnswerExtractionService) InvalidateChunk(ctx context.Context, chunkID string) error {
+	if s.cache == nil {
+		return nil
 	}
+	return s.cache.Invalidate(ctx, "chunk:"+chunkID)
 }
 
 // ExtractAnswer processes a chunk and query to extract a specific answer
 func (s *AnswerExtractionService) ExtractAnswer(ctx context.Context, query, chunk string, sourceMetadata SourceMetadata) (*AnswerResult, error) {
-	llmResponse, err := s.llmClient.ExtractAnswer(ctx, query, chunk)
+	ctx, prompt, err := selectPrompt(ctx, prompts.AnswerExtraction)
+	if err != nil {
+		return nil, err
+	}
+
+	llmResponse, err := s.extractWithCache(ctx, prompt.ID(), query, chunk, sourceMetadata.ChunkID)
 	if err != nil {
 		return nil, fmt.Errorf("LLM answer extraction failed: %w", err)
 	}
 
+	result := newAnswerResult(llmResponse, chunk, sourceMetadata)
+	result.PromptVersion = prompt.ID()
+	return result, nil
+}
+
+// newAnswerResult attributes an LLM response to its source chunk
+func newAnswerResult(llmResponse *LLMResponse, chunk string, sourceMetadata SourceMetadata) *AnswerResult {
 	result := &AnswerResult{
-		Answer:      llmResponse.Answer,
-		Confidence:  llmResponse.Confidence,
-		HasAnswer:   llmResponse.HasAnswer,
-		ChunkID:     sourceMetadata.ChunkID,
-		SourceChunk: chunk,
-		SourceTitle: sourceMetadata.Title,
-		ContentType: sourceMetadata.ContentType,
+		Answer:        llmResponse.Answer,
+		Confidence:    llmResponse.Confidence,
+		HasAnswer:     llmResponse.HasAnswer,
+		ChunkID:       sourceMetadata.ChunkID,
+		ContentItemID: sourceMetadata.ContentItemID,
+		SourceChunk:   chunk,
+		SourceTitle:   sourceMetadata.Title,
+		ContentType:   sourceMetadata.ContentType,
+
+		RetrievalScore: sourceMetadata.RetrievalScore,
+		RetrievalRank:  sourceMetadata.RetrievalRank,
 	}
 
 	// Add content-specific metadata
@@ -81,57 +206,247 @@ func (s *AnswerExtractionService) ExtractAnswer(ctx context.Context, query, chun
 		result.Speaker = sourceMetadata.Speaker
 	}
 
-	return result, nil
+	// Flag and downgrade answers the chunk doesn't actually support
+	applyGrounding(result)
+
+	return result
 }
 
-// ExtractAnswersFromChunks processes multiple chunks for a query
-func (s *AnswerExtractionService) ExtractAnswersFromChunks(ctx context.Context, query string, chunks []ChunkWithMetadata) ([]*AnswerResult, error) {
-	var results []*AnswerResult
+// extractWithCache calls the LLM unless the same chunk was already asked the same
+// question with the same model and prompt. The chunk text is part of the key, so
+// edited chunks miss even before they are explicitly invalidated.
+func (s *AnswerExtractionService) extractWithCache(ctx context.Context, promptID, query, chunk, chunkID string) (*LLMResponse, error) {
+	if s.cache == nil {
+		return s.llmClient.ExtractAnswer(ctx, query, chunk)
+	}
+
+	var cached LLMResponse
+	if s.cache.GetJSON(ctx, s.cacheKey(promptID, query, chunkID, chunk, ""), &cached) {
+		return &cached, nil
+	}
+
+	llmResponse, err := s.llmClient.ExtractAnswer(ctx, query, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.SetJSON(ctx, s.cacheKey(promptID, query, chunkID, chunk, llmResponse.Model), llmResponse, "chunk:"+chunkID)
+	return llmResponse, nil
+}
+
+// cacheKey keys an answer by the model that gave it; "" means the client's
+// current model, as used for lookups
+func (s *AnswerExtractionService) cacheKey(promptID, query, chunkID, chunk, model string) string {
+	if model == "" {
+		model = s.Model()
+	}
+	if model == "" {
+		model = "unknown"
+	}
+	return cache.Key(query, chunkID, chunk, model, promptID)
+}
 
-	for _, chunk := range chunks {
-		result, err := s.ExtractAnswer(ctx, query, chunk.Text, chunk.Metadata)
+// extractBatch answers several chunks with one LLM call. Cached chunks are
+// served from the cache and left out of the prompt. Results align with chunks.
+func (s *AnswerExtractionService) extractBatch(ctx context.Context, client BatchLLMClient, query string, chunks []ChunkWithMetadata) ([]*AnswerResult, error) {
+	ctx, prompt, err := selectPrompt(ctx, prompts.BatchExtraction)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*LLMResponse, len(chunks))
+
+	var (
+		missing []int
+		texts   []string
+	)
+	for i, chunk := range chunks {
+		if s.cache != nil {
+			var cached LLMResponse
+			if s.cache.GetJSON(ctx, s.cacheKey(prompt.ID(), query, chunk.Metadata.ChunkID, chunk.Text, ""), &cached) {
+				responses[i] = &cached
+				continue
+			}
+		}
+		missing = append(missing, i)
+		texts = append(texts, chunk.Text)
+	}
+
+	if len(missing) > 0 {
+		batchResponses, err := client.ExtractAnswers(ctx, query, texts)
 		if err != nil {
-			fmt.Printf("Error extracting answer from chunk %s: %v\n", chunk.Metadata.ChunkID, err)
-			continue
+			return nil, fmt.Errorf("LLM batch answer extraction failed: %w", err)
 		}
 
-		if result.HasAnswer && result.Confidence > 0.1 {
-			results = append(results, result)
+		for j, i := range missing {
+			responses[i] = batchResponses[j]
+			if s.cache != nil {
+				chunk := chunks[i]
+				s.cache.SetJSON(ctx, s.cacheKey(prompt.ID(), query, chunk.Metadata.ChunkID, chunk.Text, batchResponses[j].Model), batchResponses[j], "chunk:"+chunk.Metadata.ChunkID)
+			}
 		}
 	}
 
+	results := make([]*AnswerResult, len(chunks))
+	for i, chunk := range chunks {
+		results[i] = newAnswerResult(responses[i], chunk.Text, chunk.Metadata)
+		results[i].PromptVersion = prompt.ID()
+	}
 	return results, nil
 }
 
-// SourceMetadata contains attribution info for the chunk
-type SourceMetadata struct {
-	ChunkID     string
-	Title       string
-	ContentType string
-	PageNum     *int
-	StartTime   *float64
-	EndTime     *float64
-	Speaker     *string
+// extractionUnits groups chunk indexes into the units of work sent to the LLM:
+// one chunk per call, or token-budgeted batches when batching is enabled and
+// the client supports it
+func (s *AnswerExtractionService) extractionUnits(ctx context.Context, query string, chunks []ChunkWithMetadata) ([][]int, BatchLLMClient) {
+	if batchClient, ok := s.llmClient.(BatchLLMClient); ok && s.options.Mode == ExtractionModeBatched {
+		if _, prompt, err := selectPrompt(ctx, prompts.BatchExtraction); err == nil {
+			// The shared tokenizer sizes batches; it's only built once batching is used
+			return planExtractionBatches(sharedTokenizer(), prompt.System, query, chunks, s.options.BatchTokenBudget), batchClient
+		}
+	}
+
+	units := make([][]int, len(chunks))
+	for i := range chunks {
+		units[i] = []int{i}
+	}
+	return units, nil
 }
 
-// ChunkWithMetadata pairs chunk text with its source metadata
-type ChunkWithMetadata struct {
-	Text     string
-	Metadata SourceMetadata
+// ExtractAnswersFromChunks processes multiple chunks for a query
+func (s *AnswerExtractionService) ExtractAnswersFromChunks(ctx context.Context, query string, chunks []ChunkWithMetadata) ([]*AnswerResult, error) {
+	results, _ := s.ExtractAnswersWithReport(ctx, query, chunks, s.options.EarlyStopCount)
+	return results, nil
 }
 
-// RankAnswersByConfidence sorts answers by confidence score (highest first)
-func RankAnswersByConfidence(answers []*AnswerResult) []*AnswerResult {
-	ranked := make([]*AnswerResult, len(answers))
-	copy(ranked, answers)
+// ExtractAnswersWithReport runs extraction over chunks with at most
+// options.Concurrency calls in flight. It returns whatever answers are in when
+// the deadline passes, and cancels the remaining calls once `enough` answers
+// reach the early-stop confidence (enough <= 0 disables early stopping).
+// Answers keep the order of their chunks.
+func (s *AnswerExtractionService) ExtractAnswersWithReport(ctx context.Context, query string, chunks []ChunkWithMetadata, enough int) ([]*AnswerResult, ExtractionReport) {
+	report := ExtractionReport{Total: len(chunks)}
+	if len(chunks) == 0 {
+		return nil, report
+	}
+
+	if s.options.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, s.options.Timeout)
+		defer cancelTimeout()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := s.options.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		confident int
+		answers   = make([]*AnswerResult, len(chunks))
+		semaphore = make(chan struct{}, concurrency)
+	)
 
-	for i := 0; i < len(ranked)-1; i++ {
-		for j := i + 1; j < len(ranked); j++ {
-			if ranked[i].Confidence < ranked[j].Confidence {
-				ranked[i], ranked[j] = ranked[j], ranked[i]
+	units, batchClient := s.extractionUnits(ctx, query, chunks)
+
+dispatch:
+	for _, unit := range units {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+
+		wg.Add(1)
+		go func(unit []int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			var (
+				results []*AnswerResult
+				err     error
+			)
+			if batchClient != nil {
+				batch := make([]ChunkWithMetadata, len(unit))
+				for j, i := range unit {
+					batch[j] = chunks[i]
+				}
+				results, err = s.extractBatch(ctx, batchClient, query, batch)
+			} else {
+				chunk := chunks[unit[0]]
+				var result *AnswerResult
+				result, err = s.ExtractAnswer(ctx, query, chunk.Text, chunk.Metadata)
+				results = []*AnswerResult{result}
 			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				// Calls cut off by the deadline or early stop are not failures
+				if ctx.Err() == nil {
+					report.Failed += len(unit)
+					fmt.Printf("Error extracting answers from %d chunk(s) starting at %s: %v\n", len(unit), chunks[unit[0]].Metadata.ChunkID, err)
+				}
+				return
+			}
+			report.Processed += len(unit)
+
+			for j, i := range unit {
+				result := results[j]
+				if result.HasAnswer && result.Confidence > 0.1 {
+					answers[i] = result
+					if result.Confidence >= s.options.EarlyStopConfidence {
+						confident++
+					}
+				}
+			}
+
+			if enough > 0 && confident >= enough && ctx.Err() == nil {
+				report.StoppedEarly = true
+				cancel()
+			}
+		}(unit)
+	}
+
+	wg.Wait()
+
+	if !report.StoppedEarly && ctx.Err() != nil && report.Processed+report.Failed < report.Total {
+		report.TimedOut = true
+		fmt.Printf("Answer extraction deadline reached: %d/%d chunks processed\n", report.Processed, report.Total)
+	}
+
+	var results []*AnswerResult
+	for _, answer := range answers {
+		if answer != nil {
+			results = append(results, answer)
 		}
 	}
 
-	return ranked
-}
\ No newline at end of file
+	return results, report
+}
+
+// SourceMetadata contains attribution info for the chunk
+type SourceMetadata struct {
+	ChunkID       string
+	ContentItemID string
+	Title         string
+	ContentType   string
+	PageNum       *int
+	StartTime     *float64
+	EndTime       *float64
+	Speaker       *string
+
+	RetrievalScore float64 // 0-1, relative to the best candidate chunk
+	RetrievalRank  int     // 1-based position among the candidate chunks
+}
+
+// ChunkWithMetadata pairs chunk text with its source metadata
+type ChunkWithMetadata struct {
+	Text     string
+	Metadata SourceMetadata
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RankingWeights sets how much each signal contributes to an answer's score.
+// Weights are relative; they are normalised by their sum.
+type RankingWeights struct {
+	Retrieval  float64 `json:"retrieval"`  // How well the source chunk matched the query
+	Confidence float64 `json:"confidence"` // The LLM's self-reported confidence
+	Grounding  float64 `json:"grounding"`  // Share of the answer found in the chunk
+	Authority  float64 `json:"authority"`  // Reliability of the source's content type
+}
+
+// DefaultRankingWeights favours the LLM's confidence but lets retrieval and
+// grounding overrule it when it is overconfident about a weak source
+func DefaultRankingWeights() RankingWeights {
+	return RankingWeights{
+		Retrieval:  0.3,
+		Confidence: 0.4,
+		Grounding:  0.2,
+		Authority:  0.1,
+	}
+}
+
+// ParseRankingWeights overlays a JSON object such as {"retrieval": 0.5} onto
+// the default weights
+func ParseRankingWeights(raw string) (RankingWeights, error) {
+	weights := DefaultRankingWeights()
+	if raw == "" {
+		return weights, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &weights); err != nil {
+		return DefaultRankingWeights(), fmt.Errorf("invalid ranking weights: %w", err)
+	}
+	if weights.Retrieval < 0 || weights.Confidence < 0 || weights.Grounding < 0 || weights.Authority < 0 {
+		return DefaultRankingWeights(), fmt.Errorf("invalid ranking weights: weights can't be negative")
+	}
+	if weights.total() == 0 {
+		return DefaultRankingWeights(), fmt.Errorf("invalid ranking weights: at least one weight must be positive")
+	}
+	return weights, nil
+}
+
+func (w RankingWeights) total() float64 {
+	return w.Retrieval + w.Confidence + w.Grounding + w.Authority
+}
+
+// duplicateAnswerSimilarity is the word-overlap (Jaccard) above which two
+// answers are treated as the same answer found in overlapping chunks
+const duplicateAnswerSimilarity = 0.8
+
+// AnswerRanker orders extracted answers by a calibrated score and folds
+// duplicates into the best-scored copy
+type AnswerRanker struct {
+	weights RankingWeights
+}
+
+func NewAnswerRanker(weights RankingWeights) *AnswerRanker {
+	if weights.total() <= 0 {
+		weights = DefaultRankingWeights()
+	}
+	return &AnswerRanker{weights: weights}
+}
+
+// Score combines retrieval relevance, confidence, grounding and source
+// authority into a 0-1 score
+func (r *AnswerRanker) Score(answer *AnswerResult) float64 {
+	w := r.weights
+	score := w.Retrieval*answer.RetrievalScore +
+		w.Confidence*answer.Confidence +
+		w.Grounding*answer.GroundingScore +
+		w.Authority*sourceAuthority(answer.ContentType)
+	return score / w.total() * (1 - answer.FeedbackPenalty)
+}
+
+// Rank scores the answers, drops duplicates and returns them best first. Ties
+// keep retrieval order. Answers are scored in place.
+func (r *AnswerRanker) Rank(answers []*AnswerResult) []*AnswerResult {
+	ranked := make([]*AnswerResult, 0, len(answers))
+	for _, answer := range answers {
+		if answer == nil {
+			continue
+		}
+		answer.Score = r.Score(answer)
+		ranked = append(ranked, answer)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return retrievalOrder(ranked[i]) < retrievalOrder(ranked[j])
+	})
+
+	return dedupeAnswers(ranked)
+}
+
+// retrievalOrder sorts answers without a retrieval rank last
+func retrievalOrder(answer *AnswerResult) int {
+	if answer.RetrievalRank <= 0 {
+		return int(^uint(0) >> 1)
+	}
+	return answer.RetrievalRank
+}
+
+// dedupeAnswers keeps the first of each group of near-identical answers in an
+// already ranked list, recording the other copies' chunks on it
+func dedupeAnswers(ranked []*AnswerResult) []*AnswerResult {
+	var (
+		kept     []*AnswerResult
+		keptText []map[string]bool
+	)
+
+	for _, answer := range ranked {
+		words := answerWords(answer.Answer)
+
+		duplicate := -1
+		if answer.HasAnswer && len(words) > 0 {
+			for i, other := range kept {
+				if other.HasAnswer && jaccard(words, keptText[i]) >= duplicateAnswerSimilarity {
+					duplicate = i
+					break
+				}
+			}
+		}
+
+		if duplicate >= 0 {
+			original := kept[duplicate]
+			if answer.ChunkID != "" && answer.ChunkID != original.ChunkID {
+				original.DuplicateChunkIDs = append(original.DuplicateChunkIDs, answer.ChunkID)
+			}
+			continue
+		}
+
+		kept = append(kept, answer)
+		keptText = append(keptText, words)
+	}
+
+	return kept
+}
+
+// answerWords is the set of lowercased words in an answer, without fillers
+func answerWords(answer string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range groundingWord.FindAllString(strings.ToLower(answer), -1) {
+		if !groundingFillers[word] {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// retrievalFusionK damps the reciprocal rank fusion of vector and full-text
+// results; 60 is the usual choice and keeps top ranks from dominating
+const retrievalFusionK = 60
+
+// fuseRetrievalScores scores chunks by reciprocal rank fusion over the vector
+// and full-text result lists, scaled so the best chunk scores 1. Vector and
+// ts_rank relevances aren't on comparable scales, but their ranks are.
+func fuseRetrievalScores(resultLists ...[]SearchResult) map[string]float64 {
+	fused := make(map[string]float64)
+	for _, results := range resultLists {
+		for rank, result := range results {
+			fused[result.ID] += 1 / float64(retrievalFusionK+rank+1)
+		}
+	}
+
+	best := 0.0
+	for _, score := range fused {
+		if score > best {
+			best = score
+		}
+	}
+	if best > 0 {
+		for id, score := range fused {
+			fused[id] = score / best
+		}
+	}
+	return fused
+}
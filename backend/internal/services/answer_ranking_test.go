@@ -0,0 +1,247 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestAnswerRankerScore(t *testing.T) {
+	strong := AnswerResult{RetrievalScore: 1, Confidence: 1, GroundingScore: 1, ContentType: "document"}
+
+	tests := []struct {
+		name    string
+		weights RankingWeights
+		answer  AnswerResult
+		want    float64
+	}{
+		{"every signal maxed", DefaultRankingWeights(), strong, 1},
+		{"nothing but authority", DefaultRankingWeights(), AnswerResult{ContentType: "webpage"}, 0.07},
+		{"unknown content type", DefaultRankingWeights(), AnswerResult{ContentType: "scan"}, 0.08},
+		{"default blend", DefaultRankingWeights(),
+			AnswerResult{RetrievalScore: 0.5, Confidence: 0.8, GroundingScore: 0.25, ContentType: "email"}, 0.61},
+		{"retrieval only", RankingWeights{Retrieval: 1}, AnswerResult{RetrievalScore: 0.4, Confidence: 1}, 0.4},
+		{"weights normalised by their sum", RankingWeights{Retrieval: 2, Confidence: 2},
+			AnswerResult{RetrievalScore: 1, Confidence: 0}, 0.5},
+		{"zero weights fall back to defaults", RankingWeights{}, strong, 1},
+		{"feedback penalty", DefaultRankingWeights(),
+			AnswerResult{RetrievalScore: 1, Confidence: 1, GroundingScore: 1, ContentType: "document", FeedbackPenalty: 0.25}, 0.75},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewAnswerRanker(tt.weights).Score(&tt.answer)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnswerRankerRank(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers []*AnswerResult
+		want    []string
+	}{
+		{
+			name: "best score first",
+			answers: []*AnswerResult{
+				{ChunkID: "low", Answer: "Lyon", HasAnswer: true, Confidence: 0.2, RetrievalRank: 1},
+				{ChunkID: "high", Answer: "Paris", HasAnswer: true, Confidence: 0.9, RetrievalRank: 2},
+			},
+			want: []string{"high", "low"},
+		},
+		{
+			name: "ties keep retrieval order",
+			answers: []*AnswerResult{
+				{ChunkID: "third", Answer: "c", HasAnswer: true, Confidence: 0.5, RetrievalRank: 3},
+				{ChunkID: "first", Answer: "a", HasAnswer: true, Confidence: 0.5, RetrievalRank: 1},
+				{ChunkID: "second", Answer: "b", HasAnswer: true, Confidence: 0.5, RetrievalRank: 2},
+			},
+			want: []string{"first", "second", "third"},
+		},
+		{
+			name: "unranked ties go last",
+			answers: []*AnswerResult{
+				{ChunkID: "unranked", Answer: "a", HasAnswer: true, Confidence: 0.5},
+				{ChunkID: "ranked", Answer: "b", HasAnswer: true, Confidence: 0.5, RetrievalRank: 4},
+			},
+			want: []string{"ranked", "unranked"},
+		},
+		{
+			name: "nil answers skipped",
+			answers: []*AnswerResult{
+				nil,
+				{ChunkID: "only", Answer: "a", HasAnswer: true, Confidence: 0.5, RetrievalRank: 1},
+			},
+			want: []string{"only"},
+		},
+		{
+			name: "duplicates folded into the best copy",
+			answers: []*AnswerResult{
+				{ChunkID: "weak", Answer: "The capital of France is Paris", HasAnswer: true, Confidence: 0.4, RetrievalRank: 1},
+				{ChunkID: "strong", Answer: "Paris is the capital of France", HasAnswer: true, Confidence: 0.9, RetrievalRank: 2},
+			},
+			want: []string{"strong"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked := NewAnswerRanker(DefaultRankingWeights()).Rank(tt.answers)
+
+			var got []string
+			for _, answer := range ranked {
+				got = append(got, answer.ChunkID)
+				if answer.Score == 0 {
+					t.Errorf("%s was not scored", answer.ChunkID)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Rank = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupeAnswers(t *testing.T) {
+	tests := []struct {
+		name           string
+		answers        []*AnswerResult
+		wantKept       []string
+		wantDuplicates []string // DuplicateChunkIDs of the first kept answer
+	}{
+		{
+			name: "reworded answer folded",
+			answers: []*AnswerResult{
+				{ChunkID: "a", Answer: "Paris is the capital of France", HasAnswer: true},
+				{ChunkID: "b", Answer: "the capital of France is Paris.", HasAnswer: true},
+				{ChunkID: "c", Answer: "Paris, capital of France", HasAnswer: true},
+			},
+			wantKept:       []string{"a"},
+			wantDuplicates: []string{"b", "c"},
+		},
+		{
+			name: "different answers kept",
+			answers: []*AnswerResult{
+				{ChunkID: "a", Answer: "Paris is the capital of France", HasAnswer: true},
+				{ChunkID: "b", Answer: "Berlin is the capital of Germany", HasAnswer: true},
+			},
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name: "same chunk not recorded twice",
+			answers: []*AnswerResult{
+				{ChunkID: "a", Answer: "Paris", HasAnswer: true},
+				{ChunkID: "a", Answer: "Paris", HasAnswer: true},
+				{Answer: "Paris", HasAnswer: true},
+			},
+			wantKept: []string{"a"},
+		},
+		{
+			name: "non-answers never folded",
+			answers: []*AnswerResult{
+				{ChunkID: "a", Answer: "", HasAnswer: false},
+				{ChunkID: "b", Answer: "", HasAnswer: false},
+				{ChunkID: "c", Answer: "Not stated", HasAnswer: false},
+				{ChunkID: "d", Answer: "Not stated", HasAnswer: false},
+			},
+			wantKept: []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "fillers only",
+			answers: []*AnswerResult{
+				{ChunkID: "a", Answer: "it is", HasAnswer: true},
+				{ChunkID: "b", Answer: "it is", HasAnswer: true},
+			},
+			wantKept: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept := dedupeAnswers(tt.answers)
+
+			var got []string
+			for _, answer := range kept {
+				got = append(got, answer.ChunkID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantKept, ",") {
+				t.Fatalf("kept = %v, want %v", got, tt.wantKept)
+			}
+			if duplicates := kept[0].DuplicateChunkIDs; strings.Join(duplicates, ",") != strings.Join(tt.wantDuplicates, ",") {
+				t.Errorf("duplicates = %v, want %v", duplicates, tt.wantDuplicates)
+			}
+		})
+	}
+}
+
+func TestFuseRetrievalScores(t *testing.T) {
+	vector := []SearchResult{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	fulltext := []SearchResult{{ID: "b"}, {ID: "d"}}
+
+	fused := fuseRetrievalScores(vector, fulltext)
+
+	// b is found by both searches, so it outranks a despite a's top vector rank
+	rrf := func(ranks ...int) float64 {
+		score := 0.0
+		for _, rank := range ranks {
+			score += 1 / float64(retrievalFusionK+rank)
+		}
+		return score
+	}
+	best := rrf(2, 1)
+	want := map[string]float64{
+		"a": rrf(1) / best,
+		"b": 1,
+		"c": rrf(3) / best,
+		"d": rrf(2) / best,
+	}
+
+	if len(fused) != len(want) {
+		t.Fatalf("fused %d chunks, want %d", len(fused), len(want))
+	}
+	for id, score := range want {
+		if math.Abs(fused[id]-score) > 1e-9 {
+			t.Errorf("%s = %v, want %v", id, fused[id], score)
+		}
+	}
+	if fused["a"] <= fused["d"] || fused["d"] <= fused["c"] {
+		t.Errorf("scores = %v, want a > d > c", fused)
+	}
+
+	if got := fuseRetrievalScores(); len(got) != 0 {
+		t.Errorf("fusing nothing = %v, want empty", got)
+	}
+	if got := fuseRetrievalScores([]SearchResult{{ID: "solo"}}); got["solo"] != 1 {
+		t.Errorf("single result = %v, want it scaled to 1", got["solo"])
+	}
+}
+
+func TestParseRankingWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    RankingWeights
+		wantErr bool
+	}{
+		{"empty uses defaults", "", DefaultRankingWeights(), false},
+		{"overlay", `{"retrieval": 0.5}`, RankingWeights{Retrieval: 0.5, Confidence: 0.4, Grounding: 0.2, Authority: 0.1}, false},
+		{"invalid json", `{retrieval`, DefaultRankingWeights(), true},
+		{"negative weight", `{"grounding": -1}`, DefaultRankingWeights(), true},
+		{"all zero", `{"retrieval": 0, "confidence": 0, "grounding": 0, "authority": 0}`, DefaultRankingWeights(), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRankingWeights(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRankingWeights(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRankingWeights(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BatchLLMClient is implemented by clients that can extract answers from several
+// numbered chunks in one call, so the system prompt is paid for once per batch
+type BatchLLMClient interface {
+	LLMClient
+	ExtractAnswers(ctx context.Context, query string, chunks []string) ([]*LLMResponse, error)
+}
+
+// batchAnswerTokens is the output budget per chunk in a batched call
+const batchAnswerTokens = 200
+
+// batchExtractionItem is one element of the batched JSON array
+type batchExtractionItem struct {
+	Chunk      int     `json:"chunk"`
+	Answer     string  `json:"answer"`
+	Confidence float64 `json:"confidence"`
+	HasAnswer  bool    `json:"has_answer"`
+}
+
+// batchExtractionVariables fills the batch_extraction prompt, numbering chunks
+// from 1 so they can be mapped back
+func batchExtractionVariables(query string, chunks []string) map[string]string {
+	numbered := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		numbered[i] = fmt.Sprintf("[Chunk %d]\n%s", i+1, chunk)
+	}
+
+	return map[string]string{
+		"query":  query,
+		"chunks": strings.Join(numbered, "\n\n"),
+		"count":  strconv.Itoa(len(chunks)),
+	}
+}
+
+// batchExtractionItems accepts the bare array or the {"results": [...]} object
+// that JSON-mode providers return, and requires the answer fields on every item
+type batchExtractionItems []batchExtractionItem
+
+func (b *batchExtractionItems) UnmarshalJSON(data []byte) error {
+	raw := data
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var envelope struct {
+			Results json.RawMessage `json:"results"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil || envelope.Results == nil {
+			return fmt.Errorf("expected a JSON array or an object with a \"results\" array")
+		}
+		raw = envelope.Results
+	}
+
+	if err := validateRequiredFields(raw, answerFields); err != nil {
+		return err
+	}
+
+	var items []batchExtractionItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return err
+	}
+	*b = items
+	return nil
+}
+
+// extractBatchStructured runs a batched call through GenerateStructured and maps
+// the items back onto the chunks
+func extractBatchStructured(ctx context.Context, provider string, call StructuredCall, chunkCount int) ([]*LLMResponse, error) {
+	var items batchExtractionItems
+	if err := GenerateStructured(ctx, provider, call, &items, nil); err != nil {
+		return nil, err
+	}
+	return mapBatchExtractionItems(items, chunkCount), nil
+}
+
+// mapBatchExtractionItems maps the model's items back onto the chunks. Chunks
+// the model skipped or numbered out of range come back as "no answer".
+func mapBatchExtractionItems(items []batchExtractionItem, chunkCount int) []*LLMResponse {
+	responses := make([]*LLMResponse, chunkCount)
+	for position, item := range items {
+		index := item.Chunk - 1
+		if item.Chunk == 0 {
+			// Fall back to array position when the model omits the number
+			index = position
+		}
+		if index < 0 || index >= chunkCount || responses[index] != nil {
+			continue
+		}
+
+		confidence := item.Confidence
+		if confidence < 0.0 {
+			confidence = 0.0
+		}
+		if confidence > 1.0 {
+			confidence = 1.0
+		}
+
+		responses[index] = &LLMResponse{
+			Answer:     item.Answer,
+			Confidence: confidence,
+			HasAnswer:  item.HasAnswer,
+		}
+	}
+
+	for i := range responses {
+		if responses[i] == nil {
+			responses[i] = &LLMResponse{Reasoning: "Chunk missing from batch response"}
+		}
+	}
+
+	return responses
+}
+
+// planExtractionBatches greedily packs chunk indexes into batches whose prompt
+// fits within tokenBudget. A chunk larger than the budget gets a batch to itself.
+func planExtractionBatches(tokenizer *TokenizerService, systemPrompt, query string, chunks []ChunkWithMetadata, tokenBudget int) [][]int {
+	countTokens := func(text string) int {
+		if tokenizer == nil {
+			// Rough approximation when no encoder is available
+			return len(text)/4 + 1
+		}
+		return tokenizer.CountTokens(text)
+	}
+
+	overhead := countTokens(systemPrompt) + countTokens(query) + 50
+
+	var (
+		batches [][]int
+		current []int
+		used    = overhead
+	)
+	for i, chunk := range chunks {
+		chunkTokens := countTokens(chunk.Text) + 10 // "[Chunk n]" header
+		if len(current) > 0 && used+chunkTokens > tokenBudget {
+			batches = append(batches, current)
+			current = nil
+			used = overhead
+		}
+		current = append(current, i)
+		used += chunkTokens
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+
+	return batches
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tanaymehhta/self/backend/internal/models"
+)
+
+// ChatMessageNode is a chat message with its place in the conversation tree.
+// Regenerating a reply or editing a question adds a sibling under the same
+// parent; the conversation's active branch runs from a root to its active message.
+type ChatMessageNode struct {
+	models.ChatMessage
+	ParentID *uuid.UUID `json:"parent_id"`
+
+	// Alternatives to this message under the same parent, itself included, oldest first
+	SiblingIDs []uuid.UUID `json:"sibling_ids" gorm:"-"`
+}
+
+var (
+	// ErrMessageNotFound is returned for messages outside the conversation
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrNotAssistantMessage is returned when regenerating a user message
+	ErrNotAssistantMessage = errors.New("only assistant replies can be regenerated")
+
+	// ErrNotUserMessage is returned when editing an assistant reply
+	ErrNotUserMessage = errors.New("only user messages can be edited")
+)
+
+// chatTurn places a new exchange in the conversation tree. The zero value
+// appends a user message and its reply to the active branch.
+type chatTurn struct {
+	fork     bool       // Start a branch under parentID instead of following the active branch
+	parentID *uuid.UUID // With fork, the parent of the new user message; nil for a new root
+
+	// Answer this existing user message again instead of saving a new one
+	userMessage *ChatMessageNode
+}
+
+// messageTree is every message of a conversation, indexed by ID and by parent
+type messageTree struct {
+	nodes    map[uuid.UUID]*ChatMessageNode
+	children map[uuid.UUID][]uuid.UUID // Oldest first; roots are under uuid.Nil
+}
+
+// loadMessageTree reads all of a conversation's messages
+func (cs *ChatService) loadMessageTree(conversationID uuid.UUID) (*messageTree, error) {
+	var messages []ChatMessageNode
+	err := cs.db.Table("chat_messages").
+		Where("conversation_id = ?", conversationID).
+		Order("created_at ASC, id ASC").
+		Scan(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &messageTree{
+		nodes:    make(map[uuid.UUID]*ChatMessageNode, len(messages)),
+		children: make(map[uuid.UUID][]uuid.UUID),
+	}
+	for i := range messages {
+		message := &messages[i]
+		parent := uuid.Nil
+		if message.ParentID != nil {
+			parent = *message.ParentID
+		}
+		tree.nodes[message.ID] = message
+		tree.children[parent] = append(tree.children[parent], message.ID)
+	}
+	return tree, nil
+}
+
+// branch returns the messages from the root down to leafID, with their siblings
+func (t *messageTree) branch(leafID uuid.UUID) []ChatMessageNode {
+	var path []ChatMessageNode
+	for id := leafID; len(path) < len(t.nodes); {
+		node, ok := t.nodes[id]
+		if !ok {
+			break
+		}
+
+		parent := uuid.Nil
+		if node.ParentID != nil {
+			parent = *node.ParentID
+		}
+		message := *node
+		message.SiblingIDs = t.children[parent]
+		path = append(path, message)
+
+		if node.ParentID == nil {
+			break
+		}
+		id = *node.ParentID
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// latestLeaf follows the most recent reply down from id to the end of its branch
+func (t *messageTree) latestLeaf(id uuid.UUID) uuid.UUID {
+	for steps := 0; steps < len(t.nodes); steps++ {
+		replies := t.children[id]
+		if len(replies) == 0 {
+			break
+		}
+		id = replies[len(replies)-1]
+	}
+	return id
+}
+
+// activeMessageID returns the last message of the conversation's active
+// branch, or nil before the first message
+func (cs *ChatService) activeMessageID(conversationID uuid.UUID) (*uuid.UUID, error) {
+	var row struct {
+		ActiveMessageID *uuid.UUID
+	}
+	err := cs.db.Table("chat_conversations").
+		Select("active_message_id").
+		Where("id = ?", conversationID).
+		Scan(&row).Error
+	return row.ActiveMessageID, err
+}
+
+// activeBranch returns the messages of the conversation's active branch, root first
+func (cs *ChatService) activeBranch(conversationID uuid.UUID) ([]ChatMessageNode, error) {
+	activeID, err := cs.activeMessageID(conversationID)
+	if err != nil || activeID == nil {
+		return []ChatMessageNode{}, err
+	}
+	return cs.branchTo(conversationID, *activeID)
+}
+
+// branchTo returns the messages from the root down to leafID, root first
+func (cs *ChatService) branchTo(conversationID, leafID uuid.UUID) ([]ChatMessageNode, error) {
+	tree, err := cs.loadMessageTree(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return tree.branch(leafID), nil
+}
+
+// getMessageNode returns a message of the conversation with its parent
+func getMessageNode(db *gorm.DB, conversationID, messageID uuid.UUID) (*ChatMessageNode, error) {
+	var messages []ChatMessageNode
+	err := db.Table("chat_messages").
+		Where("id = ? AND conversation_id = ?", messageID, conversationID).
+		Limit(1).
+		Scan(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return &messages[0], nil
+}
+
+// GetConversationMessages retrieves the messages of a conversation's active
+// branch, oldest first
+func (cs *ChatService) GetConversationMessages(userID uuid.UUID, conversationID uuid.UUID) ([]ChatMessageNode, error) {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+	return cs.activeBranch(conversationID)
+}
+
+// SelectBranch makes the branch through messageID active, continuing down its
+// most recent replies, and returns the branch's messages
+func (cs *ChatService) SelectBranch(userID, conversationID, messageID uuid.UUID) ([]ChatMessageNode, error) {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	tree, err := cs.loadMessageTree(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tree.nodes[messageID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	leafID := tree.latestLeaf(messageID)
+	err = cs.db.Table("chat_conversations").
+		Where("id = ?", conversationID).
+		Update("active_message_id", leafID).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to select branch: %w", err)
+	}
+
+	return tree.branch(leafID), nil
+}
+
+// RegenerateMessage answers the question behind an assistant reply again. The
+// new reply is a sibling of the old one and becomes the active branch; req
+// carries the retrieval settings (its Message is ignored).
+func (cs *ChatService) RegenerateMessage(ctx context.Context, userID, conversationID, messageID uuid.UUID, req ChatRequest) (*ChatResponse, error) {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	reply, err := getMessageNode(cs.db, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Role != "assistant" || reply.ParentID == nil {
+		return nil, ErrNotAssistantMessage
+	}
+	question, err := getMessageNode(cs.db, conversationID, *reply.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	req.ConversationID = &conversationID
+	req.Message = question.Content
+	return cs.processMessage(ctx, userID, req, chatTurn{userMessage: question}, nil)
+}
+
+// EditMessage asks req.Message in place of a user message. The edited question
+// starts a branch beside the original, which is kept, and becomes active.
+func (cs *ChatService) EditMessage(ctx context.Context, userID, conversationID, messageID uuid.UUID, req ChatRequest) (*ChatResponse, error) {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	original, err := getMessageNode(cs.db, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if original.Role != "user" {
+		return nil, ErrNotUserMessage
+	}
+
+	req.ConversationID = &conversationID
+	return cs.processMessage(ctx, userID, req, chatTurn{fork: true, parentID: original.ParentID}, nil)
+}
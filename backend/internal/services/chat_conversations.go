@@ -0,0 +1,289 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tanaymehhta/self/backend/internal/models"
+)
+
+// ChatConversationView is a conversation with its list state
+type ChatConversationView struct {
+	models.ChatConversation
+	Pinned     bool       `json:"pinned"`
+	Archived   bool       `json:"archived"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+// ConversationFilter selects and pages a user's conversations. Pinned
+// conversations come first, then the most recently active.
+type ConversationFilter struct {
+	Archived *bool  // nil lists archived and active conversations
+	Pinned   *bool  // nil lists pinned and unpinned conversations
+	Limit    int    // Default 20, at most 100
+	Cursor   string // NextCursor from the previous page
+}
+
+// ConversationPage is one page of conversations
+type ConversationPage struct {
+	Conversations []ChatConversationView `json:"conversations"`
+	NextCursor    string                 `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// ConversationUpdate changes a conversation's title or list state; nil fields are left alone
+type ConversationUpdate struct {
+	Title    *string `json:"title"` // Empty clears the title
+	Pinned   *bool   `json:"pinned"`
+	Archived *bool   `json:"archived"`
+}
+
+// ChatMessageMatch is a chat message found by SearchMessages
+type ChatMessageMatch struct {
+	MessageID         uuid.UUID `json:"message_id"`
+	ConversationID    uuid.UUID `json:"conversation_id"`
+	ConversationTitle *string   `json:"conversation_title"`
+	Archived          bool      `json:"archived"`
+	Role              string    `json:"role"`
+	Snippet           string    `json:"snippet"` // Matching passages, HTML-escaped, with terms in <b></b>
+	Rank              float64   `json:"rank"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+var (
+	// ErrInvalidCursor is returned for a malformed or tampered page cursor
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrTitleTooLong is returned when a title exceeds maxTitleLength characters
+	ErrTitleTooLong = fmt.Errorf("title must be at most %d characters", maxTitleLength)
+)
+
+// conversationCursor is the sort key of the last conversation on a page
+type conversationCursor struct {
+	Pinned       bool      `json:"p"`
+	LastActivity time.Time `json:"t"`
+	ID           uuid.UUID `json:"id"`
+}
+
+func encodeConversationCursor(conversation ChatConversationView) string {
+	data, _ := json.Marshal(conversationCursor{
+		Pinned:       conversation.Pinned,
+		LastActivity: conversation.LastActivity,
+		ID:           conversation.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeConversationCursor(cursor string) (*conversationCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var decoded conversationCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID == uuid.Nil {
+		return nil, ErrInvalidCursor
+	}
+	return &decoded, nil
+}
+
+// conversationColumns selects a conversation with its list state
+const conversationColumns = "chat_conversations.*, archived_at IS NOT NULL AS archived"
+
+// GetConversations retrieves a page of the user's chat conversations
+func (cs *ChatService) GetConversations(userID uuid.UUID, filter ConversationFilter) (*ConversationPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 20
+	}
+	if filter.Limit > 100 {
+		filter.Limit = 100
+	}
+
+	query := cs.db.Table("chat_conversations").
+		Select(conversationColumns).
+		Where("user_id = ?", userID)
+
+	if filter.Archived != nil {
+		if *filter.Archived {
+			query = query.Where("archived_at IS NOT NULL")
+		} else {
+			query = query.Where("archived_at IS NULL")
+		}
+	}
+	if filter.Pinned != nil {
+		query = query.Where("pinned = ?", *filter.Pinned)
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeConversationCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		// Rows after the cursor in (pinned DESC, last_activity DESC, id DESC) order
+		query = query.Where(
+			"(pinned < ?) OR (pinned = ? AND (last_activity < ? OR (last_activity = ? AND id < ?)))",
+			cursor.Pinned, cursor.Pinned, cursor.LastActivity, cursor.LastActivity, cursor.ID,
+		)
+	}
+
+	conversations := []ChatConversationView{}
+	err := query.Order("pinned DESC, last_activity DESC, id DESC").
+		Limit(filter.Limit + 1).
+		Scan(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ConversationPage{Conversations: conversations}
+	if len(conversations) > filter.Limit {
+		page.Conversations = conversations[:filter.Limit]
+		page.NextCursor = encodeConversationCursor(page.Conversations[filter.Limit-1])
+	}
+	return page, nil
+}
+
+// GetConversation returns one of the user's conversations
+func (cs *ChatService) GetConversation(userID, conversationID uuid.UUID) (*ChatConversationView, error) {
+	var conversations []ChatConversationView
+	err := cs.db.Table("chat_conversations").
+		Select(conversationColumns).
+		Where("id = ? AND user_id = ?", conversationID, userID).
+		Limit(1).
+		Scan(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return nil, ErrConversationNotFound
+	}
+	return &conversations[0], nil
+}
+
+// UpdateConversation renames, pins or archives a conversation
+func (cs *ChatService) UpdateConversation(userID, conversationID uuid.UUID, update ConversationUpdate) (*ChatConversationView, error) {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	changes := map[string]interface{}{"updated_at": now}
+	if update.Title != nil {
+		title := strings.TrimSpace(*update.Title)
+		if len([]rune(title)) > maxTitleLength {
+			return nil, ErrTitleTooLong
+		}
+		if title == "" {
+			changes["title"] = nil
+		} else {
+			changes["title"] = title
+		}
+	}
+	if update.Pinned != nil {
+		changes["pinned"] = *update.Pinned
+	}
+	if update.Archived != nil {
+		if *update.Archived {
+			// Keep the original archive time if it's archived again
+			changes["archived_at"] = gorm.Expr("COALESCE(archived_at, ?)", now)
+		} else {
+			changes["archived_at"] = nil
+		}
+	}
+
+	err := cs.db.Table("chat_conversations").
+		Where("id = ? AND user_id = ?", conversationID, userID).
+		Updates(changes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update conversation: %w", err)
+	}
+
+	return cs.GetConversation(userID, conversationID)
+}
+
+// DeleteConversation deletes a conversation with its messages, summary and
+// document links
+func (cs *ChatService) DeleteConversation(userID, conversationID uuid.UUID) error {
+	return cs.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&models.ChatConversation{}).
+			Where("id = ? AND user_id = ?", conversationID, userID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrConversationNotFound
+		}
+
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ConversationDocument{}).Error; err != nil {
+			return fmt.Errorf("failed to delete document links: %w", err)
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&ConversationSummary{}).Error; err != nil {
+			return fmt.Errorf("failed to delete summary: %w", err)
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ChatMessage{}).Error; err != nil {
+			return fmt.Errorf("failed to delete messages: %w", err)
+		}
+		if err := tx.Where("id = ? AND user_id = ?", conversationID, userID).Delete(&models.ChatConversation{}).Error; err != nil {
+			return fmt.Errorf("failed to delete conversation: %w", err)
+		}
+		return nil
+	})
+}
+
+// SearchMessages full-text searches the user's chat messages, best match first
+func (cs *ChatService) SearchMessages(userID uuid.UUID, query string, limit int) ([]ChatMessageMatch, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("query cannot be empty")
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	matches := []ChatMessageMatch{}
+	err := cs.db.Raw(`
+		SELECT m.id AS message_id, m.conversation_id, cc.title AS conversation_title,
+		       cc.archived_at IS NOT NULL AS archived, m.role, m.created_at,
+		       ts_headline('english', translate(m.content, ?, ''), q, ?) AS snippet,
+		       ts_rank(to_tsvector('english', m.content), q) AS rank
+		FROM chat_messages m
+		JOIN chat_conversations cc ON m.conversation_id = cc.id,
+		     plainto_tsquery('english', ?) q
+		WHERE cc.user_id = ?
+		  AND to_tsvector('english', m.content) @@ q
+		ORDER BY rank DESC, m.created_at DESC
+		LIMIT ?
+	`, headlineStart+headlineStop, headlineOptions, query, userID, limit).Scan(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range matches {
+		matches[i].Snippet = highlightSnippet(matches[i].Snippet)
+	}
+	return matches, nil
+}
+
+// ts_headline marks matched terms with private-use characters rather than
+// HTML, since it doesn't escape the message text around them. Any in the text
+// itself are removed first.
+const (
+	headlineStart = "\uE000"
+	headlineStop  = "\uE001"
+)
+
+var headlineOptions = "MaxFragments=2, MaxWords=20, MinWords=5, StartSel=" + headlineStart + ", StopSel=" + headlineStop
+
+// highlightSnippet escapes a ts_headline snippet, then wraps its terms in <b></b>
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, headlineStart, "<b>")
+	return strings.ReplaceAll(snippet, headlineStop, "</b>")
+}
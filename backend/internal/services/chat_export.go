@@ -0,0 +1,353 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Conversation export formats
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatJSON     = "json"
+	ExportFormatHTML     = "html"
+)
+
+// ErrUnknownExportFormat is returned for a format other than md, json or html
+var ErrUnknownExportFormat = errors.New("export format must be md, json or html")
+
+// ConversationExport is a conversation's active branch with the citations and
+// sources of each answer. Times are UTC and nothing depends on when the export
+// ran, so exporting the same conversation twice gives the same bytes.
+type ConversationExport struct {
+	ID        uuid.UUID         `json:"id"`
+	Title     string            `json:"title"`
+	CreatedAt time.Time         `json:"created_at"`
+	Messages  []ExportedMessage `json:"messages"`
+}
+
+// ExportedMessage is one message of an exported conversation
+type ExportedMessage struct {
+	ID         uuid.UUID      `json:"id"`
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Confidence *float64       `json:"confidence,omitempty"`
+	Citations  []Citation     `json:"citations,omitempty"`
+	Sources    []AnswerResult `json:"sources,omitempty"` // Every answer the reply drew on, with full metadata
+}
+
+// ExportConversation collects a conversation for export
+func (cs *ChatService) ExportConversation(userID, conversationID uuid.UUID) (*ConversationExport, error) {
+	conversation, err := cs.GetConversation(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := cs.activeBranch(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	title := "Untitled conversation"
+	if conversation.Title != nil && strings.TrimSpace(*conversation.Title) != "" {
+		title = strings.TrimSpace(*conversation.Title)
+	}
+
+	export := &ConversationExport{
+		ID:        conversation.ID,
+		Title:     title,
+		CreatedAt: conversation.CreatedAt.UTC(),
+		Messages:  make([]ExportedMessage, 0, len(branch)),
+	}
+	for _, message := range branch {
+		export.Messages = append(export.Messages, ExportedMessage{
+			ID:         message.ID,
+			Role:       message.Role,
+			Content:    message.Content,
+			CreatedAt:  message.CreatedAt.UTC(),
+			Confidence: message.Confidence,
+			Citations:  storedCitations(message.ChatMessage),
+			Sources:    storedSources(message.ChatMessage),
+		})
+	}
+
+	return export, nil
+}
+
+// Render returns the export in the given format with its content type
+func (e *ConversationExport) Render(format string) ([]byte, string, error) {
+	switch format {
+	case ExportFormatMarkdown:
+		return e.Markdown(), "text/markdown; charset=utf-8", nil
+	case ExportFormatJSON:
+		data, err := e.JSON()
+		return data, "application/json", err
+	case ExportFormatHTML:
+		data, err := e.HTML()
+		return data, "text/html; charset=utf-8", err
+	}
+	return nil, "", ErrUnknownExportFormat
+}
+
+// FileName is a download name for the export, e.g. "quarterly-revenue.md"
+func (e *ConversationExport) FileName(format string) string {
+	slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(e.Title), "-"), "-")
+	if len(slug) > 60 {
+		slug = strings.TrimRight(slug[:60], "-")
+	}
+	if slug == "" {
+		slug = "conversation"
+	}
+	return slug + "." + format
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// JSON is the export with full source metadata, indented for diffing
+func (e *ConversationExport) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Markdown renders the conversation with [n] markers linked to a numbered
+// source list under each answer
+func (e *ConversationExport) Markdown() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", e.Title)
+	fmt.Fprintf(&b, "_Started %s · %d messages_\n", exportTime(e.CreatedAt), len(e.Messages))
+
+	for i, message := range e.Messages {
+		fmt.Fprintf(&b, "\n---\n\n### %s · %s\n\n", exportSpeaker(message.Role), exportTime(message.CreatedAt))
+
+		content := linkCitations(strings.TrimSpace(message.Content), message.Citations, func(number int) string {
+			return fmt.Sprintf("[[%d]](#%s)", number, citationAnchor(i, number))
+		})
+		b.WriteString(content)
+		b.WriteString("\n")
+
+		if confidence := exportConfidence(message); confidence != "" {
+			fmt.Fprintf(&b, "\n_Confidence: %s_\n", confidence)
+		}
+
+		if len(message.Citations) > 0 {
+			b.WriteString("\n**Sources**\n\n")
+			for _, citation := range message.Citations {
+				fmt.Fprintf(&b, "%d. <a id=\"%s\"></a>**%s**", citation.Number, citationAnchor(i, citation.Number), citationTitle(citation))
+				if location := citationLocation(citation); location != "" {
+					fmt.Fprintf(&b, " (%s)", location)
+				}
+				b.WriteString("\n")
+				if excerpt := strings.TrimSpace(citation.Excerpt); excerpt != "" {
+					for _, line := range strings.Split(excerpt, "\n") {
+						fmt.Fprintf(&b, "   > %s\n", strings.TrimSpace(line))
+					}
+				}
+			}
+		}
+	}
+
+	return []byte(b.String())
+}
+
+// HTML renders a standalone page with print styles, ready to save as PDF
+func (e *ConversationExport) HTML() ([]byte, error) {
+	view := exportHTMLView{
+		Title:   e.Title,
+		Started: exportTime(e.CreatedAt),
+		Count:   len(e.Messages),
+	}
+
+	for i, message := range e.Messages {
+		var paragraphs []string
+		for _, paragraph := range strings.Split(strings.TrimSpace(message.Content), "\n\n") {
+			if paragraph = strings.TrimSpace(paragraph); paragraph == "" {
+				continue
+			}
+			escaped := strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>\n")
+			escaped = linkCitations(escaped, message.Citations, func(number int) string {
+				return fmt.Sprintf(`<sup><a href="#%s">[%d]</a></sup>`, citationAnchor(i, number), number)
+			})
+			paragraphs = append(paragraphs, "<p>"+escaped+"</p>")
+		}
+
+		messageView := exportHTMLMessage{
+			Role:       message.Role,
+			Speaker:    exportSpeaker(message.Role),
+			Time:       exportTime(message.CreatedAt),
+			Body:       template.HTML(strings.Join(paragraphs, "\n")),
+			Confidence: exportConfidence(message),
+		}
+		for _, citation := range message.Citations {
+			messageView.Citations = append(messageView.Citations, exportHTMLCitation{
+				Anchor:   citationAnchor(i, citation.Number),
+				Title:    citationTitle(citation),
+				Location: citationLocation(citation),
+				Excerpt:  strings.TrimSpace(citation.Excerpt),
+			})
+		}
+		view.Messages = append(view.Messages, messageView)
+	}
+
+	var buf bytes.Buffer
+	if err := conversationHTML.Execute(&buf, view); err != nil {
+		return nil, fmt.Errorf("failed to render conversation: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+type exportHTMLView struct {
+	Title    string
+	Started  string
+	Count    int
+	Messages []exportHTMLMessage
+}
+
+type exportHTMLMessage struct {
+	Role       string
+	Speaker    string
+	Time       string
+	Body       template.HTML // Escaped content with citation links
+	Confidence string
+	Citations  []exportHTMLCitation
+}
+
+type exportHTMLCitation struct {
+	Anchor   string
+	Title    string
+	Location string
+	Excerpt  string
+}
+
+var conversationHTML = template.Must(template.New("conversation").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Georgia, "Times New Roman", serif; line-height: 1.5; max-width: 46em; margin: 2em auto; padding: 0 1em; color: #222; }
+h1 { margin-bottom: 0.2em; }
+.meta, .time, .confidence, .location { color: #666; font-size: 0.9em; }
+.message { border-top: 1px solid #ddd; padding-top: 0.5em; margin-top: 1.5em; }
+.message h2 { font-size: 1.05em; margin: 0.5em 0; }
+.message.user h2 { color: #1a4d8f; }
+.sources { font-size: 0.9em; }
+.sources blockquote { margin: 0.3em 0 0.6em; padding-left: 0.8em; border-left: 3px solid #ccc; color: #444; white-space: pre-wrap; }
+sup a { text-decoration: none; }
+@media print {
+  body { margin: 0; max-width: none; }
+  .message { page-break-inside: avoid; }
+  a { color: inherit; }
+}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Started {{.Started}} · {{.Count}} messages</p>
+{{- range .Messages}}
+<section class="message {{.Role}}">
+<h2>{{.Speaker}} <span class="time">{{.Time}}</span></h2>
+{{.Body}}
+{{- if .Confidence}}
+<p class="confidence">Confidence: {{.Confidence}}</p>
+{{- end}}
+{{- if .Citations}}
+<ol class="sources">
+{{- range .Citations}}
+<li id="{{.Anchor}}"><strong>{{.Title}}</strong>{{if .Location}} <span class="location">({{.Location}})</span>{{end}}{{if .Excerpt}}<blockquote>{{.Excerpt}}</blockquote>{{end}}</li>
+{{- end}}
+</ol>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
+
+// linkCitations replaces the [n] markers of known citations with link(n)
+func linkCitations(text string, citations []Citation, link func(number int) string) string {
+	known := make(map[int]bool, len(citations))
+	for _, citation := range citations {
+		known[citation.Number] = true
+	}
+	return citationMarker.ReplaceAllStringFunc(text, func(marker string) string {
+		number, _ := strconv.Atoi(marker[1 : len(marker)-1])
+		if !known[number] {
+			return marker
+		}
+		return link(number)
+	})
+}
+
+// citationAnchor identifies citation number of the message at index
+func citationAnchor(index, number int) string {
+	return fmt.Sprintf("m%d-source-%d", index+1, number)
+}
+
+func citationTitle(citation Citation) string {
+	if title := strings.TrimSpace(citation.Title); title != "" {
+		return title
+	}
+	return "Untitled source"
+}
+
+// citationLocation describes where in the source the excerpt is, e.g.
+// "pdf, page 4" or "audio, 1:05–1:32, Dana"
+func citationLocation(citation Citation) string {
+	var parts []string
+	if citation.ContentType != "" {
+		parts = append(parts, citation.ContentType)
+	}
+	if citation.PageNum != nil {
+		parts = append(parts, fmt.Sprintf("page %d", *citation.PageNum))
+	}
+	if citation.StartTime != nil {
+		span := exportTimestamp(*citation.StartTime)
+		if citation.EndTime != nil {
+			span += "–" + exportTimestamp(*citation.EndTime)
+		}
+		parts = append(parts, span)
+	}
+	if citation.Speaker != nil && *citation.Speaker != "" {
+		parts = append(parts, *citation.Speaker)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func exportSpeaker(role string) string {
+	if role == "assistant" {
+		return "Assistant"
+	}
+	return "You"
+}
+
+func exportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// exportTimestamp formats seconds into a recording as m:ss or h:mm:ss
+func exportTimestamp(seconds float64) string {
+	total := int(seconds)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total%3600/60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
+
+func exportConfidence(message ExportedMessage) string {
+	if message.Role != "assistant" || message.Confidence == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%%", *message.Confidence*100)
+}
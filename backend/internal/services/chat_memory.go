@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tanaymehhta/self/backend/internal/models"
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+)
+
+const (
+	// recentHistoryMessages is how many raw messages are kept beside the summary
+	recentHistoryMessages = 6
+
+	// summaryBatchMessages is how many messages must fall out of the recent
+	// window before they are folded into the summary
+	summaryBatchMessages = 6
+
+	// maxTitleLength caps generated titles, in characters
+	maxTitleLength = 80
+
+	// maxHeuristicSummary caps the fallback summary, in characters
+	maxHeuristicSummary = 2000
+)
+
+// ConversationSummary condenses the messages of a conversation's active branch
+// that have fallen out of the recent history window
+type ConversationSummary struct {
+	ConversationID      uuid.UUID  `json:"conversation_id" gorm:"primaryKey"`
+	Summary             string     `json:"summary"`
+	MessageCount        int        `json:"message_count"`         // Messages folded in so far
+	SummarizedMessageID *uuid.UUID `json:"summarized_message_id"` // Last message folded in
+	SummarizedThrough   time.Time  `json:"summarized_through"`    // created_at of the last message folded in
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+func (ConversationSummary) TableName() string {
+	return "conversation_summaries"
+}
+
+// maintainConversation titles a conversation after its first exchange and
+// folds old messages into its summary. Both are best-effort; the title is
+// returned when the conversation has one.
+func (cs *ChatService) maintainConversation(ctx context.Context, conversation *models.ChatConversation, question, answer string) *string {
+	if conversation.Title == nil {
+		title := cs.generateTitle(ctx, question, answer)
+		result := cs.db.Model(&models.ChatConversation{}).
+			Where("id = ? AND title IS NULL", conversation.ID).
+			Update("title", title)
+		if result.Error != nil {
+			fmt.Printf("Failed to save title for conversation %s: %v\n", conversation.ID, result.Error)
+		} else if result.RowsAffected > 0 {
+			conversation.Title = &title
+		}
+	}
+
+	if err := cs.updateSummary(ctx, conversation.ID); err != nil {
+		fmt.Printf("Failed to update summary for conversation %s: %v\n", conversation.ID, err)
+	}
+
+	return conversation.Title
+}
+
+// generateTitle names a conversation from its first exchange, falling back to
+// the opening question when no model is available or the reply is unusable
+func (cs *ChatService) generateTitle(ctx context.Context, question, answer string) string {
+	if cs.generator != nil {
+		title, err := cs.generateWithPrompt(ctx, prompts.ConversationTitle, map[string]string{
+			"question": truncateText(question, 500),
+			"answer":   truncateText(answer, 1000),
+		}, 30)
+		if err == nil {
+			if title = cleanTitle(title); title != "" {
+				return title
+			}
+		} else {
+			fmt.Printf("Title generation failed, using the question: %v\n", err)
+		}
+	}
+	return headline(question, 60)
+}
+
+// updateSummary folds messages of the active branch that have left the
+// recent window into the conversation's summary, once there are enough of them
+// to be worth a call. A summary of another branch is started over.
+func (cs *ChatService) updateSummary(ctx context.Context, conversationID uuid.UUID) error {
+	summary, err := cs.loadSummary(conversationID)
+	if err != nil {
+		return err
+	}
+	branch, err := cs.activeBranch(conversationID)
+	if err != nil {
+		return err
+	}
+
+	summarized := summarizedIndex(summary, branch)
+	if summarized < 0 {
+		summary.Summary = ""
+		summary.MessageCount = 0
+	}
+
+	pending := make([]models.ChatMessage, 0, len(branch)-summarized-1)
+	for _, node := range branch[summarized+1:] {
+		pending = append(pending, node.ChatMessage)
+	}
+
+	foldable := len(pending) - recentHistoryMessages
+	if foldable < summaryBatchMessages {
+		return nil
+	}
+	pending = pending[:foldable]
+
+	text := cs.summarize(ctx, summary.Summary, pending)
+
+	summary.ConversationID = conversationID
+	summary.Summary = text
+	summary.MessageCount += len(pending)
+	summary.SummarizedMessageID = &pending[len(pending)-1].ID
+	summary.SummarizedThrough = pending[len(pending)-1].CreatedAt
+	summary.UpdatedAt = time.Now()
+
+	return cs.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(summary).Error
+}
+
+// loadSummary returns the conversation's summary, or an empty one
+func (cs *ChatService) loadSummary(conversationID uuid.UUID) (*ConversationSummary, error) {
+	var summary ConversationSummary
+	err := cs.db.Where("conversation_id = ?", conversationID).First(&summary).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &ConversationSummary{ConversationID: conversationID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// summarizedIndex is the position in branch of the last message the summary
+// covers, or -1 when there is no summary or it covers a different branch
+func summarizedIndex(summary *ConversationSummary, branch []ChatMessageNode) int {
+	if summary.Summary == "" || summary.SummarizedMessageID == nil {
+		return -1
+	}
+	for i, message := range branch {
+		if message.ID == *summary.SummarizedMessageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// summarize merges messages into the previous summary with the LLM, or lists
+// the user's questions when no model is available
+func (cs *ChatService) summarize(ctx context.Context, previous string, messages []models.ChatMessage) string {
+	if cs.generator != nil {
+		lines := make([]string, len(messages))
+		for i, message := range messages {
+			lines[i] = fmt.Sprintf("%s: %s", historyRole(message.Role), truncateText(strings.TrimSpace(message.Content), 1000))
+		}
+
+		existing := previous
+		if existing == "" {
+			existing = "(none)"
+		}
+
+		text, err := cs.generateWithPrompt(ctx, prompts.ConversationSummary, map[string]string{
+			"summary":  existing,
+			"messages": strings.Join(lines, "\n"),
+		}, 400)
+		if err == nil && strings.TrimSpace(text) != "" {
+			return strings.TrimSpace(text)
+		}
+		if err != nil {
+			fmt.Printf("Conversation summary failed, listing questions: %v\n", err)
+		}
+	}
+
+	parts := []string{}
+	if previous != "" {
+		parts = append(parts, previous)
+	}
+	for _, message := range messages {
+		if message.Role == "user" {
+			parts = append(parts, "The user asked: "+headline(message.Content, 150))
+		}
+	}
+
+	text := strings.Join(parts, "\n")
+	if len(text) > maxHeuristicSummary {
+		// Keep the most recent part
+		text = text[len(text)-maxHeuristicSummary:]
+		if newline := strings.Index(text, "\n"); newline >= 0 {
+			text = text[newline+1:]
+		}
+	}
+	return text
+}
+
+// generateWithPrompt renders a template and calls the conversation generator
+func (cs *ChatService) generateWithPrompt(ctx context.Context, name string, vars map[string]string, maxTokens int) (string, error) {
+	systemPrompt, userPrompt, err := renderPrompt(ctx, name, vars)
+	if err != nil {
+		return "", err
+	}
+	return cs.generator.GenerateText(ctx, systemPrompt, userPrompt, maxTokens)
+}
+
+func historyRole(role string) string {
+	if role == "assistant" {
+		return "Assistant"
+	}
+	return "User"
+}
+
+// cleanTitle keeps the first line of a generated title without quotes,
+// labels or trailing punctuation
+func cleanTitle(text string) string {
+	title := cleanRewrittenQuery(text)
+	if label, rest, ok := strings.Cut(title, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "title") {
+		title = strings.TrimSpace(strings.Trim(strings.TrimSpace(rest), "\"'`“”"))
+	}
+	title = strings.TrimRight(title, ".!?;:")
+	return headline(title, maxTitleLength)
+}
+
+// headline shortens text to at most maxLength characters at a word boundary
+func headline(text string, maxLength int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+
+	cut := string(runes[:maxLength])
+	if space := strings.LastIndex(cut, " "); space > maxLength/2 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,;:") + "..."
+}
@@ -3,12 +3,13 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/tanaymehhta/self/backend/internal/models"
 )
@@ -16,26 +17,36 @@ import (
 type ChatService struct {
 	db            *gorm.DB
 	searchService *SearchService
+	analytics     *SearchAnalyticsService
+	feedback      *FeedbackService
+	synthesizer   *SynthesisService
+	rewriter      *QueryRewriter
+	generator     TextGenerator // Titles and summaries; heuristics without it
 }
 
 // ChatRequest represents an incoming chat message
 type ChatRequest struct {
 	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
 	Message        string     `json:"message"`
-	DocumentIDs    []uuid.UUID `json:"document_ids,omitempty"` // Optional document context
+	DocumentIDs    []uuid.UUID `json:"document_ids,omitempty"` // Attached to the conversation before answering
+	Model          string      `json:"model,omitempty"`        // Optional per-request model, e.g. "openai:gpt-4o-mini"
+	TopK           int         `json:"top_k,omitempty"`        // Passages retrieved, at most 20; default 5
 }
 
 // ChatResponse represents the complete chat response
 type ChatResponse struct {
 	ConversationID uuid.UUID                `json:"conversation_id"`
 	MessageID      uuid.UUID                `json:"message_id"`
+	UserMessageID  uuid.UUID                `json:"user_message_id"`
+	Title          *string                  `json:"title,omitempty"`
 	Response       string                   `json:"response"`
 	Sources        []AnswerResult           `json:"sources"`
 	Confidence     *float64                 `json:"confidence,omitempty"`
+	Citations      []Citation               `json:"citations"`
 	Documents      []ChatDocumentReference  `json:"documents,omitempty"`
 }
 
-// ChatDocumentReference provides context about documents used
+// ChatDocumentReference is a document attached to a conversation
 type ChatDocumentReference struct {
 	ID       uuid.UUID `json:"id"`
 	Title    string    `json:"title"`
@@ -43,8 +54,38 @@ type ChatDocumentReference struct {
 	Relevant bool      `json:"relevant"` // Whether this doc contributed to the answer
 }
 
-// ConversationHistory represents the context of previous messages
+var (
+	// ErrConversationNotFound is returned for unknown or foreign conversations
+	ErrConversationNotFound = errors.New("conversation not found")
+
+	// ErrDocumentNotFound is returned when attaching a document the user doesn't own
+	ErrDocumentNotFound = errors.New("document not found")
+
+	// ErrDocumentNotAttached is returned when detaching a document that isn't attached
+	ErrDocumentNotAttached = errors.New("document not attached to conversation")
+)
+
+// Chat stream event types, in the order StreamMessage emits them
+const (
+	ChatEventRetrievalStarted = "retrieval_started"
+	ChatEventSources          = "sources"
+	ChatEventToken            = "token"
+	ChatEventDone             = "done"
+	ChatEventTitle            = "title"
+	ChatEventError            = "error"
+)
+
+// ChatEvent is one step of a streamed chat response
+type ChatEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// ConversationHistory represents the context of previous messages: a summary
+// of older messages (if the conversation is long enough to have one) and the
+// recent messages verbatim
 type ConversationHistory struct {
+	Summary  string           `json:"summary,omitempty"`
 	Messages []HistoryMessage `json:"messages"`
 }
 
@@ -58,64 +99,192 @@ func NewChatService(db *gorm.DB, searchService *SearchService) *ChatService {
 	return &ChatService{
 		db:            db,
 		searchService: searchService,
+		analytics:     NewSearchAnalyticsService(db),
+		feedback:      NewFeedbackService(db),
+		synthesizer:   NewSynthesisService(nil),
+		rewriter:      NewQueryRewriter(nil),
 	}
 }
 
+// SetSynthesisService replaces the default extractive synthesizer
+func (cs *ChatService) SetSynthesisService(synthesizer *SynthesisService) {
+	cs.synthesizer = synthesizer
+}
+
+// SetQueryRewriter replaces the default heuristic-only query rewriter
+func (cs *ChatService) SetQueryRewriter(rewriter *QueryRewriter) {
+	cs.rewriter = rewriter
+}
+
+// SetConversationGenerator sets the model that titles conversations and
+// summarizes long ones
+func (cs *ChatService) SetConversationGenerator(generator TextGenerator) {
+	cs.generator = generator
+}
+
 // ProcessMessage is the main entry point for chat functionality
 func (cs *ChatService) ProcessMessage(ctx context.Context, userID uuid.UUID, req ChatRequest) (*ChatResponse, error) {
+	return cs.processMessage(ctx, userID, req, chatTurn{}, nil)
+}
+
+// StreamMessage processes a message like ProcessMessage while reporting
+// progress to emit: retrieval started, the sources found, the answer text as
+// the LLM writes it, and the saved response as soon as the answer is stored.
+// The "done" event's text is the streamed text. A new conversation's title is
+// generated afterwards and sent in a final "title" event, so it doesn't hold up
+// "done". If emit fails (e.g. the client went away) the message is still
+// processed and saved.
+func (cs *ChatService) StreamMessage(ctx context.Context, userID uuid.UUID, req ChatRequest, emit func(ChatEvent) error) (*ChatResponse, error) {
+	disconnected := false
+	send := func(eventType string, data interface{}) {
+		if disconnected {
+			return
+		}
+		if err := emit(ChatEvent{Type: eventType, Data: data}); err != nil {
+			disconnected = true
+		}
+	}
+
+	response, err := cs.processMessage(ctx, userID, req, chatTurn{}, send)
+	if err != nil {
+		send(ChatEventError, map[string]string{"message": err.Error()})
+		return nil, err
+	}
+	return response, nil
+}
+
+// processMessage runs the chat pipeline for the exchange turn places in the
+// conversation tree; send, when set, receives stream events
+func (cs *ChatService) processMessage(ctx context.Context, userID uuid.UUID, req ChatRequest, turn chatTurn, send func(eventType string, data interface{})) (*ChatResponse, error) {
 	// 1. Get or create conversation
 	conversation, err := cs.getOrCreateConversation(userID, req.ConversationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get/create conversation: %w", err)
 	}
 
-	// 2. Save user message
-	_, err = cs.saveMessage(conversation.ID, "user", req.Message, nil, nil)
+	// Attach any documents sent with the message, then scope retrieval to the
+	// conversation's documents (if it has any)
+	if err := cs.attachDocuments(userID, conversation.ID, req.DocumentIDs); err != nil {
+		return nil, err
+	}
+	documents, err := cs.getConversationDocuments(conversation.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save user message: %w", err)
+		return nil, fmt.Errorf("failed to get conversation documents: %w", err)
 	}
-
-	// 3. Get conversation context for better queries
-	history, err := cs.getConversationHistory(conversation.ID, 5) // Last 5 messages
+	cs.searchService.SetContentScope(documentIDs(documents))
+	cs.searchService.SetFeedback(cs.feedback, userID)
+
+	// 2. Get conversation context for better queries from the branch the message
+	// continues, before this message joins it
+	parentID := turn.parentID
+	switch {
+	case turn.userMessage != nil:
+		parentID = turn.userMessage.ParentID
+	case !turn.fork:
+		if parentID, err = cs.activeMessageID(conversation.ID); err != nil {
+			return nil, fmt.Errorf("failed to get active branch: %w", err)
+		}
+	}
+	history, err := cs.getConversationHistory(conversation.ID, parentID, recentHistoryMessages)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get conversation history: %w", err)
 	}
 
-	// 4. Enhance query with context (resolve pronouns, add context)
-	enhancedQuery := cs.enhanceQueryWithContext(req.Message, history)
+	// 3. Save user message, unless its reply is being regenerated
+	var userMessage *models.ChatMessage
+	if turn.userMessage != nil {
+		userMessage = &turn.userMessage.ChatMessage
+	} else {
+		userMessage, err = cs.saveMessage(conversation.ID, parentID, "user", req.Message, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save user message: %w", err)
+		}
+	}
+
+	if send != nil {
+		send(ChatEventRetrievalStarted, map[string]interface{}{"conversation_id": conversation.ID})
+	}
+
+	// 4. Rewrite follow-ups into a standalone query (resolve pronouns, add context)
+	rewrite := cs.rewriter.Rewrite(ctx, req.Message, history)
+	cs.saveRewrittenQuery(userMessage, rewrite)
 
 	// 5. Perform QA search using existing pipeline
-	qaResults, err := cs.searchService.QASearch(ctx, enhancedQuery, 5)
+	retrievalStart := time.Now()
+	qaResults, err := cs.searchService.QASearch(ctx, rewrite.Query, retrievalLimit(req.TopK))
 	if err != nil {
 		return nil, fmt.Errorf("QA search failed: %w", err)
 	}
 
-	// 6. Format answer for chat context
-	chatResponse, confidence := cs.formatChatResponse(qaResults, req.Message)
+	// Record the retrieval for search analytics (best-effort)
+	if _, err := cs.analytics.RecordQASearch(userID, "chat", req.Message, qaResults, retrievalStart); err != nil {
+		fmt.Printf("Failed to record chat retrieval analytics: %v\n", err)
+	}
 
-	// 7. Prepare sources for response
+	// 6. Prepare sources for response
 	sources := cs.prepareSources(qaResults.Answers)
 
+	// 7. Synthesize a cited answer for chat context, streaming it when asked
+	var onToken func(string) error
+	streamed := false
+	if send != nil {
+		send(ChatEventSources, map[string]interface{}{"sources": sources})
+		onToken = func(token string) error {
+			streamed = true
+			send(ChatEventToken, map[string]string{"text": token})
+			return nil
+		}
+	}
+	chatResponse, confidence, citations, synthesisPrompt := cs.formatChatResponse(ctx, qaResults, rewrite.Query, onToken)
+	if send != nil && !streamed {
+		// Canned and extractive answers arrive in one piece
+		send(ChatEventToken, map[string]string{"text": chatResponse})
+	}
+
 	// 8. Save AI response
-	aiMessage, err := cs.saveMessage(conversation.ID, "assistant", chatResponse, sources, confidence)
+	aiMessage, err := cs.saveMessage(conversation.ID, &userMessage.ID, "assistant", chatResponse, sources, confidence)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save AI message: %w", err)
 	}
-
-	// 9. Get document references
-	documents, err := cs.getConversationDocuments(conversation.ID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get conversation documents: %w", err)
+	promptVersions := answerPromptVersions(qaResults.Answers, synthesisPrompt)
+	if rewrite.PromptVersion != "" {
+		promptVersions["rewrite"] = rewrite.PromptVersion
 	}
+	answerModels := map[string]string{}
+	if qaResults.Model != "" {
+		answerModels["extraction"] = qaResults.Model
+	}
+	if synthesisPrompt != "" && cs.synthesizer.Model() != "" {
+		answerModels["synthesis"] = cs.synthesizer.Model()
+	}
+	cs.saveAnswerMetadata(aiMessage, citations, promptVersions, answerModels)
 
-	return &ChatResponse{
+	// 9. Report which attached documents the answer drew on
+	markContributingDocuments(documents, sources)
+
+	response := &ChatResponse{
 		ConversationID: conversation.ID,
 		MessageID:      aiMessage.ID,
+		UserMessageID:  userMessage.ID,
+		Title:          conversation.Title,
 		Response:       chatResponse,
 		Sources:        sources,
 		Confidence:     confidence,
+		Citations:      citations,
 		Documents:      documents,
-	}, nil
+	}
+	if send != nil {
+		send(ChatEventDone, response)
+	}
+
+	// Title the conversation after its first exchange and keep its summary current
+	untitled := conversation.Title == nil
+	response.Title = cs.maintainConversation(ctx, conversation, req.Message, chatResponse)
+	if send != nil && untitled && response.Title != nil {
+		send(ChatEventTitle, map[string]interface{}{"conversation_id": conversation.ID, "title": *response.Title})
+	}
+
+	return response, nil
 }
 
 // getOrCreateConversation handles conversation management
@@ -136,7 +305,7 @@ func (cs *ChatService) getOrCreateConversation(userID uuid.UUID, conversationID
 	conversation := models.ChatConversation{
 		ID:           uuid.New(),
 		UserID:       userID,
-		Title:        nil, // Will be auto-generated later
+		Title:        nil, // Generated after the first exchange
 		MessageCount: 0,
 		LastActivity: time.Now(),
 		CreatedAt:    time.Now(),
@@ -150,14 +319,31 @@ func (cs *ChatService) getOrCreateConversation(userID uuid.UUID, conversationID
 	return &conversation, nil
 }
 
-// saveMessage saves a chat message to the database
-func (cs *ChatService) saveMessage(conversationID uuid.UUID, role, content string, sources []AnswerResult, confidence *float64) (*models.ChatMessage, error) {
+// retrievalLimit is the number of passages to retrieve for a requested top_k
+func retrievalLimit(topK int) int {
+	if topK <= 0 {
+		return 5
+	}
+	if topK > 20 {
+		return 20
+	}
+	return topK
+}
+
+// saveMessage saves a chat message under parentID and, in the same
+// transaction, makes it the end of the active branch and bumps the
+// conversation's message count and last activity
+func (cs *ChatService) saveMessage(conversationID uuid.UUID, parentID *uuid.UUID, role, content string, sources []AnswerResult, confidence *float64) (*models.ChatMessage, error) {
+	// Kept under "answers", as the column holds an object
 	sourcesJSON := models.JSONB{}
 	if sources != nil {
 		sourcesBytes, _ := json.Marshal(sources)
-		json.Unmarshal(sourcesBytes, &sourcesJSON)
+		var answers []interface{}
+		json.Unmarshal(sourcesBytes, &answers)
+		sourcesJSON["answers"] = answers
 	}
 
+	now := time.Now()
 	message := models.ChatMessage{
 		ID:             uuid.New(),
 		ConversationID: conversationID,
@@ -166,108 +352,147 @@ func (cs *ChatService) saveMessage(conversationID uuid.UUID, role, content strin
 		Sources:        sourcesJSON,
 		Confidence:     confidence,
 		Metadata:       models.JSONB{},
-		CreatedAt:      time.Now(),
+		CreatedAt:      now,
 	}
 
-	if err := cs.db.Create(&message).Error; err != nil {
+	err := cs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if parentID != nil {
+			err := tx.Table("chat_messages").
+				Where("id = ?", message.ID).
+				Update("parent_id", *parentID).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.ChatConversation{}).
+			Where("id = ?", conversationID).
+			Updates(map[string]interface{}{
+				"message_count":     gorm.Expr("message_count + 1"),
+				"active_message_id": message.ID,
+				"last_activity":     now,
+				"updated_at":        now,
+			}).Error
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &message, nil
 }
 
-// getConversationHistory retrieves recent conversation context
-func (cs *ChatService) getConversationHistory(conversationID uuid.UUID, limit int) (*ConversationHistory, error) {
-	var messages []models.ChatMessage
-	err := cs.db.Where("conversation_id = ?", conversationID).
-		Order("created_at DESC").
-		Limit(limit).
-		Find(&messages).Error
+// getConversationHistory retrieves the branch ending at leafID: the
+// conversation's summary, if it covers the start of this branch, and the
+// messages after it. Without a summary that is the last limit messages; with
+// one, every message not yet folded in, which updateSummary keeps to a few
+// more than limit on the active branch.
+func (cs *ChatService) getConversationHistory(conversationID uuid.UUID, leafID *uuid.UUID, limit int) (*ConversationHistory, error) {
+	history := ConversationHistory{Messages: []HistoryMessage{}}
+	if leafID == nil {
+		return &history, nil
+	}
 
+	branch, err := cs.branchTo(conversationID, *leafID)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := cs.loadSummary(conversationID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Reverse to get chronological order
-	history := ConversationHistory{Messages: make([]HistoryMessage, len(messages))}
-	for i, msg := range messages {
-		history.Messages[len(messages)-1-i] = HistoryMessage{
+	if summarized := summarizedIndex(summary, branch); summarized >= 0 {
+		history.Summary = summary.Summary
+		branch = branch[summarized+1:]
+		limit += summaryBatchMessages
+	}
+	if len(branch) > limit {
+		branch = branch[len(branch)-limit:]
+	}
+
+	for _, msg := range branch {
+		history.Messages = append(history.Messages, HistoryMessage{
 			Role:      msg.Role,
 			Content:   msg.Content,
 			CreatedAt: msg.CreatedAt,
-		}
+		})
 	}
 
 	return &history, nil
 }
 
-// enhanceQueryWithContext improves queries using conversation history
-func (cs *ChatService) enhanceQueryWithContext(query string, history *ConversationHistory) string {
-	if history == nil || len(history.Messages) == 0 {
-		return query
+// formatChatResponse synthesizes the QA results into one answer to query (the
+// standalone rewrite, so a follow-up is answered in context) with inline [n]
+// citations and returns the citation list and synthesis prompt version alongside
+// it. onToken, if set, receives the LLM's text as it streams.
+func (cs *ChatService) formatChatResponse(ctx context.Context, qaResults *QASearchResults, query string, onToken func(string) error) (string, *float64, []Citation, string) {
+	if len(qaResults.Answers) == 0 {
+		return "I couldn't find relevant information to answer your question. Could you try rephrasing or provide more context?", nil, []Citation{}, ""
 	}
 
-	// Simple context enhancement (can be made more sophisticated)
-	// This helps resolve pronouns and provide context
-	contextualQuery := query
+	if !qaResults.Answers[0].HasAnswer {
+		return "I found some related content, but couldn't extract a specific answer to your question. Could you be more specific?", nil, []Citation{}, ""
+	}
 
-	// If the query has pronouns or references, add recent context
-	pronouns := []string{"it", "this", "that", "they", "them", "he", "she", "his", "her"}
-	hasPronouns := false
-	lowerQuery := strings.ToLower(query)
+	synthesized, err := cs.synthesizer.SynthesizeStream(ctx, query, qaResults.Answers, onToken)
+	if err != nil {
+		return "I found some related content, but couldn't extract a specific answer to your question. Could you be more specific?", nil, []Citation{}, ""
+	}
 
-	for _, pronoun := range pronouns {
-		if strings.Contains(lowerQuery, pronoun) {
-			hasPronouns = true
+	return synthesized.Text, &synthesized.Confidence, synthesized.Citations, synthesized.PromptVersion
+}
+
+// answerPromptVersions lists the prompt templates behind an answer, keyed by
+// stage, so it can be reproduced or compared across A/B variants
+func answerPromptVersions(answers []*AnswerResult, synthesisPrompt string) map[string]string {
+	versions := make(map[string]string)
+	for _, answer := range answers {
+		if answer.PromptVersion != "" {
+			versions["extraction"] = answer.PromptVersion
 			break
 		}
 	}
-
-	if hasPronouns && len(history.Messages) > 0 {
-		// Get the last user message for context
-		for i := len(history.Messages) - 1; i >= 0; i-- {
-			if history.Messages[i].Role == "user" {
-				contextualQuery = fmt.Sprintf("Previous context: %s\n\nCurrent question: %s",
-					history.Messages[i].Content, query)
-				break
-			}
-		}
+	if synthesisPrompt != "" {
+		versions["synthesis"] = synthesisPrompt
 	}
-
-	return contextualQuery
+	return versions
 }
 
-// formatChatResponse converts QA results into a natural chat response
-func (cs *ChatService) formatChatResponse(qaResults *QASearchResults, originalQuery string) (string, *float64) {
-	if len(qaResults.Answers) == 0 {
-		return "I couldn't find relevant information to answer your question. Could you try rephrasing or provide more context?", nil
+// saveAnswerMetadata stores the citation list, prompt versions and models in the
+// message metadata so they can be shown again when the conversation is reloaded
+// and attached to feedback (best-effort)
+func (cs *ChatService) saveAnswerMetadata(message *models.ChatMessage, citations []Citation, promptVersions, answerModels map[string]string) {
+	if len(citations) == 0 && len(promptVersions) == 0 && len(answerModels) == 0 {
+		return
 	}
 
-	// Get the best answer
-	bestAnswer := qaResults.Answers[0]
-
-	if !bestAnswer.HasAnswer {
-		return "I found some related content, but couldn't extract a specific answer to your question. Could you be more specific?", nil
+	if len(citations) > 0 {
+		message.Metadata["citations"] = citations
 	}
+	if len(promptVersions) > 0 {
+		message.Metadata["prompt_versions"] = promptVersions
+	}
+	if len(answerModels) > 0 {
+		message.Metadata["models"] = answerModels
+	}
+	if err := cs.db.Model(message).Update("metadata", message.Metadata).Error; err != nil {
+		fmt.Printf("Failed to save answer metadata for message %s: %v\n", message.ID, err)
+	}
+}
 
-	// Create a natural chat response
-	response := bestAnswer.Answer
-
-	// Add source context if helpful
-	if len(qaResults.Answers) > 1 {
-		response += "\n\n"
-		additionalSources := 0
-		for i := 1; i < len(qaResults.Answers) && i < 3; i++ {
-			if qaResults.Answers[i].HasAnswer && qaResults.Answers[i].Confidence > 0.6 {
-				additionalSources++
-			}
-		}
-		if additionalSources > 0 {
-			response += fmt.Sprintf("I found this information across %d sources.", additionalSources+1)
-		}
+// saveRewrittenQuery records the query actually searched for on the user's
+// message, for debugging retrieval (best-effort)
+func (cs *ChatService) saveRewrittenQuery(message *models.ChatMessage, rewrite *RewrittenQuery) {
+	if rewrite.Strategy == RewriteStrategyNone {
+		return
 	}
 
-	return response, &bestAnswer.Confidence
+	message.Metadata["rewritten_query"] = rewrite
+	if err := cs.db.Model(message).Update("metadata", message.Metadata).Error; err != nil {
+		fmt.Printf("Failed to save rewritten query for message %s: %v\n", message.ID, err)
+	}
 }
 
 // prepareSources formats sources for the chat response
@@ -281,62 +506,140 @@ func (cs *ChatService) prepareSources(answers []*AnswerResult) []AnswerResult {
 	return sources
 }
 
-// getConversationDocuments retrieves documents associated with a conversation
+// getConversationDocuments lists the documents attached to a conversation, oldest first
 func (cs *ChatService) getConversationDocuments(conversationID uuid.UUID) ([]ChatDocumentReference, error) {
-	var docs []ChatDocumentReference
+	docs := []ChatDocumentReference{}
+	err := cs.db.Raw(`
+		SELECT ci.id, COALESCE(ci.title, '') AS title, ci.content_type AS type
+		FROM conversation_documents cd
+		JOIN content_items ci ON cd.content_item_id = ci.id
+		WHERE cd.conversation_id = ?
+		ORDER BY cd.added_at ASC
+	`, conversationID).Scan(&docs).Error
+
+	return docs, err
+}
 
-	// This would need to join with the content_items table
-	// For now, return empty - can be implemented when document management is added
+// markContributingDocuments flags the documents that at least one source came from
+func markContributingDocuments(docs []ChatDocumentReference, sources []AnswerResult) {
+	contributed := make(map[string]bool)
+	for _, source := range sources {
+		contributed[source.ContentItemID] = true
+	}
+	for i := range docs {
+		docs[i].Relevant = contributed[docs[i].ID.String()]
+	}
+}
 
-	return docs, nil
+// documentIDs returns the IDs of docs as strings, for SearchService.SetContentScope
+func documentIDs(docs []ChatDocumentReference) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID.String()
+	}
+	return ids
 }
 
-// GetConversations retrieves user's chat conversations
-func (cs *ChatService) GetConversations(userID uuid.UUID, limit int) ([]models.ChatConversation, error) {
-	var conversations []models.ChatConversation
-	err := cs.db.Where("user_id = ?", userID).
-		Order("last_activity DESC").
-		Limit(limit).
-		Find(&conversations).Error
+// ConversationDocuments lists the documents attached to a user's conversation
+func (cs *ChatService) ConversationDocuments(userID, conversationID uuid.UUID) ([]ChatDocumentReference, error) {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+	return cs.getConversationDocuments(conversationID)
+}
+
+// AttachDocuments scopes a conversation's retrieval to documents. Documents
+// already attached are left as they are. Returns all attached documents.
+func (cs *ChatService) AttachDocuments(userID, conversationID uuid.UUID, contentItemIDs []uuid.UUID) ([]ChatDocumentReference, error) {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+	if err := cs.attachDocuments(userID, conversationID, contentItemIDs); err != nil {
+		return nil, err
+	}
+	return cs.getConversationDocuments(conversationID)
+}
+
+// AddDocumentToConversation links a document to a conversation
+func (cs *ChatService) AddDocumentToConversation(userID, conversationID, contentItemID uuid.UUID) error {
+	_, err := cs.AttachDocuments(userID, conversationID, []uuid.UUID{contentItemID})
+	return err
+}
+
+// DetachDocument removes a document from a conversation's scope
+func (cs *ChatService) DetachDocument(userID, conversationID, contentItemID uuid.UUID) error {
+	if err := cs.verifyConversation(userID, conversationID); err != nil {
+		return err
+	}
 
-	return conversations, err
+	result := cs.db.Where("conversation_id = ? AND content_item_id = ?", conversationID, contentItemID).
+		Delete(&models.ConversationDocument{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to detach document: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrDocumentNotAttached
+	}
+	return nil
 }
 
-// GetConversationMessages retrieves messages for a conversation
-func (cs *ChatService) GetConversationMessages(userID uuid.UUID, conversationID uuid.UUID) ([]models.ChatMessage, error) {
-	var messages []models.ChatMessage
+// attachDocuments links the user's documents to a conversation the caller has verified
+func (cs *ChatService) attachDocuments(userID, conversationID uuid.UUID, contentItemIDs []uuid.UUID) error {
+	unique := make([]uuid.UUID, 0, len(contentItemIDs))
+	seen := make(map[uuid.UUID]bool)
+	for _, id := range contentItemIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
 
-	// First verify user owns the conversation
-	var conversation models.ChatConversation
-	err := cs.db.Where("id = ? AND user_id = ?", conversationID, userID).First(&conversation).Error
+	// Every document must exist and belong to the user
+	var owned int64
+	err := cs.db.Table("content_items").
+		Where("id IN ? AND user_id = ?", unique, userID).
+		Count(&owned).Error
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to look up documents: %w", err)
+	}
+	if int(owned) != len(unique) {
+		return ErrDocumentNotFound
 	}
 
-	// Get messages
-	err = cs.db.Where("conversation_id = ?", conversationID).
-		Order("created_at ASC").
-		Find(&messages).Error
+	links := make([]models.ConversationDocument, len(unique))
+	for i, id := range unique {
+		links[i] = models.ConversationDocument{
+			ID:             uuid.New(),
+			ConversationID: conversationID,
+			ContentItemID:  id,
+			AddedAt:        time.Now(),
+		}
+	}
 
-	return messages, err
+	err = cs.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation_id"}, {Name: "content_item_id"}},
+		DoNothing: true,
+	}).Create(&links).Error
+	if err != nil {
+		return fmt.Errorf("failed to attach documents: %w", err)
+	}
+	return nil
 }
 
-// AddDocumentToConversation links a document to a conversation
-func (cs *ChatService) AddDocumentToConversation(userID, conversationID, contentItemID uuid.UUID) error {
-	// First verify user owns both the conversation and the document
-	var conversation models.ChatConversation
-	err := cs.db.Where("id = ? AND user_id = ?", conversationID, userID).First(&conversation).Error
+// verifyConversation returns ErrConversationNotFound unless the user owns the conversation
+func (cs *ChatService) verifyConversation(userID, conversationID uuid.UUID) error {
+	var count int64
+	err := cs.db.Model(&models.ChatConversation{}).
+		Where("id = ? AND user_id = ?", conversationID, userID).
+		Count(&count).Error
 	if err != nil {
 		return err
 	}
-
-	// Create the link
-	conversationDoc := models.ConversationDocument{
-		ID:             uuid.New(),
-		ConversationID: conversationID,
-		ContentItemID:  contentItemID,
-		AddedAt:        time.Now(),
+	if count == 0 {
+		return ErrConversationNotFound
 	}
-
-	return cs.db.Create(&conversationDoc).Error
-}
\ No newline at end of file
+	return nil
+}
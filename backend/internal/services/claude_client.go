@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"time"
+	"strings"
+
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+	"github.com/tanaymehhta/self/backend/internal/resilient"
 )
 
 // ClaudeClient implements LLMClient interface using Anthropic's Claude API
@@ -24,6 +27,7 @@ type ClaudeRequest struct {
 	MaxTokens int           `json:"max_tokens"`
 	Messages  []ClaudeMessage `json:"messages"`
 	System    string         `json:"system,omitempty"`
+	Stream    bool           `json:"stream,omitempty"`
 }
 
 // ClaudeMessage represents a message in Claude format
@@ -35,6 +39,13 @@ type ClaudeMessage struct {
 // ClaudeResponse represents the response from Claude API
 type ClaudeResponse struct {
 	Content []ClaudeContent `json:"content"`
+	Usage   ClaudeUsage     `json:"usage"`
+}
+
+// ClaudeUsage is the token count Claude reports for a call
+type ClaudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 // ClaudeContent represents content in Claude response
@@ -52,90 +63,209 @@ func NewClaudeClient(apiKey, model string) *ClaudeClient {
 		apiKey:  apiKey,
 		baseURL: "https://api.anthropic.com/v1/messages",
 		model:   model,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		// Shared per provider so retries, the concurrency limit and the circuit
+		// breaker apply across requests
+		httpClient: resilient.NewClient(resilient.Shared("claude")),
+	}
+}
+
+// NewClaudeClientWithBaseURL creates a client for an endpoint speaking the
+// Anthropic Messages API. baseURL is the API root such as https://api.anthropic.com/v1
+// (or a local stand-in, see internal/llmfake); empty keeps the default.
+func NewClaudeClientWithBaseURL(baseURL, apiKey, model string) *ClaudeClient {
+	client := NewClaudeClient(apiKey, model)
+	if baseURL != "" {
+		client.baseURL = strings.TrimRight(baseURL, "/") + "/messages"
 	}
+	return client
+}
+
+// Model returns the Claude model this client calls
+func (c *ClaudeClient) Model() string {
+	return c.model
 }
 
 // ExtractAnswer implements LLMClient interface
 func (c *ClaudeClient) ExtractAnswer(ctx context.Context, query, chunk string) (*LLMResponse, error) {
-	// Create the system prompt for answer extraction
-	systemPrompt := `You are an expert at extracting specific answers from text chunks.
+	systemPrompt, userPrompt, err := renderPrompt(ctx, prompts.AnswerExtraction, map[string]string{
+		"query": query,
+		"chunk": chunk,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-Your task:
-1. Read the text chunk carefully
-2. Determine if it contains information that answers the user's query
-3. If it does, extract the most precise answer
-4. If it doesn't, indicate there's no relevant answer
+	// Prefilling "{" makes Claude start its reply with the JSON object
+	return parseLLMResponse(ctx, "claude", c.structuredCall(systemPrompt, userPrompt, 500, "{"))
+}
 
-Respond with a JSON object containing:
-- "answer": The extracted answer (or empty string if no answer)
-- "confidence": Float between 0.0-1.0 indicating your confidence
-- "has_answer": Boolean indicating if chunk contains relevant answer
-- "reasoning": Brief explanation of your decision
+// ExtractAnswers implements BatchLLMClient, judging several chunks in one call
+func (c *ClaudeClient) ExtractAnswers(ctx context.Context, query string, chunks []string) ([]*LLMResponse, error) {
+	systemPrompt, userPrompt, err := renderPrompt(ctx, prompts.BatchExtraction, batchExtractionVariables(query, chunks))
+	if err != nil {
+		return nil, err
+	}
+	call := c.structuredCall(systemPrompt, userPrompt, batchAnswerTokens*len(chunks), "[")
+	return extractBatchStructured(ctx, "claude", call, len(chunks))
+}
 
-Guidelines:
-- Be precise and concise in answers
-- Only extract information actually present in the chunk
-- Don't make assumptions or add external knowledge
-- Confidence should reflect how directly the chunk answers the query`
+// structuredCall builds a request whose assistant turn is prefilled with the
+// opening bracket, Claude's equivalent of a JSON mode. A repair resends the
+// conversation with the rejected output and the repair instruction appended.
+func (c *ClaudeClient) structuredCall(systemPrompt, userPrompt string, maxTokens int, prefill string) StructuredCall {
+	return func(ctx context.Context, repair *StructuredRepair) (string, error) {
+		messages := []ClaudeMessage{
+			{Role: "user", Content: userPrompt},
+		}
+		if repair != nil {
+			previous := strings.TrimSpace(repair.PreviousOutput)
+			if previous == "" {
+				previous = "(empty response)"
+			}
+			messages = append(messages,
+				ClaudeMessage{Role: "assistant", Content: previous},
+				ClaudeMessage{Role: "user", Content: repair.Instruction},
+			)
+		}
+		messages = append(messages, ClaudeMessage{Role: "assistant", Content: prefill})
 
-	userPrompt := fmt.Sprintf(`Query: %s
+		request := ClaudeRequest{
+			Model:     c.model,
+			MaxTokens: maxTokens,
+			System:    systemPrompt,
+			Messages:  messages,
+		}
 
-Text Chunk:
-%s
+		response, err := c.callClaude(ctx, request)
+		if err != nil {
+			return "", fmt.Errorf("Claude API call failed: %w", err)
+		}
 
-Extract the answer from this chunk:`, query, chunk)
+		if len(response.Content) == 0 {
+			return "", fmt.Errorf("no content returned from Claude")
+		}
 
-	// Prepare Claude request
+		return prefill + response.Content[0].Text, nil
+	}
+}
+
+// GenerateText implements TextGenerator for free-form responses
+func (c *ClaudeClient) GenerateText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int) (string, error) {
 	request := ClaudeRequest{
 		Model:     c.model,
-		MaxTokens: 500,
+		MaxTokens: maxTokens,
 		System:    systemPrompt,
 		Messages: []ClaudeMessage{
 			{Role: "user", Content: userPrompt},
 		},
 	}
 
-	// Make API call
 	response, err := c.callClaude(ctx, request)
 	if err != nil {
-		return nil, fmt.Errorf("Claude API call failed: %w", err)
+		return "", fmt.Errorf("Claude API call failed: %w", err)
 	}
 
-	// Parse Claude response
 	if len(response.Content) == 0 {
-		return nil, fmt.Errorf("no content returned from Claude")
+		return "", fmt.Errorf("no content returned from Claude")
 	}
 
-	content := response.Content[0].Text
+	return response.Content[0].Text, nil
+}
 
-	// Parse JSON response from LLM
-	var llmResponse LLMResponse
-	if err := json.Unmarshal([]byte(content), &llmResponse); err != nil {
-		// If JSON parsing fails, create a fallback response
-		return &LLMResponse{
-			Answer:     "",
-			Confidence: 0.0,
-			HasAnswer:  false,
-			Reasoning:  fmt.Sprintf("Failed to parse Claude response: %v", err),
-		}, nil
+// StreamText implements TextStreamer, calling onToken with each piece of text
+// as Claude produces it and returning the whole text
+func (c *ClaudeClient) StreamText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int, onToken func(string) error) (string, error) {
+	request := ClaudeRequest{
+		Model:     c.model,
+		MaxTokens: maxTokens,
+		System:    systemPrompt,
+		Messages: []ClaudeMessage{
+			{Role: "user", Content: userPrompt},
+		},
+		Stream: true,
 	}
 
-	// Validate confidence score
-	if llmResponse.Confidence < 0.0 {
-		llmResponse.Confidence = 0.0
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("Claude API call failed: %w", err)
 	}
-	if llmResponse.Confidence > 1.0 {
-		llmResponse.Confidence = 1.0
+	defer resp.Body.Close()
+
+	// Endpoints that ignore "stream" (e.g. the llmfake server) answer in one piece
+	if !isEventStream(resp) {
+		response, err := c.decodeResponse(ctx, request, resp)
+		if err != nil {
+			return "", err
+		}
+		if len(response.Content) == 0 {
+			return "", fmt.Errorf("no content returned from Claude")
+		}
+		return response.Content[0].Text, onToken(response.Content[0].Text)
 	}
 
-	return &llmResponse, nil
+	var (
+		text  strings.Builder
+		usage ClaudeUsage
+	)
+	err = readServerSentEvents(resp.Body, func(event, data string) error {
+		switch event {
+		case "message_start":
+			var start struct {
+				Message struct {
+					Usage ClaudeUsage `json:"usage"`
+				} `json:"message"`
+			}
+			if err := json.Unmarshal([]byte(data), &start); err == nil {
+				usage.InputTokens = start.Message.Usage.InputTokens
+			}
+		case "content_block_delta":
+			var delta struct {
+				Delta struct {
+					Text string `json:"text"`
+				} `json:"delta"`
+			}
+			if err := json.Unmarshal([]byte(data), &delta); err != nil {
+				return fmt.Errorf("invalid stream event: %w", err)
+			}
+			if delta.Delta.Text != "" {
+				text.WriteString(delta.Delta.Text)
+				return onToken(delta.Delta.Text)
+			}
+		case "message_delta":
+			var delta struct {
+				Usage ClaudeUsage `json:"usage"`
+			}
+			if err := json.Unmarshal([]byte(data), &delta); err == nil {
+				usage.OutputTokens = delta.Usage.OutputTokens
+			}
+		case "error":
+			return fmt.Errorf("Claude stream error: %s", data)
+		}
+		return nil
+	})
+
+	// A broken stream is still billed for the prompt and whatever was produced;
+	// counts the stream never reported are estimated from the text
+	recordUsage(ctx, "claude", request.Model, usage.InputTokens, usage.OutputTokens, request.System+userPrompt, text.String())
+	if err != nil {
+		return text.String(), fmt.Errorf("Claude stream failed: %w", err)
+	}
+	return text.String(), nil
 }
 
 // callClaude makes the actual HTTP request to Claude API
 func (c *ClaudeClient) callClaude(ctx context.Context, request ClaudeRequest) (*ClaudeResponse, error) {
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return c.decodeResponse(ctx, request, resp)
+}
+
+// send posts a request and returns the response once its status is OK
+func (c *ClaudeClient) send(ctx context.Context, request ClaudeRequest) (*http.Response, error) {
 	// Marshal request to JSON
 	jsonData, err := json.Marshal(request)
 	if err != nil {
@@ -158,24 +288,43 @@ func (c *ClaudeClient) callClaude(ctx context.Context, request ClaudeRequest) (*
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
-	defer resp.Body.Close()
 
+	// Check for HTTP errors
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Claude API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
+
+// decodeResponse reads a complete (non-streamed) response and records its usage
+func (c *ClaudeClient) decodeResponse(ctx context.Context, request ClaudeRequest, resp *http.Response) (*ClaudeResponse, error) {
 	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Check for HTTP errors
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Claude API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
 	// Parse response
 	var response ClaudeResponse
 	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
+	// Attribute token usage to the caller's scope (if any)
+	if _, ok := usageScopeFrom(ctx); ok {
+		var prompt, completion strings.Builder
+		prompt.WriteString(request.System)
+		for _, message := range request.Messages {
+			prompt.WriteString(message.Content)
+		}
+		for _, content := range response.Content {
+			completion.WriteString(content.Text)
+		}
+		recordUsage(ctx, "claude", request.Model, response.Usage.InputTokens, response.Usage.OutputTokens, prompt.String(), completion.String())
+	}
+
 	return &response, nil
-}
\ No newline at end of file
+}
@@ -4,15 +4,21 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/sashabaranov/go-openai"
+
+	"github.com/tanaymehhta/self/backend/internal/cache"
+	"github.com/tanaymehhta/self/backend/internal/resilient"
 )
 
 type EmbeddingService struct {
-	client    *openai.Client
-	model     string
-	dimension int
+	client     *openai.Client
+	model      string
+	dimension  int
+	queryCache *cache.Cache
+	usage      UsageScope
 }
 
 func NewEmbeddingService() *EmbeddingService {
@@ -26,13 +32,74 @@ func NewEmbeddingService() *EmbeddingService {
 		}
 	}
 
+	return NewOpenAIEmbeddingService(os.Getenv("OPENAI_BASE_URL"), apiKey)
+}
+
+// NewOpenAIEmbeddingService embeds with the OpenAI embeddings API at baseURL,
+// the API root such as https://api.openai.com/v1; empty keeps the default
+func NewOpenAIEmbeddingService(baseURL, apiKey string) *EmbeddingService {
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.HTTPClient = resilient.NewClient(resilient.Shared("openai-embeddings"))
+	if baseURL != "" {
+		clientConfig.BaseURL = strings.TrimRight(baseURL, "/")
+	}
+
 	return &EmbeddingService{
-		client:    openai.NewClient(apiKey),
+		client:    openai.NewClientWithConfig(clientConfig),
 		model:     "text-embedding-ada-002", // Reliable model
 		dimension: 1536,
 	}
 }
 
+// NewDeterministicEmbeddingService always uses the hash-based mock embedder,
+// regardless of OPENAI_API_KEY, so vectors are reproducible offline
+func NewDeterministicEmbeddingService() *EmbeddingService {
+	return &EmbeddingService{
+		client:    nil,
+		model:     "text-embedding-ada-002",
+		dimension: 1536,
+	}
+}
+
+// SetUsageScope attributes embedding calls to a user and feature
+func (e *EmbeddingService) SetUsageScope(scope UsageScope) {
+	e.usage = scope
+}
+
+// SetQueryCache enables caching of query embeddings (see EmbedQuery)
+func (e *EmbeddingService) SetQueryCache(queryCache *cache.Cache) {
+	e.queryCache = queryCache
+}
+
+// EmbedQuery embeds a search query, reusing the cached vector for repeated queries.
+// Chunk embeddings during ingestion go through CreateEmbedding and skip the cache.
+func (e *EmbeddingService) EmbedQuery(ctx context.Context, text string) (*Embedding, error) {
+	if e.queryCache == nil {
+		return e.CreateEmbedding(text)
+	}
+
+	// The mock embedder produces different vectors than the real model
+	modelKey := e.model
+	if e.client == nil {
+		modelKey = "mock-embedding-dev"
+	}
+	key := cache.Key(modelKey, text)
+
+	var cached Embedding
+	if e.queryCache.GetJSON(ctx, key, &cached) {
+		cached.ID = uuid.New()
+		return &cached, nil
+	}
+
+	embedding, err := e.CreateEmbedding(text)
+	if err != nil {
+		return nil, err
+	}
+
+	e.queryCache.SetJSON(ctx, key, embedding)
+	return embedding, nil
+}
+
 func (e *EmbeddingService) CreateEmbedding(text string) (*Embedding, error) {
 	if e.client == nil {
 		// Mock embedding for development
@@ -48,6 +115,8 @@ func (e *EmbeddingService) CreateEmbedding(text string) (*Embedding, error) {
 		return nil, fmt.Errorf("failed to create embedding: %w", err)
 	}
 
+	e.usage.RecordEmbedding("openai", e.model, resp.Usage.PromptTokens, text)
+
 	return &Embedding{
 		ID:               uuid.New(),
 		EmbeddingModel:   e.model,
@@ -0,0 +1,427 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tanaymehhta/self/backend/internal/models"
+)
+
+// Feedback ratings
+const (
+	FeedbackUp   = "up"
+	FeedbackDown = "down"
+)
+
+const (
+	// similarQueryOverlap is the word overlap at which two queries count as similar
+	similarQueryOverlap = 0.5
+
+	// feedbackDemotionVotes is how many more "down" than "up" votes a chunk needs
+	// for similar queries before it is demoted
+	feedbackDemotionVotes = 2
+
+	// feedbackPenaltyPerVote and maxFeedbackPenalty set how far a demoted
+	// chunk's score drops per net "down" vote, and at most
+	feedbackPenaltyPerVote = 0.2
+	maxFeedbackPenalty     = 0.6
+)
+
+// AnswerFeedback is a user's rating of a chat answer, or of one source of a
+// chat answer or QA search, with what is needed to replay it as an eval case
+type AnswerFeedback struct {
+	ID             uuid.UUID  `json:"id"`
+	UserID         uuid.UUID  `json:"user_id"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	MessageID      *uuid.UUID `json:"message_id,omitempty"`      // Chat answer rated, or whose source is rated
+	SearchQueryID  *uuid.UUID `json:"search_query_id,omitempty"` // QA search whose result is rated
+	ChunkID        string     `json:"chunk_id,omitempty"`        // Source rated; empty for a whole answer
+	Rating         string     `json:"rating"`                    // "up" or "down"
+	Correction     *string    `json:"correction,omitempty"`      // What the answer should have said
+
+	// Query the sources were retrieved for, the answer rated and the chunks behind it
+	Query    string   `json:"query"`
+	Answer   string   `json:"answer"`
+	ChunkIDs []string `json:"chunk_ids" gorm:"serializer:json"`
+
+	// Model and prompt templates that produced the answer
+	Model          string            `json:"model,omitempty"`
+	PromptVersions map[string]string `json:"prompt_versions,omitempty" gorm:"serializer:json"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (AnswerFeedback) TableName() string {
+	return "answer_feedback"
+}
+
+// FeedbackInput is a rating submitted by a user
+type FeedbackInput struct {
+	Rating     string  `json:"rating"`
+	Correction *string `json:"correction"`
+	ChunkID    string  `json:"chunk_id"` // Rate one source instead of the whole answer
+	Answer     string  `json:"answer"`   // QA results only: the answer shown for the source
+}
+
+var (
+	// ErrInvalidRating is returned for a rating other than "up" or "down"
+	ErrInvalidRating = errors.New(`rating must be "up" or "down"`)
+
+	// ErrNotRateable is returned when rating a user message
+	ErrNotRateable = errors.New("only assistant replies can be rated")
+
+	// ErrSourceNotFound is returned when rating a chunk the answer didn't come from
+	ErrSourceNotFound = errors.New("source not found in answer")
+)
+
+// FeedbackService stores ratings of answers and sources, exports them as eval
+// data and turns repeated "down" votes into a ranking penalty
+type FeedbackService struct {
+	db *gorm.DB
+}
+
+func NewFeedbackService(db *gorm.DB) *FeedbackService {
+	return &FeedbackService{db: db}
+}
+
+// RateMessage rates a chat answer, or with input.ChunkID one of its sources.
+// Rating the same answer or source again replaces the earlier rating.
+func (f *FeedbackService) RateMessage(userID, conversationID, messageID uuid.UUID, input FeedbackInput) (*AnswerFeedback, error) {
+	if err := normalizeFeedback(&input); err != nil {
+		return nil, err
+	}
+
+	var owned int64
+	err := f.db.Model(&models.ChatConversation{}).
+		Where("id = ? AND user_id = ?", conversationID, userID).
+		Count(&owned).Error
+	if err != nil {
+		return nil, err
+	}
+	if owned == 0 {
+		return nil, ErrConversationNotFound
+	}
+
+	reply, err := getMessageNode(f.db, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Role != "assistant" {
+		return nil, ErrNotRateable
+	}
+
+	query := ""
+	if reply.ParentID != nil {
+		question, err := getMessageNode(f.db, conversationID, *reply.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		query = retrievalQuery(question)
+	}
+
+	sources := storedSources(reply.ChatMessage)
+	promptVersions := metadataStrings(reply.Metadata, "prompt_versions")
+	messageModels := metadataStrings(reply.Metadata, "models")
+
+	feedback := &AnswerFeedback{
+		UserID:         userID,
+		ConversationID: &conversationID,
+		MessageID:      &messageID,
+		ChunkID:        input.ChunkID,
+		Rating:         input.Rating,
+		Correction:     input.Correction,
+		Query:          query,
+		PromptVersions: promptVersions,
+	}
+
+	if input.ChunkID == "" {
+		feedback.Answer = reply.Content
+		feedback.ChunkIDs = citedChunkIDs(reply.ChatMessage, sources)
+		feedback.Model = messageModels["synthesis"]
+		if feedback.Model == "" {
+			feedback.Model = messageModels["extraction"]
+		}
+	} else {
+		source := findSource(sources, input.ChunkID)
+		if source == nil {
+			return nil, ErrSourceNotFound
+		}
+		feedback.Answer = source.Answer
+		feedback.ChunkIDs = []string{source.ChunkID}
+		feedback.Model = messageModels["extraction"]
+	}
+
+	return f.save(feedback, "message_id")
+}
+
+// RateSearchResult rates one result of a recorded QA search
+func (f *FeedbackService) RateSearchResult(userID, searchQueryID uuid.UUID, input FeedbackInput) (*AnswerFeedback, error) {
+	if err := normalizeFeedback(&input); err != nil {
+		return nil, err
+	}
+
+	var search SearchQuery
+	err := f.db.Where("id = ? AND user_id = ?", searchQueryID, userID).First(&search).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSearchQueryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up search query: %w", err)
+	}
+
+	found := false
+	for _, id := range search.TopResultIDs {
+		if id != "" && id == input.ChunkID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrSourceNotFound
+	}
+
+	feedback := &AnswerFeedback{
+		UserID:        userID,
+		SearchQueryID: &searchQueryID,
+		ChunkID:       input.ChunkID,
+		Rating:        input.Rating,
+		Correction:    input.Correction,
+		Query:         search.Query,
+		Answer:        strings.TrimSpace(input.Answer),
+		ChunkIDs:      []string{input.ChunkID},
+		Model:         search.Model,
+	}
+	if search.PromptVersion != "" {
+		feedback.PromptVersions = map[string]string{"extraction": search.PromptVersion}
+	}
+
+	return f.save(feedback, "search_query_id")
+}
+
+// save stores the feedback, replacing the user's earlier rating of the same
+// answer or source. target is the rated column, message_id or search_query_id;
+// with chunk_id it matches one of the table's partial unique indexes, so a
+// repeated or concurrent vote updates the one row.
+func (f *FeedbackService) save(feedback *AnswerFeedback, target string) (*AnswerFeedback, error) {
+	now := time.Now()
+	if feedback.ChunkIDs == nil {
+		feedback.ChunkIDs = []string{}
+	}
+	feedback.ID = uuid.New()
+	feedback.CreatedAt = now
+	feedback.UpdatedAt = now
+
+	// On conflict the existing row's id and created_at are read back
+	err := f.db.Clauses(
+		clause.OnConflict{
+			Columns:     []clause.Column{{Name: "user_id"}, {Name: target}, {Name: "chunk_id"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: target + " IS NOT NULL"}}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"rating", "correction", "query", "answer", "chunk_ids", "model", "prompt_versions", "updated_at",
+			}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "created_at"}}},
+	).Create(feedback).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save feedback: %w", err)
+	}
+
+	return feedback, nil
+}
+
+// Dataset returns the user's feedback since the given time, oldest first, for
+// export as eval data
+func (f *FeedbackService) Dataset(userID uuid.UUID, since time.Time) ([]AnswerFeedback, error) {
+	feedback := []AnswerFeedback{}
+	err := f.db.Where("user_id = ? AND updated_at >= ?", userID, since).
+		Order("created_at ASC, id ASC").
+		Find(&feedback).Error
+
+	return feedback, err
+}
+
+// ApplyPenalties sets FeedbackPenalty on answers from chunks the user has
+// repeatedly marked wrong for queries similar to query
+func (f *FeedbackService) ApplyPenalties(userID uuid.UUID, query string, answers []*AnswerResult) error {
+	var chunkIDs []string
+	seen := make(map[string]bool)
+	for _, answer := range answers {
+		if answer != nil && answer.ChunkID != "" && !seen[answer.ChunkID] {
+			seen[answer.ChunkID] = true
+			chunkIDs = append(chunkIDs, answer.ChunkID)
+		}
+	}
+	if len(chunkIDs) == 0 {
+		return nil
+	}
+
+	penalties, err := f.ChunkPenalties(userID, query, chunkIDs)
+	if err != nil {
+		return err
+	}
+	for _, answer := range answers {
+		if answer != nil {
+			answer.FeedbackPenalty = penalties[answer.ChunkID]
+		}
+	}
+	return nil
+}
+
+// ChunkPenalties returns the demotion for each chunk with more "down" than "up"
+// votes, by feedbackDemotionVotes or more, from queries similar to query.
+// Source ratings and QA "helpful"/"not_helpful" interactions both count.
+func (f *FeedbackService) ChunkPenalties(userID uuid.UUID, query string, chunkIDs []string) (map[string]float64, error) {
+	var votes []struct {
+		ChunkID string
+		Query   string
+		Rating  string
+	}
+	err := f.db.Raw(`
+		SELECT chunk_id, query, rating
+		FROM answer_feedback
+		WHERE user_id = ? AND chunk_id IN ?
+		UNION ALL
+		SELECT si.result_id AS chunk_id, sq.query,
+		       CASE WHEN si.action = 'helpful' THEN 'up' ELSE 'down' END AS rating
+		FROM search_interactions si
+		JOIN search_queries sq ON si.search_query_id = sq.id
+		WHERE si.user_id = ? AND si.result_id IN ? AND si.action IN ('helpful', 'not_helpful')
+	`, userID, chunkIDs, userID, chunkIDs).Scan(&votes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feedback votes: %w", err)
+	}
+
+	words := answerWords(query)
+	net := make(map[string]int)
+	for _, vote := range votes {
+		if jaccard(words, answerWords(vote.Query)) < similarQueryOverlap {
+			continue
+		}
+		if vote.Rating == FeedbackDown {
+			net[vote.ChunkID]++
+		} else {
+			net[vote.ChunkID]--
+		}
+	}
+
+	penalties := make(map[string]float64)
+	for chunkID, downVotes := range net {
+		if downVotes >= feedbackDemotionVotes {
+			penalties[chunkID] = min(maxFeedbackPenalty, feedbackPenaltyPerVote*float64(downVotes))
+		}
+	}
+	return penalties, nil
+}
+
+// normalizeFeedback validates the rating and drops a blank correction
+func normalizeFeedback(input *FeedbackInput) error {
+	input.Rating = strings.ToLower(strings.TrimSpace(input.Rating))
+	if input.Rating != FeedbackUp && input.Rating != FeedbackDown {
+		return ErrInvalidRating
+	}
+	input.ChunkID = strings.TrimSpace(input.ChunkID)
+	if input.Correction != nil {
+		correction := strings.TrimSpace(*input.Correction)
+		if correction == "" {
+			input.Correction = nil
+		} else {
+			input.Correction = &correction
+		}
+	}
+	return nil
+}
+
+// retrievalQuery is the query a user message was searched with: its rewrite,
+// if it had one, or the message itself
+func retrievalQuery(question *ChatMessageNode) string {
+	if rewrite, ok := question.Metadata["rewritten_query"].(map[string]interface{}); ok {
+		if query, ok := rewrite["query"].(string); ok && query != "" {
+			return query
+		}
+	}
+	return question.Content
+}
+
+// storedSources decodes the answers saved with a chat reply. Replies saved
+// before sources were kept under "answers" have none, so their sources are
+// rebuilt from the citation list, which has the chunk, title and excerpt of
+// each source the reply cited but not the extracted answer.
+func storedSources(message models.ChatMessage) []AnswerResult {
+	var sources []AnswerResult
+	if answers, ok := message.Sources["answers"]; ok {
+		if data, err := json.Marshal(answers); err == nil {
+			json.Unmarshal(data, &sources)
+		}
+		return sources
+	}
+
+	for _, citation := range storedCitations(message) {
+		sources = append(sources, AnswerResult{
+			HasAnswer:   true,
+			ChunkID:     citation.ChunkID,
+			SourceChunk: citation.Excerpt,
+			SourceTitle: citation.Title,
+			ContentType: citation.ContentType,
+			StartTime:   citation.StartTime,
+			EndTime:     citation.EndTime,
+			Speaker:     citation.Speaker,
+			PageNum:     citation.PageNum,
+			Grounded:    citation.Grounded,
+		})
+	}
+	return sources
+}
+
+// storedCitations decodes the citation list saved with a chat reply
+func storedCitations(message models.ChatMessage) []Citation {
+	var citations []Citation
+	if data, err := json.Marshal(message.Metadata["citations"]); err == nil {
+		json.Unmarshal(data, &citations)
+	}
+	return citations
+}
+
+// citedChunkIDs lists the chunks a reply cites, or all its sources' chunks
+// when it has no citation list
+func citedChunkIDs(message models.ChatMessage, sources []AnswerResult) []string {
+	ids := []string{}
+	for _, citation := range storedCitations(message) {
+		ids = append(ids, citation.ChunkID)
+	}
+	if len(ids) == 0 {
+		for _, source := range sources {
+			ids = append(ids, source.ChunkID)
+		}
+	}
+	return ids
+}
+
+func findSource(sources []AnswerResult, chunkID string) *AnswerResult {
+	for i := range sources {
+		if sources[i].ChunkID == chunkID {
+			return &sources[i]
+		}
+	}
+	return nil
+}
+
+// metadataStrings reads a map of strings saved in message metadata
+func metadataStrings(metadata models.JSONB, key string) map[string]string {
+	values := make(map[string]string)
+	if stored, ok := metadata[key].(map[string]interface{}); ok {
+		for name, value := range stored {
+			if text, ok := value.(string); ok {
+				values[name] = text
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// SourceSpan is a passage in the source chunk that supports an answer.
+// Start and End are character (rune) offsets into SourceChunk, End exclusive.
+type SourceSpan struct {
+	Start int     `json:"start"`
+	End   int     `json:"end"`
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+const (
+	// groundingThreshold is the share of answer words that must be found in the chunk
+	groundingThreshold = 0.6
+
+	// ungroundedPenalty scales the confidence of answers the chunk doesn't support
+	ungroundedPenalty = 0.5
+)
+
+var (
+	groundingWord    = regexp.MustCompile(`[\p{L}\p{N}]+`)
+	groundingClause  = regexp.MustCompile(`[.;!?\n]+`)
+	groundingFillers = map[string]bool{"the": true, "a": true, "an": true, "of": true, "is": true, "are": true, "was": true, "were": true, "to": true, "and": true, "in": true, "on": true, "it": true}
+)
+
+type groundingToken struct {
+	word       string
+	start, end int // byte offsets
+}
+
+// VerifyGrounding locates the parts of chunk that support answer. Each clause of
+// the answer is matched on its own against the best window of the chunk, so
+// paraphrases that reorder or drop a few words still ground. The score is the
+// share of answer words covered by the spans found.
+func VerifyGrounding(answer, chunk string) ([]SourceSpan, float64) {
+	answer = strings.TrimSpace(answer)
+	if answer == "" || chunk == "" {
+		return nil, 0
+	}
+
+	// Verbatim answers are the common case. Lowercasing can change byte lengths
+	// for some scripts, in which case the offsets below wouldn't line up.
+	lowerChunk, lowerAnswer := strings.ToLower(chunk), strings.ToLower(answer)
+	if len(lowerChunk) == len(chunk) && len(lowerAnswer) == len(answer) {
+		if index := strings.Index(lowerChunk, lowerAnswer); index >= 0 {
+			return []SourceSpan{newSourceSpan(chunk, index, index+len(answer), 1)}, 1
+		}
+	}
+
+	chunkTokens := tokenizeForGrounding(chunk)
+
+	var (
+		spans        []SourceSpan
+		totalWords   int
+		matchedWords float64
+	)
+	for _, clause := range groundingClause.Split(answer, -1) {
+		words := contentWords(tokenizeForGrounding(clause))
+		if len(words) == 0 {
+			continue
+		}
+		totalWords += len(words)
+
+		start, end, score := bestGroundingWindow(words, chunkTokens)
+		if score < groundingThreshold {
+			continue
+		}
+		matchedWords += score * float64(len(words))
+		spans = appendSpan(spans, newSourceSpan(chunk, start, end, score))
+	}
+
+	if totalWords == 0 {
+		return nil, 0
+	}
+	return spans, matchedWords / float64(totalWords)
+}
+
+// applyGrounding verifies an answer against its chunk, attaches the spans and
+// downgrades the confidence of answers the chunk doesn't support
+func applyGrounding(result *AnswerResult) {
+	if !result.HasAnswer {
+		return
+	}
+
+	spans, score := VerifyGrounding(result.Answer, result.SourceChunk)
+	result.Spans = spans
+	result.GroundingScore = score
+	result.Grounded = score >= groundingThreshold
+
+	if !result.Grounded {
+		result.Confidence *= ungroundedPenalty
+	}
+}
+
+// bestGroundingWindow slides a window over the chunk and returns the byte range
+// of the matched words in the window that covers the most clause words
+func bestGroundingWindow(words []string, chunkTokens []groundingToken) (int, int, float64) {
+	needed := make(map[string]int)
+	for _, word := range words {
+		needed[word]++
+	}
+
+	// Allow the source to spread the same words over twice the space
+	windowSize := len(words) * 2
+
+	bestMatched, bestStart, bestEnd := 0, 0, 0
+	for i := range chunkTokens {
+		if needed[chunkTokens[i].word] == 0 {
+			continue // windows start on a matching word
+		}
+
+		remaining := make(map[string]int, len(needed))
+		for word, count := range needed {
+			remaining[word] = count
+		}
+
+		matched, last := 0, i
+		for j := i; j < len(chunkTokens) && j < i+windowSize; j++ {
+			if remaining[chunkTokens[j].word] > 0 {
+				remaining[chunkTokens[j].word]--
+				matched++
+				last = j
+			}
+		}
+
+		if matched > bestMatched {
+			bestMatched = matched
+			bestStart = chunkTokens[i].start
+			bestEnd = chunkTokens[last].end
+		}
+	}
+
+	return bestStart, bestEnd, float64(bestMatched) / float64(len(words))
+}
+
+func tokenizeForGrounding(text string) []groundingToken {
+	var tokens []groundingToken
+	for _, loc := range groundingWord.FindAllStringIndex(text, -1) {
+		tokens = append(tokens, groundingToken{
+			word:  strings.ToLower(text[loc[0]:loc[1]]),
+			start: loc[0],
+			end:   loc[1],
+		})
+	}
+	return tokens
+}
+
+// contentWords drops filler words unless nothing else is left
+func contentWords(tokens []groundingToken) []string {
+	var words, all []string
+	for _, token := range tokens {
+		all = append(all, token.word)
+		if !groundingFillers[token.word] {
+			words = append(words, token.word)
+		}
+	}
+	if len(words) == 0 {
+		return all
+	}
+	return words
+}
+
+// newSourceSpan converts a byte range into a span with rune offsets
+func newSourceSpan(chunk string, start, end int, score float64) SourceSpan {
+	runeStart := utf8.RuneCountInString(chunk[:start])
+	return SourceSpan{
+		Start: runeStart,
+		End:   runeStart + utf8.RuneCountInString(chunk[start:end]),
+		Text:  chunk[start:end],
+		Score: score,
+	}
+}
+
+// appendSpan keeps the stronger of two overlapping spans so clauses from the
+// same sentence don't produce duplicates
+func appendSpan(spans []SourceSpan, span SourceSpan) []SourceSpan {
+	for i, existing := range spans {
+		if span.Start < existing.End && existing.Start < span.End {
+			if span.Score > existing.Score {
+				spans[i] = span
+			}
+			return spans
+		}
+	}
+	return append(spans, span)
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// InsightHub fans out newly created insights to a user's open WebSocket connections
+type InsightHub struct {
+	mu          sync.RWMutex
+	subscribers map[uuid.UUID]map[chan *Insight]struct{}
+}
+
+func NewInsightHub() *InsightHub {
+	return &InsightHub{
+		subscribers: make(map[uuid.UUID]map[chan *Insight]struct{}),
+	}
+}
+
+// Subscribe registers a listener for a user. Call the returned func to unsubscribe.
+func (h *InsightHub) Subscribe(userID uuid.UUID) (<-chan *Insight, func()) {
+	ch := make(chan *Insight, 16)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan *Insight]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[userID], ch)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers an insight to every listener of its user. Slow listeners
+// are skipped rather than blocking ingestion; the insight is still stored.
+func (h *InsightHub) Publish(insight *Insight) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for ch := range h.subscribers[insight.UserID] {
+		select {
+		case ch <- insight:
+		default:
+		}
+	}
+}
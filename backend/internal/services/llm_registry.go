@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Model roles an LLMRegistry routes
+const (
+	LLMRoleExtraction = "extraction"
+	LLMRoleSynthesis  = "synthesis"
+	LLMRoleTitling    = "titling"
+	LLMRoleRewriting  = "rewriting"
+)
+
+// ModelClient is what every provider client offers: per-chunk and batched
+// extraction plus free-form generation, whole or streamed
+type ModelClient interface {
+	BatchLLMClient
+	TextGenerator
+	TextStreamer
+	Model() string
+}
+
+// LLMProviderConfig describes one configured provider
+type LLMProviderConfig struct {
+	Name    string // Referenced from role lists, e.g. "claude", "openai", "local"
+	Kind    string // "anthropic" or "openai" (any OpenAI-compatible endpoint)
+	BaseURL string // API root; empty for the provider's default, e.g. http://localhost:11434/v1 for Ollama
+	APIKey  string
+}
+
+// ModelRef is one "provider:model" entry in a role's fallback order
+type ModelRef struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+func (m ModelRef) String() string {
+	return m.Provider + ":" + m.Model
+}
+
+// LLMRegistry builds clients for model roles from configured providers
+type LLMRegistry struct {
+	providers map[string]LLMProviderConfig
+	roles     map[string][]ModelRef
+}
+
+var (
+	// ErrUnknownModel is returned when a per-request model isn't in any role
+	ErrUnknownModel = errors.New("unknown model")
+
+	// ErrNoModelsForRole is returned when a role has no usable models
+	ErrNoModelsForRole = errors.New("no models configured for role")
+)
+
+// NewLLMRegistry validates role lists against the providers. Entries naming a
+// provider that isn't configured are dropped with a warning rather than failing
+// startup, so a missing API key only disables that provider.
+func NewLLMRegistry(providers []LLMProviderConfig, roles map[string]string) (*LLMRegistry, error) {
+	registry := &LLMRegistry{
+		providers: make(map[string]LLMProviderConfig),
+		roles:     make(map[string][]ModelRef),
+	}
+
+	for _, provider := range providers {
+		if provider.Kind != "anthropic" && provider.Kind != "openai" {
+			return nil, fmt.Errorf("provider %s has unsupported kind %q", provider.Name, provider.Kind)
+		}
+		registry.providers[provider.Name] = provider
+	}
+
+	for role, list := range roles {
+		refs, err := ParseModelRefs(list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid models for role %s: %w", role, err)
+		}
+
+		for _, ref := range refs {
+			if _, ok := registry.providers[ref.Provider]; !ok {
+				fmt.Printf("LLM role %s: provider %s is not configured, skipping %s\n", role, ref.Provider, ref)
+				continue
+			}
+			registry.roles[role] = append(registry.roles[role], ref)
+		}
+	}
+
+	return registry, nil
+}
+
+// ParseModelRefs parses a comma-separated "provider:model" fallback list
+func ParseModelRefs(list string) ([]ModelRef, error) {
+	var refs []ModelRef
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		provider, model, ok := strings.Cut(entry, ":")
+		if !ok || provider == "" || model == "" {
+			return nil, fmt.Errorf("expected provider:model, got %q", entry)
+		}
+		refs = append(refs, ModelRef{Provider: provider, Model: model})
+	}
+	return refs, nil
+}
+
+// Roles returns each role's fallback order
+func (r *LLMRegistry) Roles() map[string][]ModelRef {
+	return r.roles
+}
+
+// Client returns a client for a role that tries each model in order. A non-empty
+// model picks one model for this call instead; it may be "provider:model" or a
+// bare model name, and must appear in some role.
+func (r *LLMRegistry) Client(role, model string) (*FallbackClient, error) {
+	if model != "" {
+		ref, err := r.resolveModel(model)
+		if err != nil {
+			return nil, err
+		}
+		return &FallbackClient{clients: []ModelClient{r.newClient(ref)}}, nil
+	}
+
+	refs := r.roles[role]
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoModelsForRole, role)
+	}
+
+	clients := make([]ModelClient, len(refs))
+	for i, ref := range refs {
+		clients[i] = r.newClient(ref)
+	}
+	return &FallbackClient{clients: clients}, nil
+}
+
+// resolveModel finds a per-request model among the role lists, so clients can
+// only pick models an operator configured, not any model a provider offers
+func (r *LLMRegistry) resolveModel(model string) (ModelRef, error) {
+	provider, name, qualified := strings.Cut(model, ":")
+	for _, refs := range r.roles {
+		for _, ref := range refs {
+			if qualified && ref.Provider == provider && ref.Model == name {
+				return ref, nil
+			}
+			if !qualified && ref.Model == model {
+				return ref, nil
+			}
+		}
+	}
+	return ModelRef{}, fmt.Errorf("%w: %s", ErrUnknownModel, model)
+}
+
+func (r *LLMRegistry) newClient(ref ModelRef) ModelClient {
+	provider := r.providers[ref.Provider]
+	if provider.Kind == "anthropic" {
+		return NewClaudeClientWithBaseURL(provider.BaseURL, provider.APIKey, ref.Model)
+	}
+	return NewOpenAICompatibleClient(provider.Name, provider.BaseURL, provider.APIKey, ref.Model)
+}
+
+// FallbackClient tries each model in order until one succeeds. It stops early
+// when the request's context is done, since the next model would fail too.
+type FallbackClient struct {
+	clients []ModelClient
+
+	mu         sync.Mutex
+	answeredBy string // Model that answered the latest call, or streamed part of its answer
+}
+
+// Model returns the model that answered the latest successful call, or the
+// primary model before any has
+func (f *FallbackClient) Model() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.answeredBy != "" {
+		return f.answeredBy
+	}
+	return f.clients[0].Model()
+}
+
+// answered records which model a call was answered by and returns its name
+func (f *FallbackClient) answered(client ModelClient) string {
+	model := client.Model()
+	f.mu.Lock()
+	f.answeredBy = model
+	f.mu.Unlock()
+	return model
+}
+
+func (f *FallbackClient) ExtractAnswer(ctx context.Context, query, chunk string) (*LLMResponse, error) {
+	var lastErr error
+	for _, client := range f.clients {
+		response, err := client.ExtractAnswer(ctx, query, chunk)
+		if err == nil {
+			response.Model = f.answered(client)
+			return response, nil
+		}
+		lastErr = f.logFallback(client, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (f *FallbackClient) ExtractAnswers(ctx context.Context, query string, chunks []string) ([]*LLMResponse, error) {
+	var lastErr error
+	for _, client := range f.clients {
+		responses, err := client.ExtractAnswers(ctx, query, chunks)
+		if err == nil {
+			model := f.answered(client)
+			for _, response := range responses {
+				if response != nil {
+					response.Model = model
+				}
+			}
+			return responses, nil
+		}
+		lastErr = f.logFallback(client, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (f *FallbackClient) GenerateText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int) (string, error) {
+	var lastErr error
+	for _, client := range f.clients {
+		text, err := client.GenerateText(ctx, systemPrompt, userPrompt, maxTokens)
+		if err == nil {
+			f.answered(client)
+			return text, nil
+		}
+		lastErr = f.logFallback(client, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", lastErr
+}
+
+// StreamText falls back to the next model only until the first token has been
+// passed on; after that the failure is returned with the partial text, since
+// the caller has already shown part of the answer
+func (f *FallbackClient) StreamText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int, onToken func(string) error) (string, error) {
+	var lastErr error
+	for _, client := range f.clients {
+		streamed := false
+		text, err := client.StreamText(ctx, systemPrompt, userPrompt, maxTokens, func(token string) error {
+			streamed = true
+			return onToken(token)
+		})
+		if err == nil {
+			f.answered(client)
+			return text, nil
+		}
+		lastErr = f.logFallback(client, err)
+		if streamed {
+			f.answered(client)
+			return text, lastErr
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", lastErr
+}
+
+func (f *FallbackClient) logFallback(client ModelClient, err error) error {
+	if len(f.clients) > 1 {
+		fmt.Printf("LLM model %s failed, trying next: %v\n", client.Model(), err)
+	}
+	return fmt.Errorf("%s: %w", client.Model(), err)
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// stubModel is a ModelClient whose StreamText sends tokens, then fails with
+// err if it is set
+type stubModel struct {
+	stubStreamer
+	model string
+	calls int
+}
+
+func (s *stubModel) Model() string { return s.model }
+
+func (s *stubModel) ExtractAnswer(ctx context.Context, query, chunk string) (*LLMResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *stubModel) ExtractAnswers(ctx context.Context, query string, chunks []string) ([]*LLMResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *stubModel) StreamText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int, onToken func(string) error) (string, error) {
+	s.calls++
+	return s.stubStreamer.StreamText(ctx, systemPrompt, userPrompt, maxTokens, onToken)
+}
+
+func TestFallbackClientStreamText(t *testing.T) {
+	reset := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		clients   []*stubModel
+		wantText  string
+		wantErr   bool
+		wantCalls []int
+		wantModel string
+	}{
+		{
+			name: "primary streams",
+			clients: []*stubModel{
+				{model: "primary", stubStreamer: stubStreamer{tokens: []string{"Par", "is"}}},
+				{model: "backup", stubStreamer: stubStreamer{tokens: []string{"Lyon"}}},
+			},
+			wantText:  "Paris",
+			wantCalls: []int{1, 0},
+			wantModel: "primary",
+		},
+		{
+			name: "falls back before the first token",
+			clients: []*stubModel{
+				{model: "primary", stubStreamer: stubStreamer{err: reset}},
+				{model: "backup", stubStreamer: stubStreamer{tokens: []string{"Paris"}}},
+			},
+			wantText:  "Paris",
+			wantCalls: []int{1, 1},
+			wantModel: "backup",
+		},
+		{
+			name: "mid-stream failure keeps the partial text",
+			clients: []*stubModel{
+				{model: "primary", stubStreamer: stubStreamer{tokens: []string{"Par"}, err: reset}},
+				{model: "backup", stubStreamer: stubStreamer{tokens: []string{"Paris"}}},
+			},
+			wantText:  "Par",
+			wantErr:   true,
+			wantCalls: []int{1, 0},
+			wantModel: "primary",
+		},
+		{
+			name: "every model fails",
+			clients: []*stubModel{
+				{model: "primary", stubStreamer: stubStreamer{err: reset}},
+				{model: "backup", stubStreamer: stubStreamer{err: reset}},
+			},
+			wantErr:   true,
+			wantCalls: []int{1, 1},
+			wantModel: "primary",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := make([]ModelClient, len(tt.clients))
+			for i, client := range tt.clients {
+				clients[i] = client
+			}
+			fallback := &FallbackClient{clients: clients}
+
+			var streamed strings.Builder
+			text, err := fallback.StreamText(context.Background(), "system", "user", 100, func(token string) error {
+				streamed.WriteString(token)
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, reset) {
+				t.Errorf("error = %v, want it to wrap %v", err, reset)
+			}
+			if text != tt.wantText || streamed.String() != tt.wantText {
+				t.Errorf("text = %q, streamed %q; want %q", text, streamed.String(), tt.wantText)
+			}
+			for i, client := range tt.clients {
+				if client.calls != tt.wantCalls[i] {
+					t.Errorf("%s called %d times, want %d", client.model, client.calls, tt.wantCalls[i])
+				}
+			}
+			if got := fallback.Model(); got != tt.wantModel {
+				t.Errorf("Model = %q, want %q", got, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestFallbackClientStreamTextStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	backup := &stubModel{model: "backup", stubStreamer: stubStreamer{tokens: []string{"Paris"}}}
+	fallback := &FallbackClient{clients: []ModelClient{
+		&stubModel{model: "primary", stubStreamer: stubStreamer{err: context.Canceled}},
+		backup,
+	}}
+
+	if _, err := fallback.StreamText(ctx, "system", "user", 100, func(string) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if backup.calls != 0 {
+		t.Errorf("backup called %d times after cancellation", backup.calls)
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sseServer answers every request with the given server-sent events
+func sseServer(t *testing.T, events ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "%s\n\n", event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func claudeDelta(text string) string {
+	return fmt.Sprintf("event: content_block_delta\ndata: {\"delta\": {\"type\": \"text_delta\", \"text\": %q}}", text)
+}
+
+func openAIDelta(text string) string {
+	return fmt.Sprintf("data: {\"choices\": [{\"delta\": {\"content\": %q}}]}", text)
+}
+
+func TestStreamTextReadsServerSentEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		streamer   func(baseURL string) TextStreamer
+		events     []string
+		wantTokens []string
+		wantErr    string
+	}{
+		{
+			name:     "claude",
+			streamer: func(baseURL string) TextStreamer { return NewClaudeClientWithBaseURL(baseURL, "key", "") },
+			events: []string{
+				`event: message_start` + "\n" + `data: {"message": {"usage": {"input_tokens": 12}}}`,
+				claudeDelta("Paris is"),
+				`event: ping` + "\n" + `data: {}`,
+				claudeDelta(" the capital [1]."),
+				`event: message_delta` + "\n" + `data: {"usage": {"output_tokens": 7}}`,
+				`event: message_stop` + "\n" + `data: {}`,
+			},
+			wantTokens: []string{"Paris is", " the capital [1]."},
+		},
+		{
+			name:     "claude error event",
+			streamer: func(baseURL string) TextStreamer { return NewClaudeClientWithBaseURL(baseURL, "key", "") },
+			events: []string{
+				claudeDelta("Paris is"),
+				`event: error` + "\n" + `data: {"type": "overloaded_error"}`,
+				claudeDelta(" never sent"),
+			},
+			wantTokens: []string{"Paris is"},
+			wantErr:    "Claude stream error",
+		},
+		{
+			name:     "openai",
+			streamer: func(baseURL string) TextStreamer { return NewOpenAICompatibleClient("test-openai", baseURL, "", "") },
+			events: []string{
+				openAIDelta("Paris is"),
+				openAIDelta(" the capital [1]."),
+				`data: {"choices": [], "usage": {"prompt_tokens": 12, "completion_tokens": 7}}`,
+				`data: [DONE]`,
+			},
+			wantTokens: []string{"Paris is", " the capital [1]."},
+		},
+		{
+			name:     "openai invalid chunk",
+			streamer: func(baseURL string) TextStreamer { return NewOpenAICompatibleClient("test-openai", baseURL, "", "") },
+			events: []string{
+				openAIDelta("Paris is"),
+				`data: {"choices": [`,
+				openAIDelta(" never sent"),
+			},
+			wantTokens: []string{"Paris is"},
+			wantErr:    "invalid stream chunk",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := sseServer(t, tt.events...)
+
+			var tokens []string
+			text, err := tt.streamer(server.URL).StreamText(context.Background(), "system", "user", 100, func(token string) error {
+				tokens = append(tokens, token)
+				return nil
+			})
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("StreamText failed: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if strings.Join(tokens, "|") != strings.Join(tt.wantTokens, "|") {
+				t.Errorf("tokens = %q, want %q", tokens, tt.wantTokens)
+			}
+			// The text streamed before a failure is returned with the error
+			if text != strings.Join(tt.wantTokens, "") {
+				t.Errorf("text = %q, want %q", text, strings.Join(tt.wantTokens, ""))
+			}
+		})
+	}
+}
+
+func TestReadServerSentEvents(t *testing.T) {
+	body := "event: first\ndata: one\ndata: two\n\n: comment\ndata:three\n\nevent: ignored\n\ndata: last"
+
+	var got []string
+	err := readServerSentEvents(strings.NewReader(body), func(event, data string) error {
+		got = append(got, event+"="+data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("readServerSentEvents failed: %v", err)
+	}
+
+	want := []string{"first=one\ntwo", "=three", "=last"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"time"
+	"strings"
+
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+	"github.com/tanaymehhta/self/backend/internal/resilient"
 )
 
 // OpenAIClient implements LLMClient interface using OpenAI's API
 type OpenAIClient struct {
+	provider   string // Label for metrics, e.g. "openai" or "local"
 	apiKey     string
 	baseURL    string
 	model      string
@@ -24,6 +28,21 @@ type OpenAIRequest struct {
 	Messages    []Message `json:"messages"`
 	Temperature float64   `json:"temperature"`
 	MaxTokens   int       `json:"max_tokens"`
+
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+// OpenAIStreamOptions asks for a final usage chunk on streamed responses
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIResponseFormat enables JSON mode ({"type": "json_object"})
+type OpenAIResponseFormat struct {
+	Type string `json:"type"`
 }
 
 // Message represents a chat message
@@ -34,7 +53,14 @@ type Message struct {
 
 // OpenAIResponse represents the response from OpenAI API
 type OpenAIResponse struct {
-	Choices []Choice `json:"choices"`
+	Choices []Choice    `json:"choices"`
+	Usage   OpenAIUsage `json:"usage"`
+}
+
+// OpenAIUsage is the token count reported for a call; some compatible servers omit it
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
 // Choice represents a response choice
@@ -49,94 +75,201 @@ func NewOpenAIClient(apiKey, model string) *OpenAIClient {
 	}
 
 	return &OpenAIClient{
-		apiKey:  apiKey,
-		baseURL: "https://api.openai.com/v1/chat/completions",
-		model:   model,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		provider: "openai",
+		apiKey:   apiKey,
+		baseURL:  "https://api.openai.com/v1/chat/completions",
+		model:    model,
+		// Shared per provider so retries, the concurrency limit and the circuit
+		// breaker apply across requests
+		httpClient: resilient.NewClient(resilient.Shared("openai")),
+	}
+}
+
+// NewOpenAICompatibleClient creates a client for any endpoint speaking the OpenAI
+// chat completions API (OpenAI itself, Ollama, vLLM, ...). baseURL is the API
+// root such as http://localhost:11434/v1; an empty apiKey sends no auth header.
+func NewOpenAICompatibleClient(provider, baseURL, apiKey, model string) *OpenAIClient {
+	client := NewOpenAIClient(apiKey, model)
+	client.provider = provider
+	client.httpClient = resilient.NewClient(resilient.Shared(provider))
+	if baseURL != "" {
+		client.baseURL = strings.TrimRight(baseURL, "/") + "/chat/completions"
 	}
+	return client
+}
+
+// Model returns the OpenAI model this client calls
+func (c *OpenAIClient) Model() string {
+	return c.model
 }
 
 // ExtractAnswer implements LLMClient interface
 func (c *OpenAIClient) ExtractAnswer(ctx context.Context, query, chunk string) (*LLMResponse, error) {
-	// Create the prompt for answer extraction
-	systemPrompt := `You are an expert at extracting specific answers from text chunks.
+	systemPrompt, userPrompt, err := renderPrompt(ctx, prompts.AnswerExtraction, map[string]string{
+		"query": query,
+		"chunk": chunk,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON mode guarantees a parseable object; validation and repair still apply
+	return parseLLMResponse(ctx, c.provider, c.structuredCall(systemPrompt, userPrompt, 500))
+}
 
-Your task:
-1. Read the text chunk carefully
-2. Determine if it contains information that answers the user's query
-3. If it does, extract the most precise answer
-4. If it doesn't, indicate there's no relevant answer
+// ExtractAnswers implements BatchLLMClient, judging several chunks in one call
+func (c *OpenAIClient) ExtractAnswers(ctx context.Context, query string, chunks []string) ([]*LLMResponse, error) {
+	systemPrompt, userPrompt, err := renderPrompt(ctx, prompts.BatchExtraction, batchExtractionVariables(query, chunks))
+	if err != nil {
+		return nil, err
+	}
+	call := c.structuredCall(systemPrompt, userPrompt, batchAnswerTokens*len(chunks))
+	return extractBatchStructured(ctx, c.provider, call, len(chunks))
+}
 
-Respond with a JSON object containing:
-- "answer": The extracted answer (or empty string if no answer)
-- "confidence": Float between 0.0-1.0 indicating your confidence
-- "has_answer": Boolean indicating if chunk contains relevant answer
-- "reasoning": Brief explanation of your decision
+// structuredCall builds a JSON-mode request; a repair resends the conversation
+// with the rejected output and the repair instruction appended
+func (c *OpenAIClient) structuredCall(systemPrompt, userPrompt string, maxTokens int) StructuredCall {
+	return func(ctx context.Context, repair *StructuredRepair) (string, error) {
+		messages := []Message{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: userPrompt},
+		}
+		if repair != nil {
+			messages = append(messages,
+				Message{Role: "assistant", Content: repair.PreviousOutput},
+				Message{Role: "user", Content: repair.Instruction},
+			)
+		}
 
-Guidelines:
-- Be precise and concise in answers
-- Only extract information actually present in the chunk
-- Don't make assumptions or add external knowledge
-- Confidence should reflect how directly the chunk answers the query`
+		request := OpenAIRequest{
+			Model:          c.model,
+			Temperature:    0.1, // Low temperature for consistent extraction
+			MaxTokens:      maxTokens,
+			Messages:       messages,
+			ResponseFormat: &OpenAIResponseFormat{Type: "json_object"},
+		}
 
-	userPrompt := fmt.Sprintf(`Query: %s
+		response, err := c.callOpenAI(ctx, request)
+		if err != nil {
+			return "", fmt.Errorf("OpenAI API call failed: %w", err)
+		}
 
-Text Chunk:
-%s
+		if len(response.Choices) == 0 {
+			return "", fmt.Errorf("no choices returned from OpenAI")
+		}
 
-Extract the answer from this chunk:`, query, chunk)
+		return response.Choices[0].Message.Content, nil
+	}
+}
 
-	// Prepare OpenAI request
+// GenerateText implements TextGenerator for free-form responses
+func (c *OpenAIClient) GenerateText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int) (string, error) {
 	request := OpenAIRequest{
 		Model:       c.model,
-		Temperature: 0.1, // Low temperature for consistent extraction
-		MaxTokens:   500,  // Reasonable limit for answer extraction
+		Temperature: 0.3,
+		MaxTokens:   maxTokens,
 		Messages: []Message{
 			{Role: "system", Content: systemPrompt},
 			{Role: "user", Content: userPrompt},
 		},
 	}
 
-	// Make API call
 	response, err := c.callOpenAI(ctx, request)
 	if err != nil {
-		return nil, fmt.Errorf("OpenAI API call failed: %w", err)
+		return "", fmt.Errorf("OpenAI API call failed: %w", err)
 	}
 
-	// Parse LLM response
 	if len(response.Choices) == 0 {
-		return nil, fmt.Errorf("no choices returned from OpenAI")
+		return "", fmt.Errorf("no choices returned from OpenAI")
 	}
 
-	content := response.Choices[0].Message.Content
+	return response.Choices[0].Message.Content, nil
+}
 
-	// Parse JSON response from LLM
-	var llmResponse LLMResponse
-	if err := json.Unmarshal([]byte(content), &llmResponse); err != nil {
-		// If JSON parsing fails, create a fallback response
-		return &LLMResponse{
-			Answer:     "",
-			Confidence: 0.0,
-			HasAnswer:  false,
-			Reasoning:  fmt.Sprintf("Failed to parse LLM response: %v", err),
-		}, nil
+// StreamText implements TextStreamer, calling onToken with each piece of text
+// as the model produces it and returning the whole text
+func (c *OpenAIClient) StreamText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int, onToken func(string) error) (string, error) {
+	request := OpenAIRequest{
+		Model:       c.model,
+		Temperature: 0.3,
+		MaxTokens:   maxTokens,
+		Messages: []Message{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: userPrompt},
+		},
+		Stream:        true,
+		StreamOptions: &OpenAIStreamOptions{IncludeUsage: true},
 	}
 
-	// Validate confidence score
-	if llmResponse.Confidence < 0.0 {
-		llmResponse.Confidence = 0.0
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("OpenAI API call failed: %w", err)
 	}
-	if llmResponse.Confidence > 1.0 {
-		llmResponse.Confidence = 1.0
+	defer resp.Body.Close()
+
+	// Endpoints that ignore "stream" (e.g. the llmfake server) answer in one piece
+	if !isEventStream(resp) {
+		response, err := c.decodeResponse(ctx, request, resp)
+		if err != nil {
+			return "", err
+		}
+		if len(response.Choices) == 0 {
+			return "", fmt.Errorf("no choices returned from OpenAI")
+		}
+		return response.Choices[0].Message.Content, onToken(response.Choices[0].Message.Content)
 	}
 
-	return &llmResponse, nil
+	var (
+		text  strings.Builder
+		usage OpenAIUsage
+	)
+	err = readServerSentEvents(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta Message `json:"delta"`
+			} `json:"choices"`
+			Usage *OpenAIUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			text.WriteString(chunk.Choices[0].Delta.Content)
+			return onToken(chunk.Choices[0].Delta.Content)
+		}
+		return nil
+	})
+
+	// A broken stream is still billed for the prompt and whatever was produced;
+	// counts the stream never reported are estimated from the text
+	recordUsage(ctx, c.provider, request.Model, usage.PromptTokens, usage.CompletionTokens, systemPrompt+userPrompt, text.String())
+	if err != nil {
+		return text.String(), fmt.Errorf("OpenAI stream failed: %w", err)
+	}
+	return text.String(), nil
 }
 
 // callOpenAI makes the actual HTTP request to OpenAI API
 func (c *OpenAIClient) callOpenAI(ctx context.Context, request OpenAIRequest) (*OpenAIResponse, error) {
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return c.decodeResponse(ctx, request, resp)
+}
+
+// send posts a request and returns the response once its status is OK
+func (c *OpenAIClient) send(ctx context.Context, request OpenAIRequest) (*http.Response, error) {
 	// Marshal request to JSON
 	jsonData, err := json.Marshal(request)
 	if err != nil {
@@ -151,31 +284,51 @@ func (c *OpenAIClient) callOpenAI(ctx context.Context, request OpenAIRequest) (*
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	// Make request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
-	defer resp.Body.Close()
 
+	// Check for HTTP errors
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
+
+// decodeResponse reads a complete (non-streamed) response and records its usage
+func (c *OpenAIClient) decodeResponse(ctx context.Context, request OpenAIRequest, resp *http.Response) (*OpenAIResponse, error) {
 	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Check for HTTP errors
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
 	// Parse response
 	var response OpenAIResponse
 	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
+	// Attribute token usage to the caller's scope (if any)
+	if _, ok := usageScopeFrom(ctx); ok {
+		var prompt, completion strings.Builder
+		for _, message := range request.Messages {
+			prompt.WriteString(message.Content)
+		}
+		for _, choice := range response.Choices {
+			completion.WriteString(choice.Message.Content)
+		}
+		recordUsage(ctx, c.provider, request.Model, response.Usage.PromptTokens, response.Usage.CompletionTokens, prompt.String(), completion.String())
+	}
+
 	return &response, nil
-}
\ No newline at end of file
+}
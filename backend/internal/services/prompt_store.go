@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+)
+
+// PromptTemplateRecord is a prompt version stored in the database. Rows are
+// added to the registry at startup next to the embedded templates; a row with
+// the same name and version as a built-in replaces it.
+type PromptTemplateRecord struct {
+	ID             uuid.UUID `json:"id"`
+	Name           string    `json:"name"`
+	Version        string    `json:"version"`
+	SystemTemplate string    `json:"system_template"`
+	UserTemplate   string    `json:"user_template"`
+	Variables      []string  `json:"variables" gorm:"serializer:json"`
+	Weight         int       `json:"weight"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (PromptTemplateRecord) TableName() string {
+	return "prompt_templates"
+}
+
+// LoadPromptTemplates adds the stored templates to registry and returns how many loaded
+func LoadPromptTemplates(db *gorm.DB, registry *prompts.Registry) (int, error) {
+	var records []PromptTemplateRecord
+	if err := db.Order("created_at ASC").Find(&records).Error; err != nil {
+		return 0, fmt.Errorf("failed to load prompt templates: %w", err)
+	}
+
+	loaded := 0
+	for _, record := range records {
+		t, err := prompts.New(record.Name, record.Version, record.SystemTemplate, record.UserTemplate, record.Variables, record.Weight)
+		if err != nil {
+			fmt.Printf("Skipping stored prompt template: %v\n", err)
+			continue
+		}
+		registry.Add(t)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// selectPrompt returns the template version this request uses for name. The
+// first call picks one, sticky per user when a usage scope is in ctx so A/B
+// variants stay consistent, and records it in the returned context.
+func selectPrompt(ctx context.Context, name string) (context.Context, *prompts.Template, error) {
+	if t, ok := prompts.Selected(ctx, name); ok {
+		return ctx, t, nil
+	}
+
+	key := ""
+	if scope, ok := usageScopeFrom(ctx); ok && scope.UserID != nil {
+		key = scope.UserID.String()
+	}
+
+	t, err := prompts.Default().Select(name, key)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return prompts.WithSelected(ctx, t), t, nil
+}
+
+// renderPrompt renders the template version this request uses for name
+func renderPrompt(ctx context.Context, name string, vars map[string]string) (system, user string, err error) {
+	_, t, err := selectPrompt(ctx, name)
+	if err != nil {
+		return "", "", err
+	}
+	return t.Render(vars)
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+)
+
+// Query rewrite strategies, as reported in RewrittenQuery
+const (
+	RewriteStrategyNone      = "none"      // No history, or the query stands on its own
+	RewriteStrategyLLM       = "llm"       // Rewritten by the LLM
+	RewriteStrategyHeuristic = "heuristic" // LLM unavailable or unusable; references expanded by keyword
+)
+
+// RewrittenQuery is a chat message turned into a standalone search query
+type RewrittenQuery struct {
+	Original string `json:"original"`
+	Query    string `json:"query"`
+	Strategy string `json:"strategy"`
+
+	// Prompt template used for "llm" rewrites, e.g. "query_rewrite@v1"
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+// QueryRewriter turns follow-up chat messages ("when was it published?") into
+// standalone queries for retrieval, so the embedding isn't diluted by the raw
+// previous message
+type QueryRewriter struct {
+	generator   TextGenerator
+	maxMessages int // Most recent history messages shown to the LLM
+}
+
+var (
+	// referringWords mark a message that depends on earlier context
+	referringWords = map[string]bool{
+		"it": true, "its": true, "this": true, "that": true, "these": true, "those": true,
+		"they": true, "them": true, "their": true, "theirs": true, "he": true, "him": true,
+		"his": true, "she": true, "her": true, "hers": true, "there": true, "then": true,
+		"former": true, "latter": true,
+	}
+
+	// rewriteStopWords are left out of the keywords the heuristic borrows
+	rewriteStopWords = map[string]bool{
+		"what": true, "when": true, "where": true, "which": true, "who": true, "whom": true,
+		"why": true, "how": true, "does": true, "did": true, "do": true, "can": true,
+		"could": true, "would": true, "should": true, "will": true, "about": true, "tell": true,
+		"me": true, "my": true, "i": true, "you": true, "your": true, "we": true, "our": true,
+		"for": true, "with": true, "from": true, "by": true, "at": true, "as": true, "be": true,
+		"been": true, "has": true, "have": true, "had": true, "or": true, "but": true, "not": true,
+		"please": true, "any": true, "some": true, "more": true, "also": true,
+	}
+)
+
+// NewQueryRewriter creates a rewriter; with a nil generator only the heuristic is used
+func NewQueryRewriter(generator TextGenerator) *QueryRewriter {
+	return &QueryRewriter{
+		generator:   generator,
+		maxMessages: 6,
+	}
+}
+
+// Rewrite returns a standalone version of query given the conversation so far.
+// history must not include query itself. It never fails: if the LLM call fails
+// or returns something unusable, the heuristic rewrite is used.
+func (r *QueryRewriter) Rewrite(ctx context.Context, query string, history *ConversationHistory) *RewrittenQuery {
+	result := &RewrittenQuery{Original: query, Query: query, Strategy: RewriteStrategyNone}
+	if history == nil || (len(history.Messages) == 0 && history.Summary == "") {
+		return result
+	}
+
+	if r.generator != nil {
+		rewritten, promptID, err := r.rewriteWithLLM(ctx, query, history)
+		if err == nil {
+			result.Query = rewritten
+			result.Strategy = RewriteStrategyLLM
+			result.PromptVersion = promptID
+			return result
+		}
+		fmt.Printf("Query rewrite failed, using heuristic: %v\n", err)
+	}
+
+	if rewritten, ok := rewriteHeuristically(query, history); ok {
+		result.Query = rewritten
+		result.Strategy = RewriteStrategyHeuristic
+	}
+	return result
+}
+
+// rewriteWithLLM asks the LLM for a standalone query, returning the prompt version used
+func (r *QueryRewriter) rewriteWithLLM(ctx context.Context, query string, history *ConversationHistory) (string, string, error) {
+	ctx, prompt, err := selectPrompt(ctx, prompts.QueryRewrite)
+	if err != nil {
+		return "", "", err
+	}
+
+	systemPrompt, userPrompt, err := prompt.Render(map[string]string{
+		"history":  r.formatHistory(history),
+		"question": query,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	text, err := r.generator.GenerateText(ctx, systemPrompt, userPrompt, 200)
+	if err != nil {
+		return "", "", err
+	}
+
+	rewritten := cleanRewrittenQuery(text)
+	if rewritten == "" {
+		return "", "", fmt.Errorf("empty rewrite")
+	}
+	// A rewrite that long is an answer or an explanation, not a query
+	if len(rewritten) > 3*len(query)+300 {
+		return "", "", fmt.Errorf("rewrite too long (%d chars)", len(rewritten))
+	}
+	return rewritten, prompt.ID(), nil
+}
+
+// formatHistory lists the summary of earlier messages and the most recent
+// messages, truncating long answers
+func (r *QueryRewriter) formatHistory(history *ConversationHistory) string {
+	messages := history.Messages
+	if len(messages) > r.maxMessages {
+		messages = messages[len(messages)-r.maxMessages:]
+	}
+
+	var lines []string
+	if history.Summary != "" {
+		lines = append(lines, "Earlier in the conversation: "+history.Summary)
+	}
+	for _, message := range messages {
+		lines = append(lines, fmt.Sprintf("%s: %s", historyRole(message.Role), truncateText(strings.TrimSpace(message.Content), 500)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cleanRewrittenQuery keeps the first non-empty line, without a label or quotes
+func cleanRewrittenQuery(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if label, rest, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "standalone query") {
+			line = strings.TrimSpace(rest)
+		}
+		return strings.TrimSpace(strings.Trim(line, "\"'`“”"))
+	}
+	return ""
+}
+
+// rewriteHeuristically appends the key terms of the previous user message to a
+// query that refers back to it. Words are matched whole, so "with" doesn't
+// count as "it".
+func rewriteHeuristically(query string, history *ConversationHistory) (string, bool) {
+	queryWords := groundingWord.FindAllString(strings.ToLower(query), -1)
+
+	refers := false
+	inQuery := make(map[string]bool, len(queryWords))
+	for _, word := range queryWords {
+		inQuery[word] = true
+		if referringWords[word] {
+			refers = true
+		}
+	}
+	if !refers {
+		return query, false
+	}
+
+	var previous string
+	for i := len(history.Messages) - 1; i >= 0; i-- {
+		if history.Messages[i].Role == "user" {
+			previous = history.Messages[i].Content
+			break
+		}
+	}
+
+	var keywords []string
+	seen := make(map[string]bool)
+	for _, word := range groundingWord.FindAllString(strings.ToLower(previous), -1) {
+		if len(word) < 3 || inQuery[word] || seen[word] || referringWords[word] || groundingFillers[word] || rewriteStopWords[word] {
+			continue
+		}
+		seen[word] = true
+		keywords = append(keywords, word)
+		if len(keywords) == 8 {
+			break
+		}
+	}
+	if len(keywords) == 0 {
+		return query, false
+	}
+
+	return strings.TrimSpace(query) + " " + strings.Join(keywords, " "), true
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Quota limit names, as reported in QuotaExceededError and quota status
+const (
+	QuotaMonthlyTokens          = "monthly_tokens"
+	QuotaMonthlyEmbeddingTokens = "monthly_embedding_tokens"
+	QuotaDailyQARequests        = "daily_qa_requests"
+	QuotaDailyChatRequests      = "daily_chat_requests"
+)
+
+// QuotaLimits caps a user's AI usage; zero means unlimited
+type QuotaLimits struct {
+	MonthlyTokens          int64 `json:"monthly_tokens"`           // LLM input + output tokens
+	MonthlyEmbeddingTokens int64 `json:"monthly_embedding_tokens"` // Tokens sent for embedding
+	DailyQARequests        int64 `json:"daily_qa_requests"`
+	DailyChatRequests      int64 `json:"daily_chat_requests"`
+}
+
+// QuotaPlans maps plan names to limits
+type QuotaPlans map[string]QuotaLimits
+
+// DefaultQuotaPlans are the built-in plans; override or extend with QUOTA_PLANS
+func DefaultQuotaPlans() QuotaPlans {
+	return QuotaPlans{
+		"free": {
+			MonthlyTokens:          2_000_000,
+			MonthlyEmbeddingTokens: 5_000_000,
+			DailyQARequests:        100,
+			DailyChatRequests:      200,
+		},
+		"pro": {
+			MonthlyTokens:          20_000_000,
+			MonthlyEmbeddingTokens: 50_000_000,
+			DailyQARequests:        1000,
+			DailyChatRequests:      2000,
+		},
+		"unlimited": {},
+	}
+}
+
+// ParseQuotaPlans overlays a JSON object such as
+// {"team": {"monthly_tokens": 50000000}} onto the default plans
+func ParseQuotaPlans(raw string) (QuotaPlans, error) {
+	plans := DefaultQuotaPlans()
+	if raw == "" {
+		return plans, nil
+	}
+
+	var overrides QuotaPlans
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return plans, fmt.Errorf("invalid quota plans: %w", err)
+	}
+	for name, limits := range overrides {
+		plans[name] = limits
+	}
+	return plans, nil
+}
+
+// UserQuota assigns a user a plan, optionally overriding individual limits
+type UserQuota struct {
+	UserID                 uuid.UUID `json:"user_id" gorm:"primaryKey"`
+	Plan                   string    `json:"plan"`
+	MonthlyTokens          *int64    `json:"monthly_tokens,omitempty"`
+	MonthlyEmbeddingTokens *int64    `json:"monthly_embedding_tokens,omitempty"`
+	DailyQARequests        *int64    `json:"daily_qa_requests,omitempty"`
+	DailyChatRequests      *int64    `json:"daily_chat_requests,omitempty"`
+	UpdatedAt              time.Time `json:"updated_at"`
+}
+
+func (UserQuota) TableName() string {
+	return "user_quotas"
+}
+
+// QuotaUsage is one limit's current consumption
+type QuotaUsage struct {
+	Limit   string    `json:"limit"`
+	Used    int64     `json:"used"`
+	Max     int64     `json:"max"` // 0 = unlimited
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// QuotaStatus is a user's plan and consumption of every limit
+type QuotaStatus struct {
+	Plan   string       `json:"plan"`
+	Limits []QuotaUsage `json:"limits"`
+}
+
+// ErrQuotaExceeded is matched by every QuotaExceededError
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrUnknownQuotaPlan is returned when assigning a plan that isn't configured
+var ErrUnknownQuotaPlan = errors.New("unknown quota plan")
+
+// QuotaExceededError says which limit was hit and when it resets
+type QuotaExceededError struct {
+	QuotaUsage
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded (%d of %d used), resets at %s",
+		e.Limit, e.Used, e.Max, e.ResetAt.Format(time.RFC3339))
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// QuotaService enforces per-user limits on AI features. Token usage comes from
+// llm_usage and request counts from search_queries, so both must be recorded
+// for limits to bite.
+//
+// Limits are soft: Check counts what has been recorded, and usage is recorded
+// as requests finish, so requests a user has in flight when they reach a limit
+// all go through. A user can overshoot by their concurrent requests' usage.
+// Token costs aren't known until the provider answers, so they can't be
+// reserved up front.
+type QuotaService struct {
+	db          *gorm.DB
+	plans       QuotaPlans
+	defaultPlan string
+}
+
+func NewQuotaService(db *gorm.DB, plans QuotaPlans, defaultPlan string) *QuotaService {
+	if plans == nil {
+		plans = DefaultQuotaPlans()
+	}
+	if _, ok := plans[defaultPlan]; !ok {
+		defaultPlan = "free"
+	}
+	return &QuotaService{
+		db:          db,
+		plans:       plans,
+		defaultPlan: defaultPlan,
+	}
+}
+
+// Limits returns the user's plan and effective limits
+func (s *QuotaService) Limits(userID uuid.UUID) (string, QuotaLimits, error) {
+	var quota UserQuota
+	err := s.db.Where("user_id = ?", userID).First(&quota).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.defaultPlan, s.plans[s.defaultPlan], nil
+	}
+	if err != nil {
+		return "", QuotaLimits{}, err
+	}
+
+	plan := quota.Plan
+	limits, ok := s.plans[plan]
+	if !ok {
+		// The plan was removed from config since it was assigned
+		plan = s.defaultPlan
+		limits = s.plans[plan]
+	}
+
+	if quota.MonthlyTokens != nil {
+		limits.MonthlyTokens = *quota.MonthlyTokens
+	}
+	if quota.MonthlyEmbeddingTokens != nil {
+		limits.MonthlyEmbeddingTokens = *quota.MonthlyEmbeddingTokens
+	}
+	if quota.DailyQARequests != nil {
+		limits.DailyQARequests = *quota.DailyQARequests
+	}
+	if quota.DailyChatRequests != nil {
+		limits.DailyChatRequests = *quota.DailyChatRequests
+	}
+	return plan, limits, nil
+}
+
+// Check returns a QuotaExceededError if the user may not use feature right now.
+// Call it before QASearch, chat or ingestion. It doesn't reserve anything; see
+// QuotaService for how far concurrent requests can overshoot.
+func (s *QuotaService) Check(userID uuid.UUID, feature string) error {
+	_, limits, err := s.Limits(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load quota: %w", err)
+	}
+
+	var names []string
+	switch feature {
+	case UsageFeatureQA:
+		names = []string{QuotaDailyQARequests, QuotaMonthlyTokens}
+	case UsageFeatureChat:
+		names = []string{QuotaDailyChatRequests, QuotaMonthlyTokens}
+	case UsageFeatureIngestion:
+		names = []string{QuotaMonthlyEmbeddingTokens}
+	}
+
+	for _, name := range names {
+		usage, err := s.usage(userID, name, limits)
+		if err != nil {
+			return fmt.Errorf("failed to count %s: %w", name, err)
+		}
+		if usage.Max > 0 && usage.Used >= usage.Max {
+			return &QuotaExceededError{QuotaUsage: usage}
+		}
+	}
+	return nil
+}
+
+// Status reports consumption of every limit
+func (s *QuotaService) Status(userID uuid.UUID) (*QuotaStatus, error) {
+	plan, limits, err := s.Limits(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &QuotaStatus{Plan: plan}
+	for _, name := range []string{QuotaMonthlyTokens, QuotaMonthlyEmbeddingTokens, QuotaDailyQARequests, QuotaDailyChatRequests} {
+		usage, err := s.usage(userID, name, limits)
+		if err != nil {
+			return nil, err
+		}
+		status.Limits = append(status.Limits, usage)
+	}
+	return status, nil
+}
+
+// SetUserQuota assigns a plan and limit overrides; nil overrides use the plan's limits
+func (s *QuotaService) SetUserQuota(quota UserQuota) (*UserQuota, error) {
+	if quota.Plan == "" {
+		quota.Plan = s.defaultPlan
+	}
+	if _, ok := s.plans[quota.Plan]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQuotaPlan, quota.Plan)
+	}
+	quota.UpdatedAt = time.Now()
+
+	if err := s.db.Save(&quota).Error; err != nil {
+		return nil, fmt.Errorf("failed to save quota: %w", err)
+	}
+	return &quota, nil
+}
+
+// usage counts one limit within its current window. Windows are calendar
+// months and days in UTC.
+func (s *QuotaService) usage(userID uuid.UUID, name string, limits QuotaLimits) (QuotaUsage, error) {
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	usage := QuotaUsage{Limit: name}
+	var err error
+
+	switch name {
+	case QuotaMonthlyTokens:
+		usage.Max = limits.MonthlyTokens
+		usage.ResetAt = monthStart.AddDate(0, 1, 0)
+		err = s.db.Model(&LLMUsage{}).
+			Select("COALESCE(SUM(input_tokens + output_tokens), 0)").
+			Where("user_id = ? AND kind = ? AND created_at >= ?", userID, UsageKindCompletion, monthStart).
+			Scan(&usage.Used).Error
+	case QuotaMonthlyEmbeddingTokens:
+		usage.Max = limits.MonthlyEmbeddingTokens
+		usage.ResetAt = monthStart.AddDate(0, 1, 0)
+		err = s.db.Model(&LLMUsage{}).
+			Select("COALESCE(SUM(input_tokens), 0)").
+			Where("user_id = ? AND kind = ? AND created_at >= ?", userID, UsageKindEmbedding, monthStart).
+			Scan(&usage.Used).Error
+	case QuotaDailyQARequests, QuotaDailyChatRequests:
+		feature := UsageFeatureQA
+		usage.Max = limits.DailyQARequests
+		if name == QuotaDailyChatRequests {
+			feature = UsageFeatureChat
+			usage.Max = limits.DailyChatRequests
+		}
+		usage.ResetAt = dayStart.AddDate(0, 0, 1)
+		err = s.db.Model(&SearchQuery{}).
+			Where("user_id = ? AND feature = ? AND created_at >= ?", userID, feature, dayStart).
+			Count(&usage.Used).Error
+	default:
+		err = fmt.Errorf("unknown quota %s", name)
+	}
+
+	return usage, err
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tanaymehhta/self/backend/internal/models"
+)
+
+// SavedSearchService stores saved queries and matches newly ingested content against them
+type SavedSearchService struct {
+	db               *gorm.DB
+	embeddingService *EmbeddingService
+	hub              *InsightHub
+}
+
+// SavedSearch is a query the user wants to be notified about
+type SavedSearch struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        uuid.UUID  `json:"user_id"`
+	Name          string     `json:"name"`
+	Query         string     `json:"query"`
+	ContentTypes  []string   `json:"content_types" gorm:"serializer:json"`
+	Threshold     float64    `json:"threshold"`
+	IsActive      bool       `json:"is_active"`
+	LastMatchedAt *time.Time `json:"last_matched_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Insight is a proactive notification shown to the user
+type Insight struct {
+	ID          uuid.UUID    `json:"id"`
+	UserID      uuid.UUID    `json:"user_id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Data        models.JSONB `json:"data"`
+	Priority    int          `json:"priority"`
+	Status      string       `json:"status"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// IngestedChunk is a freshly stored chunk together with its vector
+type IngestedChunk struct {
+	ID     uuid.UUID
+	Text   string
+	Vector []float32
+}
+
+// SavedSearchMatch is one chunk that scored above a saved search's threshold
+type SavedSearchMatch struct {
+	ChunkID string  `json:"chunk_id"`
+	Score   float64 `json:"score"`
+	Excerpt string  `json:"excerpt"`
+}
+
+// ErrSavedSearchNotFound is returned for unknown or foreign saved searches
+var ErrSavedSearchNotFound = errors.New("saved search not found")
+
+const defaultSavedSearchThreshold = 0.8
+
+func NewSavedSearchService(db *gorm.DB, hub *InsightHub) *SavedSearchService {
+	return &SavedSearchService{
+		db:               db,
+		embeddingService: NewEmbeddingService(),
+		hub:              hub,
+	}
+}
+
+// SetUsageScope attributes saved-search query embeddings to a user
+func (s *SavedSearchService) SetUsageScope(scope UsageScope) {
+	s.embeddingService.SetUsageScope(scope)
+}
+
+// Create saves a new search for the user
+func (s *SavedSearchService) Create(userID uuid.UUID, name, query string, contentTypes []string, threshold float64) (*SavedSearch, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("query cannot be empty")
+	}
+	if threshold == 0 {
+		threshold = defaultSavedSearchThreshold
+	}
+	if threshold < 0 || threshold > 1 {
+		return nil, fmt.Errorf("threshold must be between 0 and 1")
+	}
+	if name == "" {
+		name = query
+	}
+	if contentTypes == nil {
+		contentTypes = []string{}
+	}
+
+	savedSearch := &SavedSearch{
+		ID:           uuid.New(),
+		UserID:       userID,
+		Name:         name,
+		Query:        query,
+		ContentTypes: contentTypes,
+		Threshold:    threshold,
+		IsActive:     true,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+
+	if err := s.db.Create(savedSearch).Error; err != nil {
+		return nil, fmt.Errorf("failed to save search: %w", err)
+	}
+
+	return savedSearch, nil
+}
+
+// List returns the user's saved searches, newest first
+func (s *SavedSearchService) List(userID uuid.UUID) ([]SavedSearch, error) {
+	savedSearches := []SavedSearch{}
+	err := s.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&savedSearches).Error
+
+	return savedSearches, err
+}
+
+// Update changes a saved search. Only non-nil fields are applied.
+func (s *SavedSearchService) Update(userID, id uuid.UUID, name *string, threshold *float64, isActive *bool) (*SavedSearch, error) {
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if name != nil {
+		updates["name"] = *name
+	}
+	if threshold != nil {
+		if *threshold < 0 || *threshold > 1 {
+			return nil, fmt.Errorf("threshold must be between 0 and 1")
+		}
+		updates["threshold"] = *threshold
+	}
+	if isActive != nil {
+		updates["is_active"] = *isActive
+	}
+
+	result := s.db.Model(&SavedSearch{}).Where("id = ? AND user_id = ?", id, userID).Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update saved search: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrSavedSearchNotFound
+	}
+
+	var savedSearch SavedSearch
+	if err := s.db.Where("id = ?", id).First(&savedSearch).Error; err != nil {
+		return nil, err
+	}
+	return &savedSearch, nil
+}
+
+// Delete removes a saved search
+func (s *SavedSearchService) Delete(userID, id uuid.UUID) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&SavedSearch{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete saved search: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+// EvaluateNewContent checks freshly ingested chunks against the owner's active
+// saved searches and creates one insight per saved search that matched
+func (s *SavedSearchService) EvaluateNewContent(item *ContentItem, chunks []IngestedChunk) ([]*Insight, error) {
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	var savedSearches []SavedSearch
+	err := s.db.Where("user_id = ? AND is_active = ?", item.UserID, true).Find(&savedSearches).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load saved searches: %w", err)
+	}
+
+	var insights []*Insight
+	for _, savedSearch := range savedSearches {
+		if !matchesContentType(savedSearch.ContentTypes, item.ContentType) {
+			continue
+		}
+
+		matches, err := s.matchChunks(savedSearch, chunks)
+		if err != nil {
+			return insights, err
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		insight, err := s.createMatchInsight(savedSearch, item, matches)
+		if err != nil {
+			return insights, err
+		}
+		insights = append(insights, insight)
+	}
+
+	return insights, nil
+}
+
+// matchChunks scores chunks against a saved query, best first. A chunk's score is
+// the higher of its vector similarity and the share of query terms it mentions,
+// so "anything mentioning Acme renewal" matches literal mentions too.
+func (s *SavedSearchService) matchChunks(savedSearch SavedSearch, chunks []IngestedChunk) ([]SavedSearchMatch, error) {
+	queryEmbedding, err := s.embeddingService.CreateEmbedding(savedSearch.Query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed saved search %s: %w", savedSearch.ID, err)
+	}
+
+	terms := queryTerms(savedSearch.Query)
+
+	var matches []SavedSearchMatch
+	for _, chunk := range chunks {
+		score := math.Max(
+			cosineSimilarity(queryEmbedding.Vector, chunk.Vector),
+			termCoverage(terms, chunk.Text),
+		)
+		if score < savedSearch.Threshold {
+			continue
+		}
+
+		// Cut on a character boundary, not mid-way through a multi-byte one
+		excerpt := chunk.Text
+		if runes := []rune(excerpt); len(runes) > 200 {
+			excerpt = string(runes[:200]) + "..."
+		}
+
+		matches = append(matches, SavedSearchMatch{
+			ChunkID: chunk.ID.String(),
+			Score:   score,
+			Excerpt: excerpt,
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	return matches, nil
+}
+
+// createMatchInsight stores the insight, stamps the saved search and pushes it to listeners
+func (s *SavedSearchService) createMatchInsight(savedSearch SavedSearch, item *ContentItem, matches []SavedSearchMatch) (*Insight, error) {
+	now := time.Now()
+	topMatches := matches
+	if len(topMatches) > 3 {
+		topMatches = topMatches[:3]
+	}
+
+	insight := &Insight{
+		ID:          uuid.New(),
+		UserID:      item.UserID,
+		Type:        "saved_search_match",
+		Title:       fmt.Sprintf("New match for \"%s\"", savedSearch.Name),
+		Description: fmt.Sprintf("%s has %d passage(s) matching your saved search", item.Title, len(matches)),
+		Data: models.JSONB{
+			"saved_search_id": savedSearch.ID,
+			"query":           savedSearch.Query,
+			"content_item_id": item.ID,
+			"content_title":   item.Title,
+			"content_type":    item.ContentType,
+			"match_count":     len(matches),
+			"matches":         topMatches,
+		},
+		Priority:  50 + int(matches[0].Score*50),
+		Status:    "new",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := s.db.Create(insight).Error; err != nil {
+		return nil, fmt.Errorf("failed to create insight: %w", err)
+	}
+
+	s.db.Model(&SavedSearch{}).Where("id = ?", savedSearch.ID).Update("last_matched_at", now)
+
+	if s.hub != nil {
+		s.hub.Publish(insight)
+	}
+
+	return insight, nil
+}
+
+func matchesContentType(contentTypes []string, contentType string) bool {
+	if len(contentTypes) == 0 {
+		return true
+	}
+	for _, t := range contentTypes {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// queryTerms lowercases the query and drops short filler words
+func queryTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		word = strings.Trim(word, ".,!?;:()[]{}\"'")
+		if len(word) > 2 && !savedSearchStopWords[word] {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+var savedSearchStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "about": true,
+	"anything": true, "mentioning": true, "new": true, "docs": true,
+}
+
+// termCoverage is the fraction of terms that appear in text
+func termCoverage(terms []string, text string) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	lowerText := strings.ToLower(text)
+	found := 0
+	for _, term := range terms {
+		if strings.Contains(lowerText, term) {
+			found++
+		}
+	}
+	return float64(found) / float64(len(terms))
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
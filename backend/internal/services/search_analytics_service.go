@@ -0,0 +1,271 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SearchAnalyticsService records what users search for and how retrieval performed
+type SearchAnalyticsService struct {
+	db *gorm.DB
+}
+
+// SearchQuery is one recorded Search, QASearch or chat retrieval
+type SearchQuery struct {
+	ID           uuid.UUID `json:"id"`
+	UserID       uuid.UUID `json:"user_id"`
+	Query        string    `json:"query"`
+	Feature      string    `json:"feature"` // "search", "qa" or "chat"
+	Strategy     string    `json:"strategy"`
+	ResultCount  int       `json:"result_count"`
+	TopResultIDs []string  `json:"top_result_ids" gorm:"serializer:json"`
+	LatencyMs    int64     `json:"latency_ms"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Model and prompt template answers were extracted with, for QA and chat
+	Model         string `json:"model,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+// SearchInteraction is a click or feedback on one result of a recorded query
+type SearchInteraction struct {
+	ID            uuid.UUID `json:"id"`
+	SearchQueryID uuid.UUID `json:"search_query_id"`
+	UserID        uuid.UUID `json:"user_id"`
+	ResultID      string    `json:"result_id"`
+	Position      *int      `json:"position,omitempty"`
+	Action        string    `json:"action"` // "click", "helpful" or "not_helpful"
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// QueryStats aggregates repeated queries within an analytics window
+type QueryStats struct {
+	Query          string    `json:"query"`
+	Count          int       `json:"count"`
+	AvgResults     float64   `json:"avg_results"`
+	Clicks         int       `json:"clicks"`
+	LastSearchedAt time.Time `json:"last_searched_at"`
+}
+
+// StrategyLatency summarises retrieval latency for one strategy
+type StrategyLatency struct {
+	Strategy     string  `json:"strategy"`
+	Count        int     `json:"count"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	P95LatencyMs float64 `json:"p95_latency_ms"`
+	ZeroResults  int     `json:"zero_results"`
+}
+
+// SearchAnalyticsReport is the payload of the analytics endpoint
+type SearchAnalyticsReport struct {
+	Since             time.Time         `json:"since"`
+	TotalQueries      int               `json:"total_queries"`
+	TopQueries        []QueryStats      `json:"top_queries"`
+	ZeroResultQueries []QueryStats      `json:"zero_result_queries"`
+	Latency           []StrategyLatency `json:"latency"`
+}
+
+// Valid interaction actions
+var searchInteractionActions = map[string]bool{
+	"click":       true,
+	"helpful":     true,
+	"not_helpful": true,
+}
+
+// ErrSearchQueryNotFound is returned when feedback references an unknown or foreign query
+var ErrSearchQueryNotFound = errors.New("search query not found")
+
+func NewSearchAnalyticsService(db *gorm.DB) *SearchAnalyticsService {
+	return &SearchAnalyticsService{db: db}
+}
+
+// RecordQuery stores a completed retrieval. started is when the retrieval began.
+func (s *SearchAnalyticsService) RecordQuery(userID uuid.UUID, feature, query, strategy string, resultIDs []string, started time.Time) (*SearchQuery, error) {
+	record := newSearchQuery(userID, feature, query, strategy, resultIDs, started)
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to record search query: %w", err)
+	}
+
+	return record, nil
+}
+
+func newSearchQuery(userID uuid.UUID, feature, query, strategy string, resultIDs []string, started time.Time) *SearchQuery {
+	// Keep the top of the ranking only, that's what users actually see
+	topIDs := resultIDs
+	if len(topIDs) > 10 {
+		topIDs = topIDs[:10]
+	}
+	if topIDs == nil {
+		topIDs = []string{}
+	}
+
+	return &SearchQuery{
+		ID:           uuid.New(),
+		UserID:       userID,
+		Query:        query,
+		Feature:      feature,
+		Strategy:     strategy,
+		ResultCount:  len(resultIDs),
+		TopResultIDs: topIDs,
+		LatencyMs:    time.Since(started).Milliseconds(),
+		CreatedAt:    time.Now(),
+	}
+}
+
+// RecordSearch records a chunk search
+func (s *SearchAnalyticsService) RecordSearch(userID uuid.UUID, feature, query string, results *SearchResults, started time.Time) (*SearchQuery, error) {
+	ids := make([]string, 0, len(results.Results))
+	for _, result := range results.Results {
+		ids = append(ids, result.ID)
+	}
+	return s.RecordQuery(userID, feature, query, results.Strategy, ids, started)
+}
+
+// RecordQASearch records an answer search, using the chunks the answers came
+// from, with the model and prompt that extracted them
+func (s *SearchAnalyticsService) RecordQASearch(userID uuid.UUID, feature, query string, results *QASearchResults, started time.Time) (*SearchQuery, error) {
+	ids := make([]string, 0, len(results.Answers))
+	for _, answer := range results.Answers {
+		ids = append(ids, answer.ChunkID)
+	}
+
+	record := newSearchQuery(userID, feature, query, results.Strategy, ids, started)
+	record.Model = results.Model
+	record.PromptVersion = answerPromptVersions(results.Answers, "")["extraction"]
+
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to record search query: %w", err)
+	}
+
+	return record, nil
+}
+
+// RecordInteraction stores a click or feedback against a query owned by the user
+func (s *SearchAnalyticsService) RecordInteraction(userID, searchQueryID uuid.UUID, resultID, action string, position *int) (*SearchInteraction, error) {
+	if !searchInteractionActions[action] {
+		return nil, fmt.Errorf("invalid action %q", action)
+	}
+
+	var count int64
+	err := s.db.Model(&SearchQuery{}).
+		Where("id = ? AND user_id = ?", searchQueryID, userID).
+		Count(&count).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up search query: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrSearchQueryNotFound
+	}
+
+	interaction := &SearchInteraction{
+		ID:            uuid.New(),
+		SearchQueryID: searchQueryID,
+		UserID:        userID,
+		ResultID:      resultID,
+		Position:      position,
+		Action:        action,
+		CreatedAt:     time.Now(),
+	}
+
+	if err := s.db.Create(interaction).Error; err != nil {
+		return nil, fmt.Errorf("failed to record interaction: %w", err)
+	}
+
+	return interaction, nil
+}
+
+// reportLimit bounds the queries listed per section of a report: default 20,
+// at most 100
+func reportLimit(limit int) int {
+	if limit <= 0 {
+		return 20
+	}
+	if limit > 100 {
+		return 100
+	}
+	return limit
+}
+
+// Report builds the analytics summary for a user since the given time
+func (s *SearchAnalyticsService) Report(userID uuid.UUID, since time.Time, limit int) (*SearchAnalyticsReport, error) {
+	report := &SearchAnalyticsReport{Since: since}
+	limit = reportLimit(limit)
+
+	var total int64
+	err := s.db.Model(&SearchQuery{}).
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Count(&total).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count queries: %w", err)
+	}
+	report.TotalQueries = int(total)
+
+	report.TopQueries, err = s.queryStats(userID, since, false, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load top queries: %w", err)
+	}
+
+	report.ZeroResultQueries, err = s.queryStats(userID, since, true, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load zero-result queries: %w", err)
+	}
+
+	report.Latency, err = s.latencyByStrategy(userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load latency stats: %w", err)
+	}
+
+	return report, nil
+}
+
+// queryStats groups queries case-insensitively, most frequent first
+func (s *SearchAnalyticsService) queryStats(userID uuid.UUID, since time.Time, zeroResultsOnly bool, limit int) ([]QueryStats, error) {
+	zeroFilter := ""
+	if zeroResultsOnly {
+		zeroFilter = "AND sq.result_count = 0"
+	}
+
+	stats := []QueryStats{}
+	err := s.db.Raw(fmt.Sprintf(`
+		SELECT LOWER(TRIM(sq.query)) AS query,
+		       COUNT(*) AS count,
+		       AVG(sq.result_count) AS avg_results,
+		       COALESCE(SUM(si.clicks), 0) AS clicks,
+		       MAX(sq.created_at) AS last_searched_at
+		FROM search_queries sq
+		LEFT JOIN (
+			SELECT search_query_id, COUNT(*) AS clicks
+			FROM search_interactions
+			WHERE action = 'click'
+			GROUP BY search_query_id
+		) si ON si.search_query_id = sq.id
+		WHERE sq.user_id = ? AND sq.created_at >= ? %s
+		GROUP BY LOWER(TRIM(sq.query))
+		ORDER BY count DESC, last_searched_at DESC
+		LIMIT ?
+	`, zeroFilter), userID, since, limit).Scan(&stats).Error
+
+	return stats, err
+}
+
+// latencyByStrategy reports mean and p95 latency for each retrieval strategy
+func (s *SearchAnalyticsService) latencyByStrategy(userID uuid.UUID, since time.Time) ([]StrategyLatency, error) {
+	latency := []StrategyLatency{}
+	err := s.db.Raw(`
+		SELECT strategy,
+		       COUNT(*) AS count,
+		       AVG(latency_ms) AS avg_latency_ms,
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY latency_ms) AS p95_latency_ms,
+		       COUNT(*) FILTER (WHERE result_count = 0) AS zero_results
+		FROM search_queries
+		WHERE user_id = ? AND created_at >= ?
+		GROUP BY strategy
+		ORDER BY strategy
+	`, userID, since).Scan(&latency).Error
+
+	return latency, err
+}
@@ -4,21 +4,30 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+
+	"github.com/tanaymehhta/self/backend/internal/cache"
 )
 
 type SearchService struct {
 	db                     *gorm.DB
 	embeddingService       *EmbeddingService
 	answerExtractionService *AnswerExtractionService
+	ranker                 *AnswerRanker
+	contentItemIDs         []string // When set, retrieval only searches these documents
+	feedback               *FeedbackService // When set, answers are demoted by the user's feedback
+	feedbackUserID         uuid.UUID
 }
 
 type SearchResult struct {
 	ID           string                 `json:"id"`
+	ContentItemID string                `json:"content_item_id"`
 	ChunkText    string                 `json:"text"`
 	ContentTitle string                 `json:"content_title"`
 	ContentType  string                 `json:"content_type"`
@@ -38,6 +47,13 @@ type QASearchResults struct {
 	Answers  []*AnswerResult `json:"answers"`
 	Strategy string          `json:"strategy"`
 	Total    int            `json:"total"`
+
+	// Partial is set when extraction hit its deadline or stopped early
+	Partial    bool             `json:"partial"`
+	Extraction ExtractionReport `json:"extraction"`
+
+	// Model answers were extracted with (the primary one when fallbacks are configured)
+	Model string `json:"model,omitempty"`
 }
 
 func NewSearchService(db *gorm.DB, answerExtractionService *AnswerExtractionService) *SearchService {
@@ -45,6 +61,76 @@ func NewSearchService(db *gorm.DB, answerExtractionService *AnswerExtractionServ
 		db:                     db,
 		embeddingService:       NewEmbeddingService(),
 		answerExtractionService: answerExtractionService,
+		ranker:                 NewAnswerRanker(DefaultRankingWeights()),
+	}
+}
+
+// SearchStrategies lists the retrieval strategies accepted by SearchWithStrategy
+var SearchStrategies = []string{"hybrid", "vector", "fulltext", "simple"}
+
+// SetEmbeddingService swaps the embedder used for query vectors, e.g. for
+// deterministic offline evaluation
+func (s *SearchService) SetEmbeddingService(embeddingService *EmbeddingService) {
+	s.embeddingService = embeddingService
+}
+
+// SetQueryEmbeddingCache shares a query-embedding cache across search requests
+func (s *SearchService) SetQueryEmbeddingCache(queryCache *cache.Cache) {
+	s.embeddingService.SetQueryCache(queryCache)
+}
+
+// SetRankingWeights changes how QA answers are ranked
+func (s *SearchService) SetRankingWeights(weights RankingWeights) {
+	s.ranker = NewAnswerRanker(weights)
+}
+
+// SetContentScope restricts vector and full-text retrieval to the given content
+// items; an empty list searches everything
+func (s *SearchService) SetContentScope(contentItemIDs []string) {
+	s.contentItemIDs = contentItemIDs
+}
+
+// SetFeedback demotes answers from chunks the user has repeatedly marked wrong
+// for similar queries
+func (s *SearchService) SetFeedback(feedback *FeedbackService, userID uuid.UUID) {
+	s.feedback = feedback
+	s.feedbackUserID = userID
+}
+
+// scopeFilter returns the SQL condition (with leading AND) and argument for the content scope
+func (s *SearchService) scopeFilter() (string, []interface{}) {
+	if len(s.contentItemIDs) == 0 {
+		return "", nil
+	}
+	return "AND ci.id IN ?", []interface{}{s.contentItemIDs}
+}
+
+// SetUsageScope attributes query-embedding usage to a user and feature
+func (s *SearchService) SetUsageScope(scope UsageScope) {
+	s.embeddingService.SetUsageScope(scope)
+}
+
+// SearchWithStrategy runs a single named retrieval strategy
+func (s *SearchService) SearchWithStrategy(strategy, query string, limit int) (*SearchResults, error) {
+	switch strategy {
+	case "hybrid":
+		return s.Search(query, limit)
+	case "simple":
+		return s.SimpleSearch(query, limit)
+	case "vector":
+		results, err := s.vectorSearch(query, limit)
+		if err != nil {
+			return nil, fmt.Errorf("vector search failed: %w", err)
+		}
+		return &SearchResults{Results: results, Strategy: strategy, Total: len(results)}, nil
+	case "fulltext":
+		results, err := s.fullTextSearch(query, limit)
+		if err != nil {
+			return nil, fmt.Errorf("fulltext search failed: %w", err)
+		}
+		return &SearchResults{Results: results, Strategy: strategy, Total: len(results)}, nil
+	default:
+		return nil, fmt.Errorf("unknown search strategy %q", strategy)
 	}
 }
 
@@ -73,7 +159,7 @@ func (s *SearchService) Search(query string, limit int) (*SearchResults, error)
 
 func (s *SearchService) vectorSearch(query string, limit int) ([]SearchResult, error) {
 	// Create embedding for query
-	embedding, err := s.embeddingService.CreateEmbedding(query)
+	embedding, err := s.embeddingService.EmbedQuery(context.Background(), query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create query embedding: %w", err)
 	}
@@ -91,18 +177,20 @@ func (s *SearchService) vectorSearch(query string, limit int) ([]SearchResult, e
 		return strs
 	}(), ","))
 
+	scope, scopeArgs := s.scopeFilter()
 	sqlQuery := fmt.Sprintf(`
-		SELECT c.chunk_text, c.chunk_span, ci.title, ci.content_type, c.id,
+		SELECT c.chunk_text, c.chunk_span, ci.title, ci.content_type, c.id, ci.id,
 		       e.embedding <=> '%s'::vector AS distance
 		FROM embeddings e
 		JOIN chunks c ON e.chunk_id = c.id
 		JOIN content_items ci ON c.content_item_id = ci.id
-		WHERE e.embedding_model = ?
+		WHERE e.embedding_model = ? %s
 		ORDER BY e.embedding <=> '%s'::vector
 		LIMIT ?
-	`, vectorStr, vectorStr)
+	`, vectorStr, scope, vectorStr)
 
-	rows, err = s.db.Raw(sqlQuery, embedding.EmbeddingModel, limit).Rows()
+	args := append([]interface{}{embedding.EmbeddingModel}, scopeArgs...)
+	rows, err = s.db.Raw(sqlQuery, append(args, limit)...).Rows()
 
 	if err != nil {
 		return nil, fmt.Errorf("vector search query failed: %w", err)
@@ -115,7 +203,7 @@ func (s *SearchService) vectorSearch(query string, limit int) ([]SearchResult, e
 		var chunkSpanJSON []byte
 
 		err := rows.Scan(&result.ChunkText, &chunkSpanJSON, &result.ContentTitle,
-						&result.ContentType, &result.ID, &distance)
+						&result.ContentType, &result.ID, &result.ContentItemID, &distance)
 		if err != nil {
 			continue
 		}
@@ -143,15 +231,17 @@ func (s *SearchService) fullTextSearch(query string, limit int) ([]SearchResult,
 	var results []SearchResult
 
 	// PostgreSQL full-text search
-	rows, err := s.db.Raw(`
-		SELECT c.chunk_text, c.chunk_span, ci.title, ci.content_type, c.id,
+	scope, scopeArgs := s.scopeFilter()
+	args := append([]interface{}{query, query}, scopeArgs...)
+	rows, err := s.db.Raw(fmt.Sprintf(`
+		SELECT c.chunk_text, c.chunk_span, ci.title, ci.content_type, c.id, ci.id,
 		       ts_rank(to_tsvector('english', c.chunk_text), plainto_tsquery('english', ?)) as rank
 		FROM chunks c
 		JOIN content_items ci ON c.content_item_id = ci.id
-		WHERE to_tsvector('english', c.chunk_text) @@ plainto_tsquery('english', ?)
+		WHERE to_tsvector('english', c.chunk_text) @@ plainto_tsquery('english', ?) %s
 		ORDER BY rank DESC
 		LIMIT ?
-	`, query, query, limit).Rows()
+	`, scope), append(args, limit)...).Rows()
 
 	if err != nil {
 		return nil, fmt.Errorf("fulltext search query failed: %w", err)
@@ -164,7 +254,7 @@ func (s *SearchService) fullTextSearch(query string, limit int) ([]SearchResult,
 		var chunkSpanJSON []byte
 
 		err := rows.Scan(&result.ChunkText, &chunkSpanJSON, &result.ContentTitle,
-						&result.ContentType, &result.ID, &rank)
+						&result.ContentType, &result.ID, &result.ContentItemID, &rank)
 		if err != nil {
 			continue
 		}
@@ -326,6 +416,10 @@ func (s *SearchService) calculateContextRelevance(chunkText string) float64 {
 }
 
 func (s *SearchService) calculateSourceAuthority(contentType string) float64 {
+	return sourceAuthority(contentType)
+}
+
+func sourceAuthority(contentType string) float64 {
 	// Weight sources by their typical authority/reliability
 	authorityWeights := map[string]float64{
 		"document":    1.0,   // Documents typically authoritative
@@ -413,6 +507,10 @@ func (s *SearchService) QASearch(ctx context.Context, query string, limit int) (
 	// Stage 1: Retrieve candidate chunks (more than final limit)
 	candidateLimit := limit * 3 // Get 3x candidates for better answer extraction
 
+	if scope, ok := usageScopeFrom(ctx); ok {
+		s.embeddingService.SetUsageScope(scope)
+	}
+
 	// 1. Vector similarity search
 	vectorResults, err := s.vectorSearch(query, candidateLimit)
 	if err != nil {
@@ -428,14 +526,20 @@ func (s *SearchService) QASearch(ctx context.Context, query string, limit int) (
 	// 3. Combine and get candidate chunks
 	candidateChunks := s.prepareCandidateChunks(vectorResults, textResults, candidateLimit)
 
-	// Stage 2: Extract answers from candidate chunks
-	answers, err := s.answerExtractionService.ExtractAnswersFromChunks(ctx, query, candidateChunks)
-	if err != nil {
-		return nil, fmt.Errorf("answer extraction failed: %w", err)
+	// Stage 2: Extract answers from candidate chunks, stopping once `limit`
+	// confident answers are in and returning partial results on timeout
+	answers, report := s.answerExtractionService.ExtractAnswersWithReport(ctx, query, candidateChunks, limit)
+
+	// Demote chunks the user has repeatedly marked wrong for similar queries (best-effort)
+	if s.feedback != nil {
+		if err := s.feedback.ApplyPenalties(s.feedbackUserID, query, answers); err != nil {
+			fmt.Printf("Failed to apply feedback penalties: %v\n", err)
+		}
 	}
 
-	// Rank answers by confidence
-	rankedAnswers := RankAnswersByConfidence(answers)
+	// Rank answers by retrieval, confidence, grounding and authority, folding
+	// answers repeated across overlapping chunks into one
+	rankedAnswers := s.ranker.Rank(answers)
 
 	// Limit final results
 	if len(rankedAnswers) > limit {
@@ -443,46 +547,206 @@ func (s *SearchService) QASearch(ctx context.Context, query string, limit int) (
 	}
 
 	return &QASearchResults{
-		Answers:  rankedAnswers,
-		Strategy: "qa-hybrid",
-		Total:    len(rankedAnswers),
+		Answers:    rankedAnswers,
+		Strategy:   "qa-hybrid",
+		Total:      len(rankedAnswers),
+		Partial:    report.Partial(),
+		Extraction: report,
+		Model:      s.answerExtractionService.Model(),
 	}, nil
 }
 
-// prepareCandidateChunks converts search results into chunks with metadata
+// prepareCandidateChunks converts search results into chunks with metadata,
+// best fused retrieval score first
 func (s *SearchService) prepareCandidateChunks(vectorResults, textResults []SearchResult, limit int) []ChunkWithMetadata {
+	scores := fuseRetrievalScores(vectorResults, textResults)
 	seen := make(map[string]bool)
 	var chunks []ChunkWithMetadata
 
-	// Process vector results
-	for _, result := range vectorResults {
-		if !seen[result.ID] && len(chunks) < limit {
+	// Vector results first, so ties keep the previous order
+	for _, results := range [][]SearchResult{vectorResults, textResults} {
+		for _, result := range results {
+			if seen[result.ID] {
+				continue
+			}
 			chunks = append(chunks, ChunkWithMetadata{
 				Text: result.ChunkText,
 				Metadata: SourceMetadata{
-					ChunkID:     result.ID,
-					Title:       result.ContentTitle,
-					ContentType: result.ContentType,
+					ChunkID:        result.ID,
+					ContentItemID:  result.ContentItemID,
+					Title:          result.ContentTitle,
+					ContentType:    result.ContentType,
+					RetrievalScore: scores[result.ID],
 				},
 			})
 			seen[result.ID] = true
 		}
 	}
 
-	// Process text results
-	for _, result := range textResults {
-		if !seen[result.ID] && len(chunks) < limit {
-			chunks = append(chunks, ChunkWithMetadata{
-				Text: result.ChunkText,
-				Metadata: SourceMetadata{
-					ChunkID:     result.ID,
-					Title:       result.ContentTitle,
-					ContentType: result.ContentType,
-				},
-			})
-			seen[result.ID] = true
-		}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Metadata.RetrievalScore > chunks[j].Metadata.RetrievalScore
+	})
+	if len(chunks) > limit {
+		chunks = chunks[:limit]
+	}
+	for i := range chunks {
+		chunks[i].Metadata.RetrievalRank = i + 1
 	}
 
 	return chunks
-}
\ No newline at end of file
+}
+
+// SimilarDocument is a content item ranked by closeness to another item
+type SimilarDocument struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	ContentType string  `json:"content_type"`
+	ChunkCount  int     `json:"chunk_count"`
+	Relevance   float64 `json:"relevance"`
+}
+
+// ErrNoSourceEmbeddings is returned when the item or chunk to compare against has no stored vectors
+var ErrNoSourceEmbeddings = errors.New("source has no stored embeddings")
+
+// similarLimit bounds the neighbours returned by SimilarDocuments and
+// SimilarChunks: default 10, at most 50
+func similarLimit(limit int) int {
+	if limit <= 0 {
+		return 10
+	}
+	if limit > 50 {
+		return 50
+	}
+	return limit
+}
+
+// SimilarDocuments ranks other content items by the cosine distance between
+// document centroids (the mean of each document's chunk vectors)
+func (s *SearchService) SimilarDocuments(contentItemID string, limit int) ([]SimilarDocument, error) {
+	limit = similarLimit(limit)
+
+	var sourceCount int64
+	err := s.db.Raw(`
+		SELECT COUNT(*)
+		FROM embeddings e
+		JOIN chunks c ON e.chunk_id = c.id
+		WHERE c.content_item_id = ?
+	`, contentItemID).Scan(&sourceCount).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source embeddings: %w", err)
+	}
+	if sourceCount == 0 {
+		return nil, ErrNoSourceEmbeddings
+	}
+
+	// Only compare against vectors from the model that embedded the source,
+	// distances across models are meaningless
+	rows, err := s.db.Raw(`
+		WITH source AS (
+			SELECT e.embedding_model, AVG(e.embedding) AS centroid
+			FROM embeddings e
+			JOIN chunks c ON e.chunk_id = c.id
+			WHERE c.content_item_id = ?
+			GROUP BY e.embedding_model
+			ORDER BY COUNT(*) DESC
+			LIMIT 1
+		)
+		SELECT ci.id, ci.title, ci.content_type, COUNT(c.id) AS chunk_count,
+		       AVG(e.embedding) <=> (SELECT centroid FROM source) AS distance
+		FROM embeddings e
+		JOIN chunks c ON e.chunk_id = c.id
+		JOIN content_items ci ON c.content_item_id = ci.id
+		WHERE e.embedding_model = (SELECT embedding_model FROM source)
+		  AND ci.id <> ?
+		GROUP BY ci.id, ci.title, ci.content_type
+		ORDER BY distance
+		LIMIT ?
+	`, contentItemID, contentItemID, limit).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("similar documents query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SimilarDocument
+	for rows.Next() {
+		var doc SimilarDocument
+		var title sql.NullString
+		var distance float64
+
+		if err := rows.Scan(&doc.ID, &title, &doc.ContentType, &doc.ChunkCount, &distance); err != nil {
+			continue
+		}
+
+		doc.Title = title.String
+		doc.Relevance = 1.0 - distance
+		results = append(results, doc)
+	}
+
+	return results, nil
+}
+
+// SimilarChunks finds the chunks nearest to a given chunk, skipping chunks
+// from the same document so the neighbours point somewhere new
+func (s *SearchService) SimilarChunks(chunkID string, limit int) ([]SearchResult, error) {
+	limit = similarLimit(limit)
+
+	var sourceCount int64
+	err := s.db.Raw(`SELECT COUNT(*) FROM embeddings WHERE chunk_id = ?`, chunkID).Scan(&sourceCount).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source embedding: %w", err)
+	}
+	if sourceCount == 0 {
+		return nil, ErrNoSourceEmbeddings
+	}
+
+	rows, err := s.db.Raw(`
+		WITH source AS (
+			SELECT c.content_item_id, e.embedding_model, e.embedding
+			FROM embeddings e
+			JOIN chunks c ON e.chunk_id = c.id
+			WHERE c.id = ?
+			ORDER BY e.embedding_version DESC
+			LIMIT 1
+		)
+		SELECT c.chunk_text, c.chunk_span, ci.title, ci.content_type, c.id,
+		       e.embedding <=> source.embedding AS distance
+		FROM embeddings e
+		JOIN chunks c ON e.chunk_id = c.id
+		JOIN content_items ci ON c.content_item_id = ci.id
+		JOIN source ON e.embedding_model = source.embedding_model
+		WHERE c.content_item_id <> source.content_item_id
+		ORDER BY e.embedding <=> source.embedding
+		LIMIT ?
+	`, chunkID, limit).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("similar chunks query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		var distance float64
+		var chunkSpanJSON []byte
+
+		err := rows.Scan(&result.ChunkText, &chunkSpanJSON, &result.ContentTitle,
+						&result.ContentType, &result.ID, &distance)
+		if err != nil {
+			continue
+		}
+
+		result.Relevance = 1.0 - distance
+		result.Source = "vector"
+
+		if len(chunkSpanJSON) > 0 {
+			var spanData map[string]interface{}
+			if err := json.Unmarshal(chunkSpanJSON, &spanData); err == nil {
+				result.ChunkSpan = spanData
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
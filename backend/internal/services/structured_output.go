@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StructuredCall sends a prompt to the model and returns its raw text. When
+// repair is non-nil the client should resend the conversation with the model's
+// previous output and the repair instruction appended.
+type StructuredCall func(ctx context.Context, repair *StructuredRepair) (string, error)
+
+// StructuredRepair carries a rejected output back to the model for one retry
+type StructuredRepair struct {
+	PreviousOutput string
+	Instruction    string
+}
+
+// StructuredOutputStats counts parse outcomes for one provider
+type StructuredOutputStats struct {
+	Provider string `json:"provider"`
+	Calls    int64  `json:"calls"`
+	Parsed   int64  `json:"parsed"`   // Valid on the first try
+	Repaired int64  `json:"repaired"` // Valid after the repair retry
+	Failed   int64  `json:"failed"`   // Still invalid after the retry
+}
+
+var (
+	// ErrNoJSON is returned when no JSON value can be found in a response
+	ErrNoJSON = errors.New("no JSON found in response")
+
+	// ErrInvalidStructuredOutput wraps outputs that stayed unusable after the repair retry
+	ErrInvalidStructuredOutput = errors.New("invalid structured output")
+)
+
+var structuredOutputMetrics = struct {
+	mu        sync.Mutex
+	providers map[string]*StructuredOutputStats
+}{providers: make(map[string]*StructuredOutputStats)}
+
+// StructuredOutputMetrics returns parse counters per provider, sorted by name
+func StructuredOutputMetrics() []StructuredOutputStats {
+	structuredOutputMetrics.mu.Lock()
+	defer structuredOutputMetrics.mu.Unlock()
+
+	stats := make([]StructuredOutputStats, 0, len(structuredOutputMetrics.providers))
+	for _, providerStats := range structuredOutputMetrics.providers {
+		stats = append(stats, *providerStats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Provider < stats[j].Provider
+	})
+	return stats
+}
+
+func recordStructuredOutput(provider string, update func(*StructuredOutputStats)) {
+	structuredOutputMetrics.mu.Lock()
+	defer structuredOutputMetrics.mu.Unlock()
+
+	stats, ok := structuredOutputMetrics.providers[provider]
+	if !ok {
+		stats = &StructuredOutputStats{Provider: provider}
+		structuredOutputMetrics.providers[provider] = stats
+	}
+	update(stats)
+}
+
+// GenerateStructured calls the model, extracts and validates JSON into dest and,
+// if that fails, asks the model once to repair its output. Transport errors
+// from the first call are returned as-is; anything that fails after a usable
+// response came back wraps ErrInvalidStructuredOutput.
+func GenerateStructured(ctx context.Context, provider string, call StructuredCall, dest interface{}, requiredFields []string) error {
+	recordStructuredOutput(provider, func(s *StructuredOutputStats) { s.Calls++ })
+
+	output, err := call(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	parseErr := ParseStructured(output, dest, requiredFields)
+	if parseErr == nil {
+		recordStructuredOutput(provider, func(s *StructuredOutputStats) { s.Parsed++ })
+		return nil
+	}
+
+	repaired, err := call(ctx, &StructuredRepair{
+		PreviousOutput: output,
+		Instruction: fmt.Sprintf("Your previous response could not be used: %v. "+
+			"Reply again with only the JSON, no prose and no code fences.", parseErr),
+	})
+	if err != nil {
+		recordStructuredOutput(provider, func(s *StructuredOutputStats) { s.Failed++ })
+		return fmt.Errorf("%w: %v (repair request failed: %v)", ErrInvalidStructuredOutput, parseErr, err)
+	}
+
+	if err := ParseStructured(repaired, dest, requiredFields); err != nil {
+		recordStructuredOutput(provider, func(s *StructuredOutputStats) { s.Failed++ })
+		return fmt.Errorf("%w after repair: %v", ErrInvalidStructuredOutput, err)
+	}
+
+	recordStructuredOutput(provider, func(s *StructuredOutputStats) { s.Repaired++ })
+	return nil
+}
+
+// ParseStructured extracts the JSON value from text, checks that every object
+// in it has the required fields and decodes it into dest
+func ParseStructured(text string, dest interface{}, requiredFields []string) error {
+	raw, err := ExtractJSON(text)
+	if err != nil {
+		return err
+	}
+
+	if err := validateRequiredFields([]byte(raw), requiredFields); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(raw), dest); err != nil {
+		return fmt.Errorf("JSON does not match the expected shape: %w", err)
+	}
+	return nil
+}
+
+// ExtractJSON finds the JSON value in a model response: the whole text, the
+// contents of a ``` fence, or the first balanced object/array embedded in prose
+func ExtractJSON(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if json.Valid([]byte(text)) {
+		return text, nil
+	}
+
+	if fenced, ok := fencedBlock(text); ok && json.Valid([]byte(fenced)) {
+		return fenced, nil
+	}
+
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && text[start] != '[' {
+			continue
+		}
+		if end := matchingBracket(text, start); end > start {
+			candidate := text[start : end+1]
+			if json.Valid([]byte(candidate)) {
+				return candidate, nil
+			}
+		}
+	}
+
+	return "", ErrNoJSON
+}
+
+// fencedBlock returns the body of the first ``` code fence, with or without a language tag
+func fencedBlock(text string) (string, bool) {
+	open := strings.Index(text, "```")
+	if open < 0 {
+		return "", false
+	}
+	body := text[open+3:]
+	if newline := strings.Index(body, "\n"); newline >= 0 && !strings.ContainsAny(body[:newline], "{[") {
+		body = body[newline+1:]
+	}
+	close := strings.Index(body, "```")
+	if close < 0 {
+		return "", false
+	}
+	return strings.TrimSpace(body[:close]), true
+}
+
+// matchingBracket returns the index closing the bracket at start, skipping
+// brackets inside JSON strings, or -1 if it is never closed
+func matchingBracket(text string, start int) int {
+	depth := 0
+	inString, escaped := false, false
+
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// validateRequiredFields checks that the object, or every object in an array,
+// has the required keys. A bare `{}` would otherwise decode to all zero values.
+func validateRequiredFields(raw []byte, requiredFields []string) error {
+	if len(requiredFields) == 0 {
+		return nil
+	}
+
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &objects); err != nil {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return fmt.Errorf("expected a JSON object or array of objects")
+		}
+		objects = []map[string]json.RawMessage{object}
+	}
+
+	for i, object := range objects {
+		for _, field := range requiredFields {
+			if _, ok := object[field]; !ok {
+				if len(objects) == 1 {
+					return fmt.Errorf("missing required field %q", field)
+				}
+				return fmt.Errorf("item %d is missing required field %q", i+1, field)
+			}
+		}
+	}
+	return nil
+}
+
+// answerFields are required in every answer-extraction object
+var answerFields = []string{"answer", "confidence", "has_answer"}
+
+// parseLLMResponse runs a single-chunk extraction through GenerateStructured and
+// falls back to "no answer" when the output can't be parsed even after repair
+func parseLLMResponse(ctx context.Context, provider string, call StructuredCall) (*LLMResponse, error) {
+	var llmResponse LLMResponse
+	err := GenerateStructured(ctx, provider, call, &llmResponse, answerFields)
+	if err != nil && !errors.Is(err, ErrInvalidStructuredOutput) {
+		return nil, err
+	}
+	if err != nil {
+		return &LLMResponse{
+			Answer:     "",
+			Confidence: 0.0,
+			HasAnswer:  false,
+			Reasoning:  fmt.Sprintf("Failed to parse %s response: %v", provider, err),
+		}, nil
+	}
+
+	// Validate confidence score
+	if llmResponse.Confidence < 0.0 {
+		llmResponse.Confidence = 0.0
+	}
+	if llmResponse.Confidence > 1.0 {
+		llmResponse.Confidence = 1.0
+	}
+
+	return &llmResponse, nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"bare object", `{"answer": "Paris"}`, `{"answer": "Paris"}`},
+		{"surrounding whitespace", "\n  {\"a\": 1}\n", `{"a": 1}`},
+		{"bare array", `[1, 2]`, `[1, 2]`},
+		{"fence with language tag", "```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"fence without tag", "```\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"fence on one line", "```{\"a\": 1}```", `{"a": 1}`},
+		{"prose around fence", "Here you go:\n```json\n{\"a\": 1}\n```\nAnything else?", `{"a": 1}`},
+		{"prose around object", `Sure! {"a": 1} Hope that helps.`, `{"a": 1}`},
+		{"array in prose", `Results: [{"a": 1}, {"a": 2}].`, `[{"a": 1}, {"a": 2}]`},
+		{"closing brace inside string", `Answer: {"a": "x } y"} done`, `{"a": "x } y"}`},
+		{"escaped quote inside string", `{"a": "say \"}\""} trailing`, `{"a": "say \"}\""}`},
+		{"nested objects", `text {"a": {"b": [1, {"c": 2}]}} text`, `{"a": {"b": [1, {"c": 2}]}}`},
+		{"invalid candidate skipped", `{not json} then {"a": 1}`, `{"a": 1}`},
+		{"unclosed fence", "```json\n{\"a\": 1}", `{"a": 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractJSON(tt.text)
+			if err != nil {
+				t.Fatalf("ExtractJSON(%q) failed: %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("ExtractJSON(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractJSONWithoutJSON(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"I couldn't find an answer.",
+		`{"answer": "Paris", "confidence": `,
+		"```json\n{\"a\": [1, 2",
+		`{"a": "unterminated}`,
+	} {
+		if got, err := ExtractJSON(text); !errors.Is(err, ErrNoJSON) {
+			t.Errorf("ExtractJSON(%q) = %q, %v; want ErrNoJSON", text, got, err)
+		}
+	}
+}
+
+func TestFencedBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{"language tag dropped", "```json\n{\"a\": 1}\n```", `{"a": 1}`, true},
+		{"first fence wins", "```\none\n```\n```\ntwo\n```", "one", true},
+		{"no fence", `{"a": 1}`, "", false},
+		{"unclosed fence", "```json\n{\"a\": 1}", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := fencedBlock(tt.text)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("fencedBlock(%q) = %q, %v; want %q, %v", tt.text, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMatchingBracket(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		start int
+		want  int
+	}{
+		{"flat object", `{"a": 1}`, 0, 7},
+		{"nested", `x{"a": [1, 2]}y`, 1, 13},
+		{"brackets inside strings", `{"a": "]}"}`, 0, 10},
+		{"escaped backslash before quote", `{"a": "\\"}`, 0, 10},
+		{"unclosed", `{"a": [1, 2}`, 0, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchingBracket(tt.text, tt.start); got != tt.want {
+				t.Errorf("matchingBracket(%q, %d) = %d, want %d", tt.text, tt.start, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStructuredRequiredFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{"all fields", `{"answer": "", "confidence": 0, "has_answer": false}`, ""},
+		{"missing field", `{"answer": "Paris", "confidence": 0.9}`, `missing required field "has_answer"`},
+		{"empty object", `{}`, `missing required field "answer"`},
+		{"array item missing field", `[{"answer": "a", "confidence": 1, "has_answer": true}, {"answer": "b"}]`,
+			`item 2 is missing required field "confidence"`},
+		{"not an object", `"Paris"`, "expected a JSON object or array of objects"},
+		{"wrong type", `{"answer": 1, "confidence": 0.9, "has_answer": true}`, "does not match the expected shape"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dest interface{} = &LLMResponse{}
+			if strings.HasPrefix(tt.text, "[") {
+				dest = &[]LLMResponse{}
+			}
+			err := ParseStructured(tt.text, dest, answerFields)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParseStructured(%q) failed: %v", tt.text, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseStructured(%q) error = %v, want one containing %q", tt.text, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// scriptedCaller returns outputs in order and records the repair requests it gets
+type scriptedCaller struct {
+	outputs []string
+	errs    []error
+	repairs []*StructuredRepair
+}
+
+func (s *scriptedCaller) call(ctx context.Context, repair *StructuredRepair) (string, error) {
+	attempt := len(s.repairs)
+	s.repairs = append(s.repairs, repair)
+	if attempt < len(s.errs) && s.errs[attempt] != nil {
+		return "", s.errs[attempt]
+	}
+	return s.outputs[attempt], nil
+}
+
+// structuredStats returns the counters for provider, zero if it has none
+func structuredStats(provider string) StructuredOutputStats {
+	for _, stats := range StructuredOutputMetrics() {
+		if stats.Provider == provider {
+			return stats
+		}
+	}
+	return StructuredOutputStats{Provider: provider}
+}
+
+const validAnswer = `{"answer": "Paris", "confidence": 0.9, "has_answer": true}`
+
+func TestGenerateStructuredParsesFirstResponse(t *testing.T) {
+	caller := &scriptedCaller{outputs: []string{"Here it is: " + validAnswer}}
+
+	var response LLMResponse
+	err := GenerateStructured(context.Background(), "test-parsed", caller.call, &response, answerFields)
+	if err != nil {
+		t.Fatalf("GenerateStructured failed: %v", err)
+	}
+	if response.Answer != "Paris" || !response.HasAnswer {
+		t.Errorf("response = %+v, want Paris", response)
+	}
+	if len(caller.repairs) != 1 || caller.repairs[0] != nil {
+		t.Errorf("calls = %d, want a single call without repair", len(caller.repairs))
+	}
+	if stats := structuredStats("test-parsed"); stats.Calls != 1 || stats.Parsed != 1 {
+		t.Errorf("stats = %+v, want 1 call parsed", stats)
+	}
+}
+
+func TestGenerateStructuredRepairsInvalidOutput(t *testing.T) {
+	first := `{"answer": "Paris"}`
+	caller := &scriptedCaller{outputs: []string{first, validAnswer}}
+
+	var response LLMResponse
+	err := GenerateStructured(context.Background(), "test-repaired", caller.call, &response, answerFields)
+	if err != nil {
+		t.Fatalf("GenerateStructured failed: %v", err)
+	}
+	if response.Answer != "Paris" || response.Confidence != 0.9 {
+		t.Errorf("response = %+v, want the repaired answer", response)
+	}
+
+	if len(caller.repairs) != 2 || caller.repairs[1] == nil {
+		t.Fatalf("calls = %d, want a repair retry", len(caller.repairs))
+	}
+	repair := caller.repairs[1]
+	if repair.PreviousOutput != first {
+		t.Errorf("repair previous output = %q, want %q", repair.PreviousOutput, first)
+	}
+	if !strings.Contains(repair.Instruction, `missing required field "confidence"`) {
+		t.Errorf("repair instruction = %q, want it to name the problem", repair.Instruction)
+	}
+	if stats := structuredStats("test-repaired"); stats.Calls != 1 || stats.Repaired != 1 {
+		t.Errorf("stats = %+v, want 1 call repaired", stats)
+	}
+}
+
+func TestGenerateStructuredFailures(t *testing.T) {
+	transportErr := errors.New("connection reset")
+
+	tests := []struct {
+		name       string
+		caller     *scriptedCaller
+		wantErr    error
+		wantCalls  int
+		wantFailed int64
+	}{
+		{"first call fails", &scriptedCaller{errs: []error{transportErr}}, transportErr, 1, 0},
+		{"still invalid after repair", &scriptedCaller{outputs: []string{"no idea", "still no idea"}}, ErrInvalidStructuredOutput, 2, 1},
+		{"repair call fails", &scriptedCaller{outputs: []string{"no idea"}, errs: []error{nil, transportErr}}, ErrInvalidStructuredOutput, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := "test-" + tt.name
+			var response LLMResponse
+			err := GenerateStructured(context.Background(), provider, tt.caller.call, &response, answerFields)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(tt.caller.repairs) != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", len(tt.caller.repairs), tt.wantCalls)
+			}
+			if stats := structuredStats(provider); stats.Failed != tt.wantFailed {
+				t.Errorf("failed = %d, want %d", stats.Failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestParseLLMResponseFallsBackToNoAnswer(t *testing.T) {
+	caller := &scriptedCaller{outputs: []string{"no idea", "no idea"}}
+
+	response, err := parseLLMResponse(context.Background(), "test-fallback", caller.call)
+	if err != nil {
+		t.Fatalf("parseLLMResponse failed: %v", err)
+	}
+	if response.HasAnswer || response.Answer != "" || response.Confidence != 0 {
+		t.Errorf("response = %+v, want no answer", response)
+	}
+}
+
+func TestParseLLMResponseClampsConfidence(t *testing.T) {
+	for _, tt := range []struct {
+		output string
+		want   float64
+	}{
+		{`{"answer": "a", "confidence": 1.7, "has_answer": true}`, 1},
+		{`{"answer": "a", "confidence": -0.2, "has_answer": true}`, 0},
+	} {
+		caller := &scriptedCaller{outputs: []string{tt.output}}
+		response, err := parseLLMResponse(context.Background(), "test-clamp", caller.call)
+		if err != nil {
+			t.Fatalf("parseLLMResponse failed: %v", err)
+		}
+		if response.Confidence != tt.want {
+			t.Errorf("confidence from %s = %v, want %v", tt.output, response.Confidence, tt.want)
+		}
+	}
+}
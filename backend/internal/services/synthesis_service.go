@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tanaymehhta/self/backend/internal/prompts"
+)
+
+// TextGenerator is implemented by LLM clients that can produce free-form text
+type TextGenerator interface {
+	GenerateText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int) (string, error)
+}
+
+// TextStreamer is implemented by LLM clients that can stream free-form text.
+// onToken is called with each piece as it arrives; an error from it aborts the call.
+type TextStreamer interface {
+	StreamText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int, onToken func(string) error) (string, error)
+}
+
+// SynthesisService merges the top extracted answers into one cited answer
+type SynthesisService struct {
+	generator  TextGenerator
+	maxSources int
+}
+
+// Citation maps an inline [n] marker back to the chunk it came from
+type Citation struct {
+	Number      int      `json:"number"`
+	ChunkID     string   `json:"chunk_id"`
+	Title       string   `json:"title"`
+	ContentType string   `json:"content_type"`
+	Excerpt     string   `json:"excerpt"`
+	PageNum     *int     `json:"page_num,omitempty"`
+	StartTime   *float64 `json:"start_time,omitempty"`
+	EndTime     *float64 `json:"end_time,omitempty"`
+	Speaker     *string  `json:"speaker,omitempty"`
+	Grounded    bool     `json:"grounded"`
+}
+
+// SynthesizedAnswer is the final answer text with its citation list
+type SynthesizedAnswer struct {
+	Text       string     `json:"text"`
+	Citations  []Citation `json:"citations"`
+	Confidence float64    `json:"confidence"`
+	Strategy   string     `json:"strategy"` // "llm" or "extractive"
+
+	// Prompt template used for "llm" answers, e.g. "synthesis@v1"
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+var citationMarker = regexp.MustCompile(`\[(\d+)\]`)
+
+func NewSynthesisService(generator TextGenerator) *SynthesisService {
+	return &SynthesisService{
+		generator:  generator,
+		maxSources: 5,
+	}
+}
+
+// Model returns the model answers are synthesized with, or "" without one
+func (s *SynthesisService) Model() string {
+	if namer, ok := s.generator.(modelNamer); ok {
+		return namer.Model()
+	}
+	return ""
+}
+
+// Synthesize combines answers into one response with inline [n] citations.
+// Without a generator, or if the LLM call fails, it falls back to an
+// extractive answer built from the distinct extracted answers.
+func (s *SynthesisService) Synthesize(ctx context.Context, query string, answers []*AnswerResult) (*SynthesizedAnswer, error) {
+	return s.SynthesizeStream(ctx, query, answers, nil)
+}
+
+// SynthesizeStream is Synthesize that also passes the LLM's text to onToken as
+// it is generated, with citations already renumbered, when the generator can
+// stream. Once any text has been streamed the answer is that text, since the
+// client has already shown it, even if it cites no sources or the stream broke
+// off; before that, failures fall back to the extractive answer.
+func (s *SynthesisService) SynthesizeStream(ctx context.Context, query string, answers []*AnswerResult, onToken func(string) error) (*SynthesizedAnswer, error) {
+	var sources []*AnswerResult
+	for _, answer := range answers {
+		if answer.HasAnswer && len(sources) < s.maxSources {
+			sources = append(sources, answer)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no answers to synthesize")
+	}
+
+	streamed := false
+	if onToken != nil {
+		forward := onToken
+		onToken = func(text string) error {
+			streamed = true
+			return forward(text)
+		}
+	}
+
+	if s.generator != nil {
+		text, promptID, err := s.generate(ctx, query, sources, onToken)
+		if streamed && err != nil {
+			fmt.Printf("Answer stream broke off, keeping the streamed text: %v\n", err)
+		}
+		if (err == nil || streamed) && strings.TrimSpace(text) != "" {
+			text, citations := renumberCitations(strings.TrimSpace(text), sources)
+			if len(citations) > 0 || streamed {
+				return &SynthesizedAnswer{
+					Text:          text,
+					Citations:     citations,
+					Confidence:    citedConfidence(citations, sources),
+					Strategy:      "llm",
+					PromptVersion: promptID,
+				}, nil
+			}
+			fmt.Printf("Synthesized answer cited no sources, using extractive answer\n")
+		} else if err != nil {
+			fmt.Printf("Answer synthesis failed, using extractive answer: %v\n", err)
+		}
+	}
+
+	return extractiveSynthesis(sources), nil
+}
+
+// generate renders the synthesis prompt and calls the LLM, returning the
+// prompt version used. With onToken set and a generator that can stream, the
+// text is streamed through a citationRenumberer.
+func (s *SynthesisService) generate(ctx context.Context, query string, sources []*AnswerResult, onToken func(string) error) (string, string, error) {
+	ctx, prompt, err := selectPrompt(ctx, prompts.Synthesis)
+	if err != nil {
+		return "", "", err
+	}
+
+	systemPrompt, userPrompt, err := prompt.Render(map[string]string{
+		"question": query,
+		"sources":  formatSynthesisSources(sources),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	streamer, canStream := s.generator.(TextStreamer)
+	if onToken == nil || !canStream {
+		text, err := s.generator.GenerateText(ctx, systemPrompt, userPrompt, 800)
+		return text, prompt.ID(), err
+	}
+
+	renumberer := newCitationRenumberer(sources)
+	started := false
+	emit := func(text string) error {
+		if !started {
+			// Match the trimmed final text
+			text = strings.TrimLeftFunc(text, unicode.IsSpace)
+			started = text != ""
+		}
+		if text == "" {
+			return nil
+		}
+		return onToken(text)
+	}
+
+	text, err := streamer.StreamText(ctx, systemPrompt, userPrompt, 800, func(token string) error {
+		return emit(renumberer.write(token))
+	})
+	if err != nil {
+		// Pass on any held-back tail so the client has all of the text kept
+		emit(renumberer.flush())
+		return text, prompt.ID(), err
+	}
+	return text, prompt.ID(), emit(renumberer.flush())
+}
+
+// formatSynthesisSources numbers the sources the answer may cite
+func formatSynthesisSources(sources []*AnswerResult) string {
+	blocks := make([]string, len(sources))
+	for i, source := range sources {
+		blocks[i] = fmt.Sprintf("[%d] %s\nExtracted answer: %s\nPassage: %s",
+			i+1, source.SourceTitle, source.Answer, truncateText(source.SourceChunk, 1500))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// renumberCitations drops markers that point at no source and renumbers the
+// rest 1..n in order of first use, so the citation list has no gaps
+func renumberCitations(text string, sources []*AnswerResult) (string, []Citation) {
+	renumberer := newCitationRenumberer(sources)
+	text = renumberer.replace(text)
+	return text, renumberer.citations
+}
+
+// citationRenumberer applies renumberCitations incrementally to streamed text.
+// A trailing "[" or "[12" is held back until it's known whether it is a marker.
+type citationRenumberer struct {
+	sources   []*AnswerResult
+	mapping   map[int]int
+	citations []Citation
+	pending   string
+}
+
+func newCitationRenumberer(sources []*AnswerResult) *citationRenumberer {
+	return &citationRenumberer{
+		sources: sources,
+		mapping: make(map[int]int),
+	}
+}
+
+// write returns the part of the text so far that is safe to pass on
+func (r *citationRenumberer) write(token string) string {
+	text := r.pending + token
+	r.pending = ""
+
+	if open := strings.LastIndex(text, "["); open >= 0 && isDigits(text[open+1:]) {
+		text, r.pending = text[:open], text[open:]
+	}
+	return r.replace(text)
+}
+
+// flush returns whatever was held back once the stream has ended
+func (r *citationRenumberer) flush() string {
+	text := r.pending
+	r.pending = ""
+	return r.replace(text)
+}
+
+func (r *citationRenumberer) replace(text string) string {
+	return citationMarker.ReplaceAllStringFunc(text, func(marker string) string {
+		original, _ := strconv.Atoi(marker[1 : len(marker)-1])
+		if original < 1 || original > len(r.sources) {
+			return ""
+		}
+
+		number, ok := r.mapping[original]
+		if !ok {
+			number = len(r.citations) + 1
+			r.mapping[original] = number
+			r.citations = append(r.citations, newCitation(number, r.sources[original-1]))
+		}
+		return fmt.Sprintf("[%d]", number)
+	})
+}
+
+func isDigits(text string) bool {
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// extractiveSynthesis lists the distinct extracted answers, each with its citation
+func extractiveSynthesis(sources []*AnswerResult) *SynthesizedAnswer {
+	var (
+		parts     []string
+		citations []Citation
+		seen      = make(map[string]bool)
+	)
+
+	for _, source := range sources {
+		normalized := strings.ToLower(strings.TrimSpace(source.Answer))
+		if normalized == "" || seen[normalized] {
+			continue
+		}
+		// The best answer leads; only strong answers are added as support
+		if len(citations) > 0 && source.Confidence <= 0.6 {
+			continue
+		}
+		seen[normalized] = true
+
+		citation := newCitation(len(citations)+1, source)
+		citations = append(citations, citation)
+		parts = append(parts, fmt.Sprintf("%s [%d]", strings.TrimSpace(source.Answer), citation.Number))
+
+		if len(parts) >= 3 {
+			break
+		}
+	}
+
+	return &SynthesizedAnswer{
+		Text:       strings.Join(parts, "\n\n"),
+		Citations:  citations,
+		Confidence: sources[0].Confidence,
+		Strategy:   "extractive",
+	}
+}
+
+func newCitation(number int, source *AnswerResult) Citation {
+	return Citation{
+		Number:      number,
+		ChunkID:     source.ChunkID,
+		Title:       source.SourceTitle,
+		ContentType: source.ContentType,
+		Excerpt:     truncateText(source.SourceChunk, 300),
+		PageNum:     source.PageNum,
+		StartTime:   source.StartTime,
+		EndTime:     source.EndTime,
+		Speaker:     source.Speaker,
+		Grounded:    source.Grounded,
+	}
+}
+
+// citedConfidence is the highest confidence among the cited sources
+func citedConfidence(citations []Citation, sources []*AnswerResult) float64 {
+	best := 0.0
+	for _, citation := range citations {
+		for _, source := range sources {
+			if source.ChunkID == citation.ChunkID && source.Confidence > best {
+				best = source.Confidence
+			}
+		}
+	}
+	return best
+}
+
+// truncateText cuts text to maxChars characters (runes, so multi-byte
+// characters are never split)
+func truncateText(text string, maxChars int) string {
+	if len(text) <= maxChars {
+		return text
+	}
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return text
+	}
+	return string(runes[:maxChars]) + "..."
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func numberedSources(n int) []*AnswerResult {
+	sources := make([]*AnswerResult, n)
+	for i := range sources {
+		sources[i] = &AnswerResult{ChunkID: fmt.Sprintf("chunk-%d", i+1), Answer: "answer", HasAnswer: true, Confidence: 0.8}
+	}
+	return sources
+}
+
+func TestCitationRenumberer(t *testing.T) {
+	tests := []struct {
+		name          string
+		sources       int
+		tokens        []string
+		want          string
+		wantCitations []string
+	}{
+		{"renumbered in order of use", 3, []string{"Paris [3] and Lyon [1]."}, "Paris [1] and Lyon [2].", []string{"chunk-3", "chunk-1"}},
+		{"marker split across tokens", 3, []string{"Paris ", "[", "2", "] and Lyon [1", "]."}, "Paris [1] and Lyon [2].", []string{"chunk-2", "chunk-1"}},
+		{"two-digit marker split", 12, []string{"Paris [1", "2]", " again [12]"}, "Paris [1] again [1]", []string{"chunk-12"}},
+		{"marker past the sources dropped", 2, []string{"Paris [", "9] [2]"}, "Paris  [1]", []string{"chunk-2"}},
+		{"zero marker dropped", 2, []string{"Paris [0]"}, "Paris ", nil},
+		{"non-marker brackets passed on", 2, []string{"see [a", "ppendix] [", "x]"}, "see [appendix] [x]", nil},
+		{"unfinished marker flushed as text", 2, []string{"Paris [1"}, "Paris [1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renumberer := newCitationRenumberer(numberedSources(tt.sources))
+			var out strings.Builder
+			for _, token := range tt.tokens {
+				out.WriteString(renumberer.write(token))
+			}
+			out.WriteString(renumberer.flush())
+
+			if out.String() != tt.want {
+				t.Errorf("text = %q, want %q", out.String(), tt.want)
+			}
+			var cited []string
+			for i, citation := range renumberer.citations {
+				cited = append(cited, citation.ChunkID)
+				if citation.Number != i+1 {
+					t.Errorf("citation %d numbered %d", i+1, citation.Number)
+				}
+			}
+			if strings.Join(cited, ",") != strings.Join(tt.wantCitations, ",") {
+				t.Errorf("citations = %v, want %v", cited, tt.wantCitations)
+			}
+		})
+	}
+}
+
+func TestCitationRenumbererHoldsBackPartialMarkers(t *testing.T) {
+	renumberer := newCitationRenumberer(numberedSources(12))
+
+	steps := []struct {
+		token string
+		want  string
+	}{
+		{"Paris is the capital [", "Paris is the capital "},
+		{"1", ""},
+		{"2", ""},
+		{"] of France", "[1] of France"},
+		{" [x", " [x"},
+	}
+	for _, step := range steps {
+		if got := renumberer.write(step.token); got != step.want {
+			t.Errorf("write(%q) = %q, want %q", step.token, got, step.want)
+		}
+	}
+	if got := renumberer.flush(); got != "" {
+		t.Errorf("flush = %q, want nothing held back", got)
+	}
+}
+
+// stubStreamer streams tokens, then fails with err if it is set
+type stubStreamer struct {
+	tokens []string
+	err    error
+}
+
+func (s *stubStreamer) GenerateText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int) (string, error) {
+	return strings.Join(s.tokens, ""), s.err
+}
+
+func (s *stubStreamer) StreamText(ctx context.Context, systemPrompt, userPrompt string, maxTokens int, onToken func(string) error) (string, error) {
+	var text strings.Builder
+	for _, token := range s.tokens {
+		text.WriteString(token)
+		if err := onToken(token); err != nil {
+			return text.String(), err
+		}
+	}
+	return text.String(), s.err
+}
+
+func TestSynthesizeStream(t *testing.T) {
+	broken := errors.New("stream reset")
+
+	tests := []struct {
+		name         string
+		streamer     *stubStreamer
+		wantText     string
+		wantStrategy string
+		wantStreamed bool
+	}{
+		{"complete stream", &stubStreamer{tokens: []string{" Paris [", "2]."}}, "Paris [1].", "llm", true},
+		{"broken after tokens keeps the partial text", &stubStreamer{tokens: []string{"Paris [2", "] and"}, err: broken}, "Paris [1] and", "llm", true},
+		{"tail held back when the stream broke", &stubStreamer{tokens: []string{"Paris [2"}, err: broken}, "Paris [2", "llm", true},
+		{"uncited stream kept", &stubStreamer{tokens: []string{"Paris."}}, "Paris.", "llm", true},
+		{"broken before tokens falls back", &stubStreamer{err: broken}, "Paris [1]", "extractive", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := []*AnswerResult{
+				{ChunkID: "chunk-1", Answer: "Paris", HasAnswer: true, Confidence: 0.9},
+				{ChunkID: "chunk-2", Answer: "Paris", HasAnswer: true, Confidence: 0.8},
+			}
+
+			var streamed strings.Builder
+			answer, err := NewSynthesisService(tt.streamer).SynthesizeStream(context.Background(), "capital?", sources,
+				func(text string) error {
+					streamed.WriteString(text)
+					return nil
+				})
+			if err != nil {
+				t.Fatalf("SynthesizeStream failed: %v", err)
+			}
+
+			if answer.Text != tt.wantText || answer.Strategy != tt.wantStrategy {
+				t.Errorf("answer = %q (%s), want %q (%s)", answer.Text, answer.Strategy, tt.wantText, tt.wantStrategy)
+			}
+			if tt.wantStreamed && streamed.String() != answer.Text {
+				t.Errorf("streamed %q, but kept %q", streamed.String(), answer.Text)
+			}
+			if !tt.wantStreamed && streamed.Len() != 0 {
+				t.Errorf("streamed %q before falling back", streamed.String())
+			}
+		})
+	}
+}
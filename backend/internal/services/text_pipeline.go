@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -18,8 +20,13 @@ type TextPipeline struct {
 	embeddingService *EmbeddingService
 	chunkService     *ChunkService
 	textExtractor    *TextExtractorService
+	savedSearches    *SavedSearchService
+	answers          *AnswerExtractionService
 }
 
+// ErrContentItemNotFound is returned for unknown or foreign content items
+var ErrContentItemNotFound = errors.New("content item not found")
+
 type ContentItem struct {
 	ID           uuid.UUID    `json:"id"`
 	UserID       uuid.UUID    `json:"user_id"`
@@ -59,6 +66,22 @@ func NewTextPipeline(db *gorm.DB) *TextPipeline {
 	}
 }
 
+// SetSavedSearchService enables saved-search matching once a document finishes processing
+func (t *TextPipeline) SetSavedSearchService(savedSearches *SavedSearchService) {
+	t.savedSearches = savedSearches
+}
+
+// SetAnswerService lets DeleteContentItem drop the answers cached for a
+// deleted item's chunks
+func (t *TextPipeline) SetAnswerService(answers *AnswerExtractionService) {
+	t.answers = answers
+}
+
+// SetUsageScope attributes chunk-embedding usage to a user
+func (t *TextPipeline) SetUsageScope(scope UsageScope) {
+	t.embeddingService.SetUsageScope(scope)
+}
+
 func (t *TextPipeline) ProcessDocument(userID uuid.UUID, file multipart.File, header *multipart.FileHeader) (*ContentItem, error) {
 	return t.ProcessDocumentWithLogging(userID, file, header, nil)
 }
@@ -130,17 +153,19 @@ func (t *TextPipeline) ProcessDocumentWithLogging(userID uuid.UUID, file multipa
 
 	// 6. Process text into chunks and embeddings with detailed logging
 	logger.LogStart("async_processing", "Starting chunking and embedding process")
-	go t.processTextAsyncWithLogging(contentItem.ID, text, logger)
+	go t.processTextAsyncWithLogging(contentItem, text, logger)
 
 	return contentItem, nil
 }
 
-func (t *TextPipeline) processTextAsync(contentItemID uuid.UUID, text string) {
+func (t *TextPipeline) processTextAsync(contentItem *ContentItem, text string) {
 	logger := NewPipelineLogger()
-	t.processTextAsyncWithLogging(contentItemID, text, logger)
+	t.processTextAsyncWithLogging(contentItem, text, logger)
 }
 
-func (t *TextPipeline) processTextAsyncWithLogging(contentItemID uuid.UUID, text string, logger *PipelineLogger) {
+func (t *TextPipeline) processTextAsyncWithLogging(contentItem *ContentItem, text string, logger *PipelineLogger) {
+	contentItemID := contentItem.ID
+
 	defer func() {
 		logger.Complete()
 		logger.Print() // Print to console for debugging
@@ -175,6 +200,7 @@ func (t *TextPipeline) processTextAsyncWithLogging(contentItemID uuid.UUID, text
 	// 2. Process each chunk
 	successfulChunks := 0
 	successfulEmbeddings := 0
+	var ingested []IngestedChunk
 
 	for i, chunkText := range chunks {
 		// Save chunk to database
@@ -230,6 +256,7 @@ func (t *TextPipeline) processTextAsyncWithLogging(contentItemID uuid.UUID, text
 				"vector_dim":      embedding.EmbeddingDim,
 			})
 		successfulEmbeddings++
+		ingested = append(ingested, IngestedChunk{ID: chunk.ID, Text: chunkText, Vector: embedding.Vector})
 	}
 
 	// Final summary
@@ -241,6 +268,56 @@ func (t *TextPipeline) processTextAsyncWithLogging(contentItemID uuid.UUID, text
 			"successful_embeddings": successfulEmbeddings,
 			"content_id":           contentItemID,
 		})
+
+	// 3. Notify saved searches that match the new content
+	if t.savedSearches != nil {
+		logger.LogStart("saved_search_matching", "Matching new chunks against saved searches")
+		insights, err := t.savedSearches.EvaluateNewContent(contentItem, ingested)
+		if err != nil {
+			logger.LogError("saved_search_matching", "Failed to match saved searches", err)
+		} else {
+			logger.LogSuccess("saved_search_matching", fmt.Sprintf("Created %d saved search insights", len(insights)), nil)
+		}
+	}
+}
+
+// DeleteContentItem deletes one of the user's content items. Its chunks,
+// embeddings and conversation links go with it (ON DELETE CASCADE), and answers
+// cached for its chunks are invalidated.
+func (t *TextPipeline) DeleteContentItem(ctx context.Context, userID, contentItemID uuid.UUID) error {
+	var chunkIDs []string
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("chunks").
+			Where("content_item_id = ?", contentItemID).
+			Pluck("id", &chunkIDs).Error
+		if err != nil {
+			return fmt.Errorf("failed to load chunks: %w", err)
+		}
+
+		result := tx.Table("content_items").
+			Where("id = ? AND user_id = ?", contentItemID, userID).
+			Delete(&ContentItem{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete content item: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrContentItemNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Best-effort: a missed invalidation only leaves entries that expire with the TTL
+	if t.answers != nil {
+		for _, chunkID := range chunkIDs {
+			if err := t.answers.InvalidateChunk(ctx, chunkID); err != nil {
+				fmt.Printf("Failed to invalidate cached answers for chunk %s: %v\n", chunkID, err)
+			}
+		}
+	}
+	return nil
 }
 
 // Legacy method - now handled by TextExtractorService
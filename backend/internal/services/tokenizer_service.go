@@ -3,6 +3,7 @@ package services
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/pkoukk/tiktoken-go"
 )
@@ -23,6 +24,26 @@ func NewTokenizerService() (*TokenizerService, error) {
 	}, nil
 }
 
+var (
+	sharedTokenizerService *TokenizerService
+	sharedTokenizerOnce    sync.Once
+)
+
+// sharedTokenizer returns a process-wide tokenizer, built on first use, or nil
+// if the encoding can't be loaded. Building the BPE is slow, so per-request
+// services should count tokens with this rather than a NewTokenizerService.
+func sharedTokenizer() *TokenizerService {
+	sharedTokenizerOnce.Do(func() {
+		tokenizer, err := NewTokenizerService()
+		if err != nil {
+			fmt.Printf("Tokenizer unavailable, estimating token counts: %v\n", err)
+			return
+		}
+		sharedTokenizerService = tokenizer
+	})
+	return sharedTokenizerService
+}
+
 func (t *TokenizerService) CountTokens(text string) int {
 	if t.encoder == nil {
 		// Fallback to rough approximation
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Features usage is attributed to
+const (
+	UsageFeatureChat        = "chat"
+	UsageFeatureQA          = "qa"
+	UsageFeatureSearch      = "search"
+	UsageFeatureIngestion   = "ingestion"
+	UsageFeatureSavedSearch = "saved_search"
+)
+
+// Kinds of provider call; quotas budget completions and embeddings separately
+const (
+	UsageKindCompletion = "completion"
+	UsageKindEmbedding  = "embedding"
+)
+
+// LLMUsage is one provider call's token usage and cost
+type LLMUsage struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       *uuid.UUID `json:"user_id,omitempty"`
+	Feature      string     `json:"feature"`
+	Kind         string     `json:"kind"`
+	Provider     string     `json:"provider"`
+	Model        string     `json:"model"`
+	InputTokens  int        `json:"input_tokens"`
+	OutputTokens int        `json:"output_tokens"`
+	Estimated    bool       `json:"estimated"` // Counted locally; the provider reported no usage
+	CostUSD      float64    `json:"cost_usd"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func (LLMUsage) TableName() string {
+	return "llm_usage"
+}
+
+// ModelPrice is the USD price per million tokens
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// PriceTable maps model names to prices
+type PriceTable map[string]ModelPrice
+
+// DefaultPriceTable holds list prices for the default models; override with LLM_PRICES
+func DefaultPriceTable() PriceTable {
+	return PriceTable{
+		"claude-3-haiku-20240307":    {Input: 0.25, Output: 1.25},
+		"claude-3-5-sonnet-20241022": {Input: 3.00, Output: 15.00},
+		"gpt-4o-mini":                {Input: 0.15, Output: 0.60},
+		"gpt-4o":                     {Input: 2.50, Output: 10.00},
+		"text-embedding-ada-002":     {Input: 0.10},
+		"text-embedding-3-small":     {Input: 0.02},
+	}
+}
+
+// ParsePriceTable overlays a JSON object such as
+// {"gpt-4o-mini": {"input": 0.15, "output": 0.6}} onto the default prices
+func ParsePriceTable(raw string) (PriceTable, error) {
+	prices := DefaultPriceTable()
+	if raw == "" {
+		return prices, nil
+	}
+
+	var overrides PriceTable
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return prices, fmt.Errorf("invalid price table: %w", err)
+	}
+	for model, price := range overrides {
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+// Cost prices a call; unknown models (e.g. local ones) are free
+func (p PriceTable) Cost(model string, inputTokens, outputTokens int) float64 {
+	price := p[model]
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1_000_000
+}
+
+// UsageScope says who a provider call is made for and from which feature
+type UsageScope struct {
+	Recorder *UsageService
+	UserID   *uuid.UUID
+	Feature  string
+}
+
+type usageScopeKey struct{}
+
+// WithUsageScope attaches a scope to ctx; LLM clients record usage against it
+func WithUsageScope(ctx context.Context, scope UsageScope) context.Context {
+	return context.WithValue(ctx, usageScopeKey{}, scope)
+}
+
+func usageScopeFrom(ctx context.Context) (UsageScope, bool) {
+	if ctx == nil {
+		return UsageScope{}, false
+	}
+	scope, ok := ctx.Value(usageScopeKey{}).(UsageScope)
+	return scope, ok && scope.Recorder != nil
+}
+
+// Record stores one completion call's usage. Zero token counts are estimated
+// from the prompt and completion text with the tokenizer. Best-effort: errors are logged.
+func (scope UsageScope) Record(provider, model string, inputTokens, outputTokens int, prompt, completion string) {
+	scope.record(UsageKindCompletion, provider, model, inputTokens, outputTokens, prompt, completion)
+}
+
+// RecordEmbedding stores one embedding call's usage
+func (scope UsageScope) RecordEmbedding(provider, model string, inputTokens int, text string) {
+	scope.record(UsageKindEmbedding, provider, model, inputTokens, 0, text, "")
+}
+
+func (scope UsageScope) record(kind, provider, model string, inputTokens, outputTokens int, prompt, completion string) {
+	if scope.Recorder == nil {
+		return
+	}
+
+	estimated := false
+	if inputTokens == 0 && prompt != "" {
+		inputTokens = estimateTokens(prompt)
+		estimated = true
+	}
+	if outputTokens == 0 && completion != "" {
+		outputTokens = estimateTokens(completion)
+		estimated = true
+	}
+
+	usage := &LLMUsage{
+		ID:           uuid.New(),
+		UserID:       scope.UserID,
+		Feature:      scope.Feature,
+		Kind:         kind,
+		Provider:     provider,
+		Model:        model,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		Estimated:    estimated,
+		CostUSD:      scope.Recorder.prices.Cost(model, inputTokens, outputTokens),
+		CreatedAt:    time.Now(),
+	}
+
+	if err := scope.Recorder.db.Create(usage).Error; err != nil {
+		fmt.Printf("Failed to record LLM usage: %v\n", err)
+	}
+}
+
+// recordUsage records against the scope in ctx, if any
+func recordUsage(ctx context.Context, provider, model string, inputTokens, outputTokens int, prompt, completion string) {
+	if scope, ok := usageScopeFrom(ctx); ok {
+		scope.Record(provider, model, inputTokens, outputTokens, prompt, completion)
+	}
+}
+
+func estimateTokens(text string) int {
+	tokenizer := sharedTokenizer()
+	if tokenizer == nil {
+		return len(text)/4 + 1
+	}
+	return tokenizer.CountTokens(text)
+}
+
+// UsageService records and reports token usage and cost
+type UsageService struct {
+	db     *gorm.DB
+	prices PriceTable
+}
+
+// UsageBreakdown is usage grouped by one dimension (feature, model, user or day)
+type UsageBreakdown struct {
+	Key          string  `json:"key"`
+	Calls        int     `json:"calls"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// UsageReport summarizes usage since a point in time
+type UsageReport struct {
+	Since     time.Time        `json:"since"`
+	Total     UsageBreakdown   `json:"total"`
+	ByFeature []UsageBreakdown `json:"by_feature"`
+	ByModel   []UsageBreakdown `json:"by_model"`
+	ByDay     []UsageBreakdown `json:"by_day"`
+	ByUser    []UsageBreakdown `json:"by_user,omitempty"` // Admin report only
+}
+
+func NewUsageService(db *gorm.DB, prices PriceTable) *UsageService {
+	if prices == nil {
+		prices = DefaultPriceTable()
+	}
+	return &UsageService{
+		db:     db,
+		prices: prices,
+	}
+}
+
+// Scope returns a scope recording against this service
+func (s *UsageService) Scope(userID *uuid.UUID, feature string) UsageScope {
+	return UsageScope{Recorder: s, UserID: userID, Feature: feature}
+}
+
+// UserReport summarizes one user's usage
+func (s *UsageService) UserReport(userID uuid.UUID, since time.Time) (*UsageReport, error) {
+	return s.report(&userID, since)
+}
+
+// AdminReport summarizes usage across all users, including the top spenders
+func (s *UsageService) AdminReport(since time.Time, topUsers int) (*UsageReport, error) {
+	report, err := s.report(nil, since)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ByUser, err = s.breakdown(nil, since, "COALESCE(user_id::text, 'system')", "cost_usd DESC", topUsers)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (s *UsageService) report(userID *uuid.UUID, since time.Time) (*UsageReport, error) {
+	report := &UsageReport{Since: since}
+
+	totals, err := s.breakdown(userID, since, "'total'", "key", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(totals) > 0 {
+		report.Total = totals[0]
+	} else {
+		report.Total = UsageBreakdown{Key: "total"}
+	}
+
+	if report.ByFeature, err = s.breakdown(userID, since, "feature", "cost_usd DESC", 0); err != nil {
+		return nil, err
+	}
+	if report.ByModel, err = s.breakdown(userID, since, "model", "cost_usd DESC", 0); err != nil {
+		return nil, err
+	}
+	if report.ByDay, err = s.breakdown(userID, since, "TO_CHAR(DATE(created_at), 'YYYY-MM-DD')", "key", 0); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// breakdown groups usage by a SQL expression (never user input)
+func (s *UsageService) breakdown(userID *uuid.UUID, since time.Time, groupExpr, order string, limit int) ([]UsageBreakdown, error) {
+	query := s.db.Model(&LLMUsage{}).
+		Select(groupExpr+" AS key, COUNT(*) AS calls, COALESCE(SUM(input_tokens), 0) AS input_tokens, "+
+			"COALESCE(SUM(output_tokens), 0) AS output_tokens, COALESCE(SUM(cost_usd), 0) AS cost_usd").
+		Where("created_at >= ?", since).
+		Group("1").
+		Order(order)
+
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	breakdown := []UsageBreakdown{}
+	err := query.Scan(&breakdown).Error
+	return breakdown, err
+}
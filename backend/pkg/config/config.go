@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -32,6 +34,12 @@ type Config struct {
 	// Redis
 	RedisURL string
 
+	// Cache
+	CacheBackend       string        // "memory" or "redis"
+	EmbeddingCacheSize int           // LRU entries for query embeddings
+	AnswerCacheSize    int           // LRU entries for extracted answers (memory backend)
+	AnswerCacheTTL     time.Duration // How long extracted answers are reused
+
 	// NATS
 	NATSURL string
 
@@ -44,6 +52,38 @@ type Config struct {
 	// AI Services
 	OpenAIAPIKey string
 	ClaudeAPIKey string
+
+	// LLM providers and model roles. Role lists are comma-separated
+	// "provider:model" entries tried in order; providers are claude, openai, local.
+	OpenAIBaseURL       string
+	ClaudeBaseURL       string // Empty for https://api.anthropic.com/v1
+	LocalLLMBaseURL     string // OpenAI-compatible endpoint, e.g. Ollama
+	LocalLLMAPIKey      string
+	LLMExtractionModels string
+	LLMSynthesisModels  string
+	LLMTitlingModels    string
+	LLMRewritingModels  string
+
+	// Resilient transport shared by LLM and embedding calls
+	LLMMaxRetries     int // 0 disables retries; 3 when LLM_MAX_RETRIES is unset
+	LLMMaxConcurrency int // Requests in flight per provider
+
+	// Usage accounting
+	LLMPrices   string   // JSON price overrides in USD per million tokens
+	AdminEmails []string // Users allowed on /admin routes
+
+	// Quotas
+	QuotaPlans       string // JSON plan overrides, e.g. {"team": {"monthly_tokens": 50000000}}
+	QuotaDefaultPlan string // Plan for users without an assigned one
+
+	// Answer extraction
+	ExtractionConcurrency int           // Parallel LLM calls per QA request
+	ExtractionTimeout     time.Duration // Deadline before partial answers are returned
+	ExtractionMode        string        // "per_chunk" or "batched"
+	ExtractionBatchTokens int           // Prompt token budget per batched call
+
+	// Answer ranking
+	RankingWeights string // JSON weight overrides, e.g. {"retrieval": 0.5}
 }
 
 func Load() *Config {
@@ -70,6 +110,12 @@ func Load() *Config {
 		MinIOBucket:    getEnv("MINIO_BUCKET", "self-audio-files"),
 
 		RedisURL: getEnv("REDIS_URL", "localhost:6379"),
+
+		CacheBackend:       getEnv("CACHE_BACKEND", "memory"),
+		EmbeddingCacheSize: int(getEnvInt64("EMBEDDING_CACHE_SIZE", 1000)),
+		AnswerCacheSize:    int(getEnvInt64("ANSWER_CACHE_SIZE", 5000)),
+		AnswerCacheTTL:     time.Duration(getEnvInt64("ANSWER_CACHE_TTL_SECONDS", 24*60*60)) * time.Second,
+
 		NATSURL:  getEnv("NATS_URL", "localhost:4222"),
 
 		QdrantURL: getEnv("QDRANT_URL", "http://localhost:6333"),
@@ -78,6 +124,31 @@ func Load() *Config {
 
 		OpenAIAPIKey: getEnv("OPENAI_API_KEY", ""),
 		ClaudeAPIKey: getEnv("CLAUDE_API_KEY", ""),
+
+		OpenAIBaseURL:       getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1"),
+		ClaudeBaseURL:       getEnv("CLAUDE_BASE_URL", ""),
+		LocalLLMBaseURL:     getEnv("LOCAL_LLM_BASE_URL", ""),
+		LocalLLMAPIKey:      getEnv("LOCAL_LLM_API_KEY", ""),
+		LLMExtractionModels: getEnv("LLM_EXTRACTION_MODELS", "claude:claude-3-haiku-20240307,openai:gpt-4o-mini"),
+		LLMSynthesisModels:  getEnv("LLM_SYNTHESIS_MODELS", "claude:claude-3-haiku-20240307,openai:gpt-4o-mini"),
+		LLMTitlingModels:    getEnv("LLM_TITLING_MODELS", "claude:claude-3-haiku-20240307,openai:gpt-4o-mini"),
+		LLMRewritingModels:  getEnv("LLM_REWRITING_MODELS", "claude:claude-3-haiku-20240307,openai:gpt-4o-mini"),
+
+		LLMMaxRetries:     int(getEnvInt64("LLM_MAX_RETRIES", 3)),
+		LLMMaxConcurrency: int(getEnvInt64("LLM_MAX_CONCURRENCY", 8)),
+
+		LLMPrices:   getEnv("LLM_PRICES", ""),
+		AdminEmails: getEnvList("ADMIN_EMAILS"),
+
+		QuotaPlans:       getEnv("QUOTA_PLANS", ""),
+		QuotaDefaultPlan: getEnv("QUOTA_DEFAULT_PLAN", "free"),
+
+		ExtractionConcurrency: int(getEnvInt64("ANSWER_EXTRACTION_CONCURRENCY", 5)),
+		ExtractionTimeout:     time.Duration(getEnvInt64("ANSWER_EXTRACTION_TIMEOUT_SECONDS", 20)) * time.Second,
+		ExtractionMode:        getEnv("ANSWER_EXTRACTION_MODE", "per_chunk"),
+		ExtractionBatchTokens: int(getEnvInt64("ANSWER_EXTRACTION_BATCH_TOKENS", 6000)),
+
+		RankingWeights: getEnv("ANSWER_RANKING_WEIGHTS", ""),
 	}
 
 	// Validate required config
@@ -104,6 +175,17 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func (c *Config) IsDevelopment() bool {
 	return c.Env == "development"
 }
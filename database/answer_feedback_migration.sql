@@ -0,0 +1,52 @@
+-- Answer Feedback Migration - Ratings of chat answers and QA results
+-- Each row is a thumbs up/down (and optional correction) on a whole chat answer
+-- or on one source (chunk_id), stored with the query, answer, chunks, model and
+-- prompt versions so it can be exported as eval data. Repeated "down" ratings of
+-- a chunk for similar queries demote it in QA ranking.
+
+CREATE TABLE IF NOT EXISTS public.answer_feedback (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    conversation_id UUID REFERENCES public.chat_conversations(id) ON DELETE CASCADE,
+    message_id UUID REFERENCES public.chat_messages(id) ON DELETE CASCADE,
+    search_query_id UUID REFERENCES public.search_queries(id) ON DELETE CASCADE,
+    chunk_id TEXT NOT NULL DEFAULT '', -- Empty when the whole answer is rated
+    rating TEXT NOT NULL CHECK (rating IN ('up', 'down')),
+    correction TEXT,
+    query TEXT NOT NULL DEFAULT '',
+    answer TEXT NOT NULL DEFAULT '',
+    chunk_ids JSONB NOT NULL DEFAULT '[]', -- Chunks behind the rated answer
+    model TEXT NOT NULL DEFAULT '',
+    prompt_versions JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (message_id IS NOT NULL OR search_query_id IS NOT NULL)
+);
+
+-- One rating per user per answer or source; a new vote replaces the old one
+CREATE UNIQUE INDEX IF NOT EXISTS idx_answer_feedback_message
+    ON public.answer_feedback(user_id, message_id, chunk_id) WHERE message_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_answer_feedback_search
+    ON public.answer_feedback(user_id, search_query_id, chunk_id) WHERE search_query_id IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_answer_feedback_user_chunk ON public.answer_feedback(user_id, chunk_id);
+CREATE INDEX IF NOT EXISTS idx_answer_feedback_user_created ON public.answer_feedback(user_id, created_at);
+
+-- Model and prompt behind QA and chat retrievals, copied into feedback on their results
+ALTER TABLE public.search_queries ADD COLUMN IF NOT EXISTS model TEXT NOT NULL DEFAULT '';
+ALTER TABLE public.search_queries ADD COLUMN IF NOT EXISTS prompt_version TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_search_interactions_user_result ON public.search_interactions(user_id, result_id);
+
+-- Chat message sources are an object, {"answers": [...]}, which feedback and
+-- exports read back. Replies saved before this migration hold {}: their source
+-- list never decoded into the object, so readers rebuild it from the citations
+-- in metadata. The old array default no longer matches either shape.
+ALTER TABLE public.chat_messages ALTER COLUMN sources SET DEFAULT '{}';
+COMMENT ON COLUMN public.chat_messages.sources IS
+    'Answers an assistant reply drew on: {"answers": [...]}; {} for user messages and replies saved before answer feedback';
+
+ALTER TABLE public.answer_feedback ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "Users can access own answer feedback" ON public.answer_feedback
+    FOR ALL USING (true); -- Allow all access for local development
@@ -0,0 +1,45 @@
+-- Chat Branches Migration - Message trees for regenerated and edited messages
+-- Each message points at the message it follows. Regenerating a reply or
+-- editing a question adds a sibling under the same parent, and the
+-- conversation remembers the last message of the branch being shown.
+
+ALTER TABLE public.chat_messages
+    ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES public.chat_messages(id) ON DELETE CASCADE;
+ALTER TABLE public.chat_conversations
+    ADD COLUMN IF NOT EXISTS active_message_id UUID REFERENCES public.chat_messages(id) ON DELETE SET NULL;
+ALTER TABLE public.conversation_summaries
+    ADD COLUMN IF NOT EXISTS summarized_message_id UUID REFERENCES public.chat_messages(id) ON DELETE SET NULL;
+
+-- Existing conversations become a single branch in message order
+UPDATE public.chat_messages m
+SET parent_id = ordered.previous_id
+FROM (
+    SELECT id, LAG(id) OVER (PARTITION BY conversation_id ORDER BY created_at, id) AS previous_id
+    FROM public.chat_messages
+) ordered
+WHERE m.id = ordered.id
+  AND ordered.previous_id IS NOT NULL
+  AND NOT EXISTS (
+      SELECT 1 FROM public.chat_messages b
+      WHERE b.conversation_id = m.conversation_id AND b.parent_id IS NOT NULL
+  );
+
+UPDATE public.chat_conversations cc
+SET active_message_id = (
+    SELECT m.id FROM public.chat_messages m
+    WHERE m.conversation_id = cc.id
+    ORDER BY m.created_at DESC, m.id DESC
+    LIMIT 1
+)
+WHERE cc.active_message_id IS NULL;
+
+UPDATE public.conversation_summaries cs
+SET summarized_message_id = (
+    SELECT m.id FROM public.chat_messages m
+    WHERE m.conversation_id = cs.conversation_id AND m.created_at <= cs.summarized_through
+    ORDER BY m.created_at DESC, m.id DESC
+    LIMIT 1
+)
+WHERE cs.summarized_message_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_parent ON public.chat_messages(parent_id);
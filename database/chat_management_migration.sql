@@ -0,0 +1,13 @@
+-- Chat Management Migration - Pinned and archived conversations, message search
+-- Conversations are listed pinned first, then by last activity, and paged by
+-- (pinned, last_activity, id) so the listing index covers the cursor.
+
+ALTER TABLE public.chat_conversations ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE public.chat_conversations ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE; -- NULL while active
+
+CREATE INDEX IF NOT EXISTS idx_chat_conversations_listing
+    ON public.chat_conversations(user_id, pinned DESC, last_activity DESC, id DESC);
+
+-- Full-text search over chat messages
+CREATE INDEX IF NOT EXISTS idx_chat_messages_content_fts
+    ON public.chat_messages USING gin(to_tsvector('english', content));
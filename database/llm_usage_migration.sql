@@ -0,0 +1,26 @@
+-- LLM Usage Migration - Token usage and cost per provider call
+-- Feeds /users/me/usage and /admin/usage (totals by feature, model, day and user)
+
+-- One row per LLM or embedding call
+CREATE TABLE IF NOT EXISTS public.llm_usage (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES public.users(id) ON DELETE SET NULL, -- NULL for system calls
+    feature TEXT NOT NULL CHECK (feature IN ('chat', 'qa', 'search', 'ingestion', 'saved_search')),
+    provider TEXT NOT NULL, -- 'claude', 'openai', 'local', ...
+    model TEXT NOT NULL,
+    input_tokens INTEGER NOT NULL DEFAULT 0,
+    output_tokens INTEGER NOT NULL DEFAULT 0,
+    estimated BOOLEAN NOT NULL DEFAULT FALSE, -- Counted locally because the provider reported no usage
+    cost_usd NUMERIC(12, 6) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Performance indexes for reporting windows
+CREATE INDEX IF NOT EXISTS idx_llm_usage_user_created ON public.llm_usage(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_llm_usage_created ON public.llm_usage(created_at DESC);
+
+-- Row Level Security
+ALTER TABLE public.llm_usage ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "Users can access own LLM usage" ON public.llm_usage
+    FOR ALL USING (true); -- Allow all access for local development
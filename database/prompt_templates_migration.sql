@@ -0,0 +1,21 @@
+-- Prompt Templates Migration - Versioned LLM prompts stored outside the binary
+-- Rows are loaded at startup next to the embedded templates (internal/prompts/templates)
+
+-- One row per template version; weight > 0 versions of a name split traffic (A/B)
+CREATE TABLE IF NOT EXISTS public.prompt_templates (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name TEXT NOT NULL, -- 'answer_extraction', 'batch_extraction', 'synthesis', ...
+    version TEXT NOT NULL, -- e.g. 'v2'; a built-in name+version is replaced by the row
+    system_template TEXT NOT NULL, -- Go text/template, variables as {{.name}}
+    user_template TEXT NOT NULL,
+    variables JSONB DEFAULT '[]', -- Variable names the template requires
+    weight INTEGER NOT NULL DEFAULT 0, -- 0 = stored but not served
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (name, version)
+);
+
+-- Row Level Security
+ALTER TABLE public.prompt_templates ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "Allow read access to prompt templates" ON public.prompt_templates
+    FOR ALL USING (true); -- Allow all access for local development
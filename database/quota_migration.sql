@@ -0,0 +1,32 @@
+-- Quota Migration - Per-user plans and limit overrides for AI features
+-- Limits are enforced from llm_usage (tokens) and search_queries (daily requests)
+
+-- Separate LLM completions from embeddings so each has its own budget
+ALTER TABLE public.llm_usage ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'completion'
+    CHECK (kind IN ('completion', 'embedding'));
+
+-- Rows recorded before the column existed all defaulted to 'completion'. Embedding
+-- calls were the ones against an embedding model with no output tokens.
+UPDATE public.llm_usage SET kind = 'embedding'
+WHERE kind = 'completion' AND output_tokens = 0 AND model ILIKE '%embedding%';
+
+-- Plan assignment; NULL limits fall back to the plan's (configured via QUOTA_PLANS)
+CREATE TABLE IF NOT EXISTS public.user_quotas (
+    user_id UUID PRIMARY KEY REFERENCES public.users(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL DEFAULT 'free',
+    monthly_tokens BIGINT,
+    monthly_embedding_tokens BIGINT,
+    daily_qa_requests BIGINT,
+    daily_chat_requests BIGINT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Performance indexes for quota windows
+CREATE INDEX IF NOT EXISTS idx_llm_usage_user_kind_created ON public.llm_usage(user_id, kind, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_search_queries_user_feature_created ON public.search_queries(user_id, feature, created_at DESC);
+
+-- Row Level Security
+ALTER TABLE public.user_quotas ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "Users can access own quota" ON public.user_quotas
+    FOR ALL USING (true); -- Allow all access for local development
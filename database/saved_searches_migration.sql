@@ -0,0 +1,40 @@
+-- Saved Searches Migration - Notify users when new content matches a saved query
+-- Matches are delivered as insights (and pushed over the insights WebSocket)
+
+-- Insights table (from schema.sql, not yet part of modern_schema.sql)
+CREATE TABLE IF NOT EXISTS public.insights (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL, -- repeated_mention, action_item, saved_search_match, etc.
+    title VARCHAR(500) NOT NULL,
+    description TEXT,
+    data JSONB NOT NULL, -- structured data about the insight
+    priority INTEGER DEFAULT 50, -- 1-100, higher = more important
+    status VARCHAR(20) DEFAULT 'new', -- new, acknowledged, dismissed
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Saved queries evaluated against newly ingested chunks
+CREATE TABLE IF NOT EXISTS public.saved_searches (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    query TEXT NOT NULL,
+    content_types JSONB DEFAULT '[]', -- Empty = match any content type
+    threshold DECIMAL(3, 2) NOT NULL DEFAULT 0.80, -- Minimum match score (0.00 to 1.00)
+    is_active BOOLEAN DEFAULT true,
+    last_matched_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_insights_user_id ON public.insights(user_id);
+CREATE INDEX IF NOT EXISTS idx_insights_status ON public.insights(status);
+CREATE INDEX IF NOT EXISTS idx_saved_searches_user_active ON public.saved_searches(user_id) WHERE is_active;
+
+ALTER TABLE public.saved_searches ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "Users can access own saved searches" ON public.saved_searches
+    FOR ALL USING (true); -- Allow all access for local development
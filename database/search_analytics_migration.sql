@@ -0,0 +1,41 @@
+-- Search Analytics Migration - Record queries and result interactions
+-- Feeds the /search/analytics endpoint (top queries, zero-result queries, latency per strategy)
+
+-- One row per Search / QASearch / chat retrieval
+CREATE TABLE IF NOT EXISTS public.search_queries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    query TEXT NOT NULL,
+    feature TEXT NOT NULL CHECK (feature IN ('search', 'qa', 'chat')),
+    strategy TEXT NOT NULL, -- 'hybrid', 'qa-hybrid', 'simple', ...
+    result_count INTEGER NOT NULL DEFAULT 0,
+    top_result_ids JSONB DEFAULT '[]', -- Chunk IDs of the top results, in rank order
+    latency_ms INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Clicks and explicit feedback on a result of a recorded query
+CREATE TABLE IF NOT EXISTS public.search_interactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    search_query_id UUID NOT NULL REFERENCES public.search_queries(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    result_id TEXT NOT NULL, -- Chunk ID the user interacted with
+    position INTEGER, -- 1-based rank of the result when shown
+    action TEXT NOT NULL CHECK (action IN ('click', 'helpful', 'not_helpful')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Performance indexes for analytics windows
+CREATE INDEX IF NOT EXISTS idx_search_queries_user_created ON public.search_queries(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_search_queries_strategy ON public.search_queries(strategy);
+CREATE INDEX IF NOT EXISTS idx_search_interactions_query ON public.search_interactions(search_query_id);
+
+-- Row Level Security
+ALTER TABLE public.search_queries ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.search_interactions ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "Users can access own search queries" ON public.search_queries
+    FOR ALL USING (true); -- Allow all access for local development
+
+CREATE POLICY "Users can access own search interactions" ON public.search_interactions
+    FOR ALL USING (true); -- Allow all access for local development